@@ -0,0 +1,220 @@
+// Command apikeys issues, lists, rotates and revokes the API keys merchants authenticate with.
+//
+// Usage:
+//
+//	apikeys create -name NAME -users ID,... [-scopes deposit:create,withdrawal:create,transactions:read,accounts:read] [-expires-in DURATION]
+//	apikeys list
+//	apikeys bind   -id N -users ID,...
+//	apikeys unbind -id N -users ID,...
+//	apikeys rotate -id N [-overlap DURATION] [-scopes ...] [-expires-in DURATION]
+//	apikeys revoke -id N
+//
+// A key may only act on behalf of the users it is bound to; rotating a key carries its users over to the replacement.
+// The database is located by the DATABASE_URL environment variable.
+// The plain text of a key is only ever printed by create and rotate; it cannot be recovered afterwards.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/api/middlewares"
+	"github.com/ercross/payment_gateways/internal/services"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: apikeys <command> [flags]
+
+commands:
+  create  issue a new API key
+  list    list API keys
+  bind    let an API key act on behalf of more users
+  unbind  stop an API key from acting on behalf of users
+  rotate  replace an API key, keeping the old one valid during an overlap period
+  revoke  disable an API key immediately
+`
+
+func main() {
+	if err := run(os.Args, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) < 2 {
+		return errors.New(usage)
+	}
+	command := args[1]
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	id := flags.Int("id", 0, "API key ID")
+	name := flags.String("name", "", "merchant or service the key is issued to")
+	scopes := flags.String("scopes", "", "comma separated scopes granted to the key (default: all on create, unchanged on rotate)")
+	expiresIn := flags.Duration("expires-in", 0, "lifetime of the key (default: no expiry)")
+	overlap := flags.Duration("overlap", 24*time.Hour, "how long the rotated key keeps working")
+	users := flags.String("users", "", "comma separated IDs of the users the key acts on behalf of")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+
+	key := db.APIKey{Name: *name}
+	if *scopes != "" {
+		key.Scopes = strings.Split(*scopes, ",")
+		for _, scope := range key.Scopes {
+			if !slices.Contains(middlewares.Scopes, scope) {
+				return fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(middlewares.Scopes, ", "))
+			}
+		}
+	}
+	if *users != "" {
+		for _, user := range strings.Split(*users, ",") {
+			userID, err := strconv.Atoi(user)
+			if err != nil || userID <= 0 {
+				return fmt.Errorf("invalid user ID %q", user)
+			}
+			key.UserIDs = append(key.UserIDs, userID)
+		}
+	}
+	if *expiresIn > 0 {
+		expiresAt := time.Now().Add(*expiresIn)
+		key.ExpiresAt = &expiresAt
+	}
+
+	repo, err := db.New(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		return fmt.Errorf("error initialising database: %w", err)
+	}
+
+	switch command {
+	case "create":
+		if key.Name == "" {
+			return errors.New("create requires -name")
+		}
+		if key.UserIDs == nil {
+			return errors.New("create requires -users")
+		}
+		if key.Scopes == nil {
+			key.Scopes = middlewares.Scopes
+		}
+		return create(repo, key, out)
+	case "list":
+		return list(repo, out)
+	case "bind", "unbind":
+		if *id == 0 || key.UserIDs == nil {
+			return fmt.Errorf("%s requires -id and -users", command)
+		}
+		if command == "unbind" {
+			if err = repo.UnbindAPIKeyUsers(*id, key.UserIDs); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "API key %d no longer acts on behalf of users %s\n", *id, *users)
+			return nil
+		}
+		if err = repo.BindAPIKeyUsers(*id, key.UserIDs); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "API key %d acts on behalf of users %s\n", *id, *users)
+		return nil
+	case "rotate":
+		if *id == 0 {
+			return errors.New("rotate requires -id")
+		}
+		return rotate(repo, *id, key, *overlap, out)
+	case "revoke":
+		if *id == 0 {
+			return errors.New("revoke requires -id")
+		}
+		if err = repo.RevokeAPIKey(*id); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "API key %d revoked\n", *id)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", command, usage)
+	}
+}
+
+func create(repo *db.DB, key db.APIKey, out io.Writer) error {
+	plain, err := generate(&key)
+	if err != nil {
+		return err
+	}
+	id, err := repo.CreateAPIKey(key)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "API key %d created for %s\n%s\n", id, key.Name, plain)
+	return nil
+}
+
+func rotate(repo *db.DB, id int, replacement db.APIKey, overlap time.Duration, out io.Writer) error {
+	plain, err := generate(&replacement)
+	if err != nil {
+		return err
+	}
+	newID, err := repo.RotateAPIKey(id, replacement, overlap)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "API key %d replaces %d, which expires in %s\n%s\n", newID, id, overlap, plain)
+	return nil
+}
+
+func list(repo *db.DB, out io.Writer) error {
+	keys, err := repo.ListAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tUSERS\tSTATUS\tEXPIRES AT\tLAST USED AT")
+	for _, key := range keys {
+		status := "active"
+		switch {
+		case key.RevokedAt != nil:
+			status = "revoked"
+		case !key.IsActive():
+			status = "expired"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","),
+			formatUsers(key.UserIDs), status, formatTime(key.ExpiresAt), formatTime(key.LastUsedAt))
+	}
+	return w.Flush()
+}
+
+// generate sets a new prefix and hash on key and returns the plain text key
+func generate(key *db.APIKey) (string, error) {
+	plain, prefix, hash, err := services.GenerateAPIKey()
+	if err != nil {
+		return "", fmt.Errorf("error generating API key: %w", err)
+	}
+	key.Prefix = prefix
+	key.Hash = hash
+	return plain, nil
+}
+
+func formatUsers(userIDs []int) string {
+	if len(userIDs) == 0 {
+		return "-"
+	}
+	users := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		users[i] = strconv.Itoa(userID)
+	}
+	return strings.Join(users, ",")
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,252 @@
+// Command deadletters lists, inspects and replays events that could not be published to Kafka.
+//
+// Usage:
+//
+//	deadletters list    [-transaction-id N] [-from RFC3339] [-to RFC3339] [-all] [-limit N]
+//	deadletters inspect -id N
+//	deadletters replay  [-id N] [-transaction-id N] [-from RFC3339] [-to RFC3339] [-limit N]
+//
+// Settings are read from the DATABASE_URL, ENCRYPTION_KEYS_FILE, ENCRYPTION_KEYS, ENCRYPTION_KEY,
+// LOCAL_KMS_KEYS_FILE, KMS_URL, KMS_KEY_ID, KMS_TOKEN, KAFKA_BROKER_URL, KAFKA_TOPICS and SCHEMA_REGISTRY_FILE environment variables.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/events"
+	"github.com/ercross/payment_gateways/internal/kafka"
+	"github.com/ercross/payment_gateways/internal/services"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: deadletters <command> [flags]
+
+commands:
+  list     list dead letter events
+  inspect  show a dead letter event, decrypting its sensitive fields
+  replay   publish dead letter events to Kafka again
+`
+
+func main() {
+	if err := run(context.Background(), os.Args, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// options are the command and flags deadletters was run with
+type options struct {
+	command string
+	filter  db.DeadLetterFilter
+	limit   int
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	opts, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+
+	repo, err := db.New(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		return fmt.Errorf("error initialising database: %w", err)
+	}
+	keyring, err := services.LoadKeyring(os.Getenv("ENCRYPTION_KEYS_FILE"), os.Getenv("ENCRYPTION_KEYS"), os.Getenv("ENCRYPTION_KEY"))
+	if err == nil {
+		keyring, err = services.WithBuiltinKey(keyring)
+	}
+	if err != nil {
+		return fmt.Errorf("error loading encryption keys: %w", err)
+	}
+	services.SetKeyProvider(keyring)
+
+	kms, err := services.LoadKMS(os.Getenv("LOCAL_KMS_KEYS_FILE"), os.Getenv("KMS_URL"), os.Getenv("KMS_KEY_ID"), os.Getenv("KMS_TOKEN"))
+	if err != nil {
+		return fmt.Errorf("error initialising KMS: %w", err)
+	}
+	if kms != nil {
+		services.SetKMS(kms)
+	}
+
+	switch opts.command {
+	case "list":
+		return list(ctx, repo, opts.filter, opts.limit, out)
+	case "inspect":
+		return inspect(ctx, repo, opts.filter, out)
+	default:
+		return replay(ctx, repo, opts.filter, opts.limit, out)
+	}
+}
+
+// parseArgs validates the command line before anything is connected to
+func parseArgs(args []string) (options, error) {
+	if len(args) < 2 {
+		return options{}, errors.New(usage)
+	}
+	opts := options{command: args[1]}
+	switch opts.command {
+	case "list", "inspect", "replay":
+	default:
+		return opts, fmt.Errorf("unknown command %q\n%s", opts.command, usage)
+	}
+
+	flags := flag.NewFlagSet(opts.command, flag.ContinueOnError)
+	id := flags.Int64("id", 0, "dead letter event ID")
+	transactionID := flags.Int("transaction-id", 0, "only events of this transaction")
+	from := flags.String("from", "", "only events that failed at or after this time (RFC3339)")
+	to := flags.String("to", "", "only events that failed before this time (RFC3339)")
+	all := flags.Bool("all", false, "include events that have already been replayed")
+	flags.IntVar(&opts.limit, "limit", 100, "maximum number of events")
+	if err := flags.Parse(args[2:]); err != nil {
+		return opts, err
+	}
+
+	opts.filter = db.DeadLetterFilter{ID: *id, TransactionID: *transactionID, IncludeReplayed: *all}
+	var err error
+	if opts.filter.From, err = parseTime(*from); err != nil {
+		return opts, fmt.Errorf("invalid -from: %w", err)
+	}
+	if opts.filter.To, err = parseTime(*to); err != nil {
+		return opts, fmt.Errorf("invalid -to: %w", err)
+	}
+	if !opts.filter.From.IsZero() && !opts.filter.To.IsZero() && !opts.filter.From.Before(opts.filter.To) {
+		return opts, errors.New("-from must be before -to")
+	}
+	if opts.limit <= 0 {
+		return opts, errors.New("-limit must be positive")
+	}
+
+	switch opts.command {
+	case "inspect":
+		if *id == 0 {
+			return opts, errors.New("inspect requires -id")
+		}
+		opts.filter.IncludeReplayed = true
+	case "replay":
+		if *all {
+			return opts, errors.New("replay never publishes replayed events again; -all only applies to list")
+		}
+	}
+	return opts, nil
+}
+
+func list(ctx context.Context, repo *db.DB, filter db.DeadLetterFilter, limit int, out io.Writer) error {
+	deadLetters, err := repo.ListDeadLetterEvents(ctx, filter, limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTRANSACTION\tTYPE\tATTEMPTS\tFAILED AT\tREPLAYED AT\tLAST ERROR")
+	for _, deadLetter := range deadLetters {
+		eventType := "?"
+		if event, err := events.Unmarshal(deadLetter.Payload); err == nil {
+			eventType = string(event.Type)
+		}
+		replayedAt := "-"
+		if deadLetter.ReplayedAt != nil {
+			replayedAt = deadLetter.ReplayedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\t%s\t%s\n", deadLetter.ID, deadLetter.TransactionID, eventType,
+			deadLetter.Attempts, deadLetter.FailedAt.Format(time.RFC3339), replayedAt, deadLetter.LastError)
+	}
+	return w.Flush()
+}
+
+func inspect(ctx context.Context, repo *db.DB, filter db.DeadLetterFilter, out io.Writer) error {
+	deadLetters, err := repo.ListDeadLetterEvents(ctx, filter, 1)
+	if err != nil {
+		return err
+	}
+	if len(deadLetters) == 0 {
+		return fmt.Errorf("dead letter event %d not found", filter.ID)
+	}
+	deadLetter := deadLetters[0]
+
+	fmt.Fprintf(out, "ID:              %d\n", deadLetter.ID)
+	fmt.Fprintf(out, "Outbox event ID: %d\n", deadLetter.OutboxEventID)
+	fmt.Fprintf(out, "Transaction ID:  %d\n", deadLetter.TransactionID)
+	fmt.Fprintf(out, "Attempts:        %d\n", deadLetter.Attempts)
+	fmt.Fprintf(out, "Recorded at:     %s\n", deadLetter.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(out, "Failed at:       %s\n", deadLetter.FailedAt.Format(time.RFC3339))
+	if deadLetter.ReplayedAt != nil {
+		fmt.Fprintf(out, "Replayed at:     %s\n", deadLetter.ReplayedAt.Format(time.RFC3339))
+	}
+	if deadLetter.AbandonedAt != nil {
+		fmt.Fprintf(out, "Abandoned at:    %s\n", deadLetter.AbandonedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(out, "Last error:      %s\n", deadLetter.LastError)
+
+	event, err := events.Unmarshal(deadLetter.Payload)
+	if err != nil {
+		return err
+	}
+	if err = printJSON(out, "Event", event); err != nil {
+		return err
+	}
+
+	data, err := event.TransactionData()
+	if err != nil {
+		return err
+	}
+	sensitive, err := data.UnmaskSensitive()
+	if err != nil {
+		fmt.Fprintf(out, "\nSensitive data could not be decrypted: %s\n", err)
+		return nil
+	}
+	return printJSON(out, "Sensitive data", sensitive)
+}
+
+func replay(ctx context.Context, repo *db.DB, filter db.DeadLetterFilter, limit int, out io.Writer) error {
+	registry, err := events.NewFileRegistry(getenv("SCHEMA_REGISTRY_FILE", "schema_registry.json"))
+	if err != nil {
+		return fmt.Errorf("error initialising schema registry: %w", err)
+	}
+	topicConfigs, err := kafka.ParseTopicConfigs(getenv("KAFKA_TOPICS", "transactions.json:json"))
+	if err != nil {
+		return fmt.Errorf("invalid kafka topics: %w", err)
+	}
+	publisher, err := kafka.NewProducer(os.Getenv("KAFKA_BROKER_URL"), topicConfigs, registry)
+	if err != nil {
+		return fmt.Errorf("error initialising kafka producer: %w", err)
+	}
+	defer publisher.Close()
+
+	// replays asked for explicitly neither delay nor abandon the automatic ones
+	replayed, err := kafka.ReplayDeadLetters(ctx, repo, publisher, filter, limit, db.OutboxRetryPolicy{})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d event(s) replayed\n", replayed)
+	return nil
+}
+
+func printJSON(out io.Writer, title string, v any) error {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "\n%s:\n%s\n", title, raw)
+	return err
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ercross/payment_gateways/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	opts, err := parseArgs([]string{"deadletters", "list", "-transaction-id", "7", "-from", "2024-01-01T00:00:00Z",
+		"-to", "2024-02-01T00:00:00Z", "-all", "-limit", "20"})
+	require.NoError(t, err)
+	assert.Equal(t, options{
+		command: "list",
+		filter: db.DeadLetterFilter{
+			TransactionID:   7,
+			From:            time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:              time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			IncludeReplayed: true,
+		},
+		limit: 20,
+	}, opts)
+
+	opts, err = parseArgs([]string{"deadletters", "replay"})
+	require.NoError(t, err)
+	assert.Equal(t, options{command: "replay", limit: 100}, opts)
+
+	// inspecting an event shows it whether or not it has been replayed
+	opts, err = parseArgs([]string{"deadletters", "inspect", "-id", "3"})
+	require.NoError(t, err)
+	assert.Equal(t, db.DeadLetterFilter{ID: 3, IncludeReplayed: true}, opts.filter)
+}
+
+func TestParseArgs_Invalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"deadletters"},
+		{"deadletters", "purge"},
+		{"deadletters", "list", "-unknown"},
+		{"deadletters", "list", "-from", "yesterday"},
+		{"deadletters", "list", "-to", "2024-02-01"},
+		{"deadletters", "list", "-from", "2024-02-01T00:00:00Z", "-to", "2024-01-01T00:00:00Z"},
+		{"deadletters", "list", "-limit", "0"},
+		{"deadletters", "inspect"},
+		{"deadletters", "replay", "-all"},
+	} {
+		_, err := parseArgs(args)
+		assert.Error(t, err, args)
+	}
+}
@@ -7,97 +7,399 @@ import (
 	"github.com/ercross/payment_gateways/db"
 	"github.com/ercross/payment_gateways/internal/api"
 	"github.com/ercross/payment_gateways/internal/api/middlewares"
+	"github.com/ercross/payment_gateways/internal/certs"
+	"github.com/ercross/payment_gateways/internal/config"
+	"github.com/ercross/payment_gateways/internal/events"
+	"github.com/ercross/payment_gateways/internal/health"
 	"github.com/ercross/payment_gateways/internal/kafka"
 	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/mfa"
+	"github.com/ercross/payment_gateways/internal/payment_gateways"
 	cache "github.com/ercross/payment_gateways/internal/redis"
 	"github.com/ercross/payment_gateways/internal/services"
-	"strconv"
+	"github.com/ercross/payment_gateways/internal/settings"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	"github.com/ercross/payment_gateways/internal/vault"
+	"io"
 
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 )
 
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
-	if err := run(ctx, os.Args); err != nil {
+	err := run(ctx, os.Args)
+	stop()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 }
 
 func run(ctx context.Context, args []string) error {
+	cfg, err := config.Load(args, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+
+	logLevel, err := logger.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		return fmt.Errorf("error parsing log level: %w", err)
+	}
+	logDestination, err := newLogDestination(cfg.Logging)
+	if err != nil {
+		return fmt.Errorf("error initialising log destinations: %w", err)
+	}
+
 	loggerConfig := &logger.Config{
-		Level:       logger.INFO,
-		Destination: new(logger.ConsoleDestination),
+		Level:       logLevel,
+		Destination: logDestination,
 	}
 	log, err := logger.NewLogger(loggerConfig)
 	if err != nil {
+		logDestination.Close()
 		return fmt.Errorf("error initialising logger: %w", err)
 	}
 
+	// resources are closed in reverse order on exit: by shutdown, within its deadline, once the API has served
+	resources := []resource{{name: "log destination", close: func(context.Context) error {
+		log.Flush()
+		return logDestination.Close()
+	}}}
+	defer func() {
+		closeResources(context.Background(), log, resources)
+	}()
+
 	log.Info("logger initialized...")
 
-	dsn := os.Getenv("DATABASE_URL")
-	repo, err := db.New(dsn)
+	tracingConfig := tracing.Config{
+		ServiceName:  cfg.Tracing.ServiceName,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		SampleRatio:  cfg.Tracing.SampleRatio,
+	}
+	shutdownTracing, err := tracing.Setup(ctx, tracingConfig)
+	if err != nil {
+		return fmt.Errorf("error initialising tracing: %w", err)
+	}
+	resources = append(resources, resource{name: "tracing", close: shutdownTracing})
+
+	repo, err := db.New(cfg.Database.URL)
 	if err != nil {
 		return fmt.Errorf("error initialising database: %w", err)
 	}
+	resources = append(resources, resource{name: "database", close: closeWith(repo), keepForWorkers: true})
 	log.Info("Database initialized...")
 
-	migrationFilesDir := os.Getenv("MIGRATIONS")
-
-	if err = repo.Migrate(migrationFilesDir, dsn); err != nil {
+	if err = repo.Migrate(cfg.Database.MigrationsDir, cfg.Database.URL); err != nil {
 		return fmt.Errorf("error migrating db schema: %w", err)
 	}
 	log.Info("Database schema files migrated successfully...")
 
-	redisAddr := os.Getenv("REDIS_ADDR")
-	redisPassword := os.Getenv("REDIS_PASSWORD")
-	redisDB, err := strconv.Atoi(os.Getenv("REDIS_DB"))
-	if err != nil {
-		return fmt.Errorf("invalid redis db value: %w", err)
-	}
-	redis, err := cache.New(redisAddr, redisPassword, redisDB)
+	redis, err := cache.New(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB, cfg.Redis.LockTTL)
 	if err != nil {
 		return fmt.Errorf("error initialising redis: %w", err)
 	}
+	resources = append(resources, resource{name: "redis", close: closeWith(redis)})
 	log.Info("Redis initialised...")
 
-	dstrRL := middlewares.NewDistributedRateLimiter(redis.Client(), 3, time.Minute*1)
+	dstrRL := middlewares.NewDistributedRateLimiter(redis.Client(), "payments", cfg.RateLimit.Requests, cfg.RateLimit.Window)
+	mfaRL := middlewares.NewDistributedRateLimiter(redis.Client(), "mfa", cfg.MFALimit.Requests, cfg.MFALimit.Window)
+
+	rateLimit := settings.RateLimit{Requests: cfg.RateLimit.Requests, Window: cfg.RateLimit.Window}
+	store := settings.NewStore(settings.Settings{RateLimit: rateLimit})
+	settingsReloader, err := settings.NewReloader(cfg.Dynamic.File, rateLimit, store, redis, cfg.Dynamic.ReloadInterval, log)
+	if err != nil {
+		return fmt.Errorf("error loading dynamic settings: %w", err)
+	}
+	store.OnChange(func(current *settings.Settings) {
+		dstrRL.SetLimit(current.RateLimit.Requests, current.RateLimit.Window)
+	})
+
+	schemaRegistry, err := events.NewFileRegistry(cfg.Kafka.SchemaRegistryFile)
+	if err != nil {
+		return fmt.Errorf("error initialising schema registry: %w", err)
+	}
 
-	kafkaBrokerAddr := os.Getenv("KAFKA_BROKER_URL")
-	publisher := kafka.NewProducer(kafkaBrokerAddr)
+	topicConfigs, err := kafka.ParseTopicConfigs(cfg.Kafka.Topics)
+	if err != nil {
+		return fmt.Errorf("invalid kafka topics: %w", err)
+	}
+
+	keyring, err := services.LoadKeyring(cfg.Encryption.KeysFile, cfg.Encryption.Keys, cfg.Encryption.Key)
+	if err != nil {
+		return fmt.Errorf("error loading encryption keys: %w", err)
+	}
+	if cfg.Encryption.BuiltinKey {
+		if keyring, err = services.WithBuiltinKey(keyring); err != nil {
+			return fmt.Errorf("error loading encryption keys: %w", err)
+		}
+		log.Warn("the encryption key built into earlier versions is enabled to decrypt existing data; " +
+			"run cmd/reencrypt, then unset ENCRYPTION_BUILTIN_KEY once Kafka messages from before the upgrade have expired")
+	}
+	services.SetKeyProvider(keyring)
+
+	kms, err := services.LoadKMS(cfg.Encryption.LocalKMSKeysFile, cfg.Encryption.KMSURL, cfg.Encryption.KMSKeyID, cfg.Encryption.KMSToken)
+	if err != nil {
+		return fmt.Errorf("error initialising KMS: %w", err)
+	}
+	if kms != nil {
+		services.SetKMS(kms)
+	}
+
+	publisher, err := kafka.NewProducer(cfg.Kafka.BrokerURL, topicConfigs, schemaRegistry)
+	if err != nil {
+		return fmt.Errorf("error initialising kafka producer: %w", err)
+	}
+	resources = append(resources, resource{name: "kafka producer", close: closeWith(publisher)})
 	log.Info("Kafka initialised...")
-	services.InitEncryptionKey("W-Dm='U]Pu@xk]GM")
-	srv := api.NewServer(repo, log, publisher, redis, dstrRL, os.Getenv("API_URL"))
+	jwtConfig := middlewares.JWTConfig{
+		HMACSecret:   []byte(cfg.JWT.HMACSecret),
+		JWKSURL:      cfg.JWT.JWKSURL,
+		JWKSCacheTTL: time.Minute * 10,
+		Issuer:       cfg.JWT.Issuer,
+		Audience:     cfg.JWT.Audience,
+		Leeway:       time.Second * 30,
+	}
+	if len(cfg.JWT.Algorithms) > 0 {
+		jwtConfig.Algorithms = cfg.JWT.Algorithms
+	} else if len(jwtConfig.HMACSecret) > 0 {
+		jwtConfig.Algorithms = []string{"HS256"}
+	} else {
+		jwtConfig.Algorithms = []string{"RS256", "EdDSA"}
+	}
+	authenticator, err := middlewares.NewJWTAuthenticator(jwtConfig)
+	if err != nil {
+		return fmt.Errorf("error initialising authenticator: %w", err)
+	}
+
+	apiKeys := middlewares.NewAPIKeyAuthenticator(repo, log)
+
+	totp := mfa.NewTOTP(repo, mfa.DefaultConfig)
+	authorizer := middlewares.NewAuthorizer(repo, log)
+
+	tokens := vault.NewVault(repo, log)
+
+	var gatewayIdentifier *middlewares.ClientCertIdentifier
+	if path := cfg.Server.TLS.ClientCertsFile; path != "" {
+		if gatewayIdentifier, err = middlewares.LoadClientCertIdentifier(path, log); err != nil {
+			return fmt.Errorf("error initialising callback client certificates: %w", err)
+		}
+	}
+
+	checker := health.NewChecker(
+		health.Dependency{Name: "postgres", Check: repo.Ping, Timeout: 2 * time.Second},
+		health.Dependency{Name: "redis", Check: redis.Ping, Timeout: time.Second},
+		health.Dependency{Name: "kafka", Check: publisher.Ping, Timeout: 2 * time.Second},
+		health.Dependency{Name: "payment_gateways", Check: gateways.AvailabilityCheck(repo), Timeout: 3 * time.Second},
+	)
+
+	srv := api.NewServer(repo, log, redis, dstrRL, mfaRL, authenticator, apiKeys, totp, tokens, tokens, authorizer, gatewayIdentifier, store, cfg)
+
+	shutdownTimeout := cfg.Server.ShutdownTimeout
+
+	// background workers outlive ctx, so that they are only stopped once in-flight requests have been drained
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	var workers sync.WaitGroup
+	startWorker := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workersCtx)
+		}()
+	}
+
+	relay := kafka.NewOutboxRelay(repo, publisher, log, time.Second*1, 100, db.OutboxRetryPolicy{
+		MaxAttempts: cfg.Kafka.OutboxMaxAttempts,
+		BaseDelay:   cfg.Kafka.OutboxRetryDelay,
+		MaxDelay:    cfg.Kafka.OutboxMaxRetryDelay,
+	})
+	startWorker(relay.Run)
+	log.Info("Outbox relay started...")
+
+	deadLetterRepublisher := kafka.NewDeadLetterRepublisher(repo, publisher, log, time.Minute*5, 100, db.OutboxRetryPolicy{
+		MaxAttempts: cfg.Kafka.DeadLetterMaxAttempts,
+		BaseDelay:   cfg.Kafka.DeadLetterRetryDelay,
+		MaxDelay:    cfg.Kafka.DeadLetterMaxRetryDelay,
+	})
+	startWorker(deadLetterRepublisher.Run)
+	startWorker(settingsReloader.Run)
 
 	httpServer := &http.Server{
-		Addr:    net.JoinHostPort("", os.Getenv("API_PORT")),
+		Addr:    net.JoinHostPort("", cfg.Server.Port),
 		Handler: srv,
 	}
-	go func() {
+	metricsServer := &http.Server{
+		Addr:    net.JoinHostPort("", cfg.Server.MetricsPort),
+		Handler: api.NewMetricsServer(checker),
+	}
+
+	if tlsConfig := cfg.Server.TLS; tlsConfig.CertFile != "" {
+		reloader, err := certs.NewReloader(certs.Config{
+			CertFile:          tlsConfig.CertFile,
+			KeyFile:           tlsConfig.KeyFile,
+			ClientCAFile:      tlsConfig.ClientCAFile,
+			RequireClientCert: tlsConfig.RequireClientCert,
+			ReloadInterval:    time.Minute,
+		}, log)
+		if err != nil {
+			return fmt.Errorf("error initialising TLS: %w", err)
+		}
+		httpServer.TLSConfig = reloader.TLSConfig()
+		startWorker(reloader.Run)
+	}
 
-		log.Info("listening on %s\n", logger.NewField("Address", httpServer.Addr))
-		if err := httpServer.ListenAndServe(); err != nil && errors.Is(err, http.ErrServerClosed) {
-			log.Error("error listening and serving: %s\n", logger.NewField("Error", err.Error()))
+	serveErr := make(chan error, 2)
+	go func() {
+		log.Info("serving metrics...", logger.NewField("Address", metricsServer.Addr))
+		if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
 		}
 	}()
-	var wg sync.WaitGroup
-	wg.Add(1)
 	go func() {
-		defer wg.Done()
-		<-ctx.Done()
-		shutdownCtx := context.Background()
-		shutdownCtx, cancel := context.WithTimeout(shutdownCtx, 10*time.Second)
-		defer cancel()
-		if err := httpServer.Shutdown(shutdownCtx); err != nil {
-			log.Error("error shutting down http server: %s\n", logger.NewField("Error", err.Error()))
+		log.Info("listening...", logger.NewField("Address", httpServer.Addr), logger.NewField("TLS", httpServer.TLSConfig != nil))
+		var err error
+		if httpServer.TLSConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
 		}
 	}()
-	wg.Wait()
-	return nil
+
+	var runErr error
+	select {
+	case err = <-serveErr:
+		runErr = fmt.Errorf("error listening and serving: %w", err)
+	case <-ctx.Done():
+		log.Info("shutting down...", logger.NewField("Timeout", shutdownTimeout.String()))
+	}
+
+	shutdown(log, shutdownTimeout, httpServer, metricsServer, stopWorkers, &workers, relay, resources)
+	resources = nil
+	return runErr
+}
+
+// shutdown stops accepting connections and waits for in-flight requests, stops the background workers and
+// publishes the outbox events recorded meanwhile, then closes resources, all within timeout.
+// Metrics are served until the end.
+func shutdown(
+	log *logger.Logger,
+	timeout time.Duration,
+	httpServer *http.Server,
+	metricsServer *http.Server,
+	stopWorkers context.CancelFunc,
+	workers *sync.WaitGroup,
+	relay *kafka.OutboxRelay,
+	resources []resource,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	defer metricsServer.Close()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Error("error draining http requests", logger.NewField("Error", err.Error()))
+	}
+
+	stopWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		relay.Drain(ctx)
+	case <-ctx.Done():
+		log.Warn("background workers still running at the shutdown deadline")
+
+		var closable []resource
+		for _, resource := range resources {
+			if !resource.keepForWorkers {
+				closable = append(closable, resource)
+			}
+		}
+		resources = closable
+	}
+
+	log.Info("shutdown complete")
+	closeResources(ctx, log, resources)
+}
+
+// resource is a connection or buffer to close on exit
+type resource struct {
+	name  string
+	close func(context.Context) error
+
+	// keepForWorkers resources are left open while background workers are running, since they record their outcome in them
+	keepForWorkers bool
+}
+
+func closeWith(closer io.Closer) func(context.Context) error {
+	return func(context.Context) error {
+		return closer.Close()
+	}
+}
+
+// closeResources closes resources in reverse order, logging failures, and gives up on those left once ctx is done
+func closeResources(ctx context.Context, log *logger.Logger, resources []resource) {
+	for i := len(resources) - 1; i >= 0; i-- {
+		closed := make(chan error, 1)
+		go func() {
+			closed <- resources[i].close(ctx)
+		}()
+		select {
+		case err := <-closed:
+			if err != nil {
+				log.Warn("error closing "+resources[i].name, logger.NewField("Error", err.Error()))
+			}
+		case <-ctx.Done():
+			log.Warn(resources[i].name+" not closed by the shutdown deadline", logger.NewField("Error", ctx.Err().Error()))
+		}
+	}
+}
+
+// newLogDestination logs entries to the console and to the daily rotated LOG_FILE if set, at the levels of the logger
+// so that they follow the levels changed at runtime, and ERROR entries to the aggregator at LOG_REMOTE_URL if set,
+// in Loki's format when LOG_REMOTE_FORMAT is loki
+func newLogDestination(loggingConfig config.Logging) (*logger.TeeDestination, error) {
+	tee, err := logger.NewTeeDestination(logger.TeeSink{Name: "console", Destination: new(logger.ConsoleDestination), Level: logger.DEBUG})
+	if err != nil {
+		return nil, err
+	}
+
+	if path := loggingConfig.File; path != "" {
+		file, err := logger.NewFileDestination(logger.FileConfig{
+			Path:        path,
+			MaxSize:     100 << 20,
+			RotateEvery: 24 * time.Hour,
+			MaxBackups:  14,
+			Compress:    true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = tee.AddSink(logger.TeeSink{Name: "file", Destination: file, Level: logger.DEBUG}); err != nil {
+			return nil, err
+		}
+	}
+
+	if url := loggingConfig.RemoteURL; url != "" {
+		remoteConfig := logger.RemoteConfig{URL: url}
+		if loggingConfig.RemoteFormat == "loki" {
+			remoteConfig.Format = logger.RemoteFormatLoki
+		}
+		if err = tee.AddSink(logger.TeeSink{Name: "remote", Destination: logger.NewRemoteDestination(remoteConfig), Level: logger.ERROR}); err != nil {
+			return nil, err
+		}
+	}
+	return tee, nil
 }
@@ -0,0 +1,78 @@
+// Command reencrypt encrypts the data stored in the database again with the primary encryption key,
+// so that keys retired by a rotation can be removed from the keyring.
+//
+// Usage:
+//
+//	reencrypt [-batch-size N]
+//
+// Settings are read from the DATABASE_URL, ENCRYPTION_KEYS_FILE, ENCRYPTION_KEYS, ENCRYPTION_KEY, LOCAL_KMS_KEYS_FILE,
+// KMS_URL, KMS_KEY_ID and KMS_TOKEN environment variables. The keyring and the KMS must give access to every key data
+// may still be encrypted with, besides the key built into earlier versions, which is always included.
+// When a KMS is configured, data is re-encrypted with envelope encryption.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/events"
+	"github.com/ercross/payment_gateways/internal/services"
+	"io"
+	"os"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	batchSize := flags.Int("batch-size", 500, "number of rows re-encrypted per database transaction")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	keyring, err := services.LoadKeyring(os.Getenv("ENCRYPTION_KEYS_FILE"), os.Getenv("ENCRYPTION_KEYS"), os.Getenv("ENCRYPTION_KEY"))
+	if err == nil {
+		keyring, err = services.WithBuiltinKey(keyring)
+	}
+	if err != nil {
+		return fmt.Errorf("error loading encryption keys: %w", err)
+	}
+	services.SetKeyProvider(keyring)
+
+	kms, err := services.LoadKMS(os.Getenv("LOCAL_KMS_KEYS_FILE"), os.Getenv("KMS_URL"), os.Getenv("KMS_KEY_ID"), os.Getenv("KMS_TOKEN"))
+	if err != nil {
+		return fmt.Errorf("error initialising KMS: %w", err)
+	}
+	if kms != nil {
+		services.SetKMS(kms)
+	}
+
+	repo, err := db.New(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		return fmt.Errorf("error initialising database: %w", err)
+	}
+
+	columns := []struct {
+		column  db.EncryptedColumn
+		rewrite func([]byte) ([]byte, bool, error)
+	}{
+		{db.OutboxEventPayloads, events.ReencryptSensitive},
+		{db.DeadLetterEventPayloads, events.ReencryptSensitive},
+		{db.TOTPSecrets, services.Reencrypt},
+		{db.VaultValues, services.Reencrypt},
+	}
+	for _, c := range columns {
+		rewritten, err := repo.RewriteEncryptedColumn(ctx, c.column, *batchSize, c.rewrite)
+		fmt.Fprintf(out, "%s.%s: %d row(s) re-encrypted\n", c.column.Table, c.column.Column, rewritten)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// lastUsedResolution limits how often using a key writes its last_used_at
+const lastUsedResolution = time.Minute
+
+// CreateAPIKey stores key, which must carry the hash of the API key rather than the key itself,
+// along with the users it may act on behalf of
+func (p *DB) CreateAPIKey(key APIKey) (int, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return -1, fmt.Errorf("failed to begin db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO api_keys (name, prefix, key_hash, scopes, expires_at)
+			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	var id int
+	err = tx.QueryRow(query, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.ExpiresAt).Scan(&id)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create API key: %w", err)
+	}
+	if err = bindAPIKeyUsers(tx, id, key.UserIDs); err != nil {
+		return -1, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return -1, fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return id, nil
+}
+
+// BindAPIKeyUsers lets the API key id act on behalf of userIDs, in addition to the users it is already bound to
+func (p *DB) BindAPIKeyUsers(id int, userIDs []int) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM api_keys WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to get API key: %w", err)
+	}
+	if !exists {
+		return ErrDataNotFound
+	}
+	if err = bindAPIKeyUsers(tx, id, userIDs); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return nil
+}
+
+// UnbindAPIKeyUsers stops the API key id from acting on behalf of userIDs
+func (p *DB) UnbindAPIKeyUsers(id int, userIDs []int) error {
+	_, err := p.db.Exec(`DELETE FROM api_key_users WHERE api_key_id = $1 AND user_id = ANY($2)`, id, pq.Array(userIDs))
+	if err != nil {
+		return fmt.Errorf("failed to unbind API key users: %w", err)
+	}
+	return nil
+}
+
+func bindAPIKeyUsers(tx *sql.Tx, id int, userIDs []int) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`INSERT INTO api_key_users (api_key_id, user_id)
+					   SELECT $1, unnest($2::INT[])
+					   ON CONFLICT DO NOTHING`, id, pq.Array(userIDs))
+	if err != nil {
+		return fmt.Errorf("failed to bind API key users: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKeyByPrefix returns the API key identified by prefix, whether or not it is still active
+func (p *DB) GetAPIKeyByPrefix(prefix string) (APIKey, error) {
+	query := `SELECT id, name, prefix, key_hash, scopes, created_at, expires_at, revoked_at, last_used_at, rotated_from_id,
+			  ARRAY(SELECT user_id FROM api_key_users WHERE api_key_id = api_keys.id ORDER BY user_id),
+			  COALESCE(expires_at <= CURRENT_TIMESTAMP, FALSE)
+			  FROM api_keys
+			  WHERE prefix = $1`
+
+	key, err := scanAPIKey(p.db.QueryRow(query, prefix))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, ErrDataNotFound
+		}
+		return APIKey{}, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns all API keys, newest first
+func (p *DB) ListAPIKeys() ([]APIKey, error) {
+	query := `SELECT id, name, prefix, key_hash, scopes, created_at, expires_at, revoked_at, last_used_at, rotated_from_id,
+			  ARRAY(SELECT user_id FROM api_key_users WHERE api_key_id = api_keys.id ORDER BY user_id),
+			  COALESCE(expires_at <= CURRENT_TIMESTAMP, FALSE)
+			  FROM api_keys
+			  ORDER BY id DESC`
+
+	rows, err := p.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return keys, nil
+}
+
+// TouchAPIKey records that the API key id has just been used.
+// The write is skipped when the key was already recorded as used within lastUsedResolution.
+func (p *DB) TouchAPIKey(id int) error {
+	query := `UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP
+			  WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - $2 * INTERVAL '1 second')`
+
+	if _, err := p.db.Exec(query, id, lastUsedResolution.Seconds()); err != nil {
+		return fmt.Errorf("failed to update API key last use: %w", err)
+	}
+	return nil
+}
+
+// RotateAPIKey stores replacement as the successor of the API key id, which keeps working for overlap
+// so that clients can be moved to the new key without downtime. It returns the ID of the new key.
+func (p *DB) RotateAPIKey(id int, replacement APIKey, overlap time.Duration) (int, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return -1, fmt.Errorf("failed to begin db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var name string
+	var scopes []string
+	err = tx.QueryRow(`SELECT name, scopes FROM api_keys WHERE id = $1 AND revoked_at IS NULL FOR UPDATE`, id).
+		Scan(&name, pq.Array(&scopes))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return -1, ErrDataNotFound
+		}
+		return -1, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	if replacement.Name == "" {
+		replacement.Name = name
+	}
+	if replacement.Scopes == nil {
+		replacement.Scopes = scopes
+	}
+
+	var newID int
+	err = tx.QueryRow(`INSERT INTO api_keys (name, prefix, key_hash, scopes, expires_at, rotated_from_id)
+					   VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		replacement.Name, replacement.Prefix, replacement.Hash, pq.Array(replacement.Scopes), replacement.ExpiresAt, id).
+		Scan(&newID)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	// the replacement acts on behalf of the same users
+	_, err = tx.Exec(`INSERT INTO api_key_users (api_key_id, user_id)
+					  SELECT $1, user_id FROM api_key_users WHERE api_key_id = $2`, newID, id)
+	if err != nil {
+		return -1, fmt.Errorf("failed to bind API key users: %w", err)
+	}
+
+	// never extend the lifetime of a key that was due to expire before the end of the overlap
+	_, err = tx.Exec(`UPDATE api_keys
+					  SET expires_at = LEAST(COALESCE(expires_at, 'infinity'), CURRENT_TIMESTAMP + $1 * INTERVAL '1 second')
+					  WHERE id = $2`, overlap.Seconds(), id)
+	if err != nil {
+		return -1, fmt.Errorf("failed to expire rotated API key: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return -1, fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return newID, nil
+}
+
+// RevokeAPIKey immediately disables the API key id
+func (p *DB) RevokeAPIKey(id int) error {
+	result, err := p.db.Exec(`UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrDataNotFound
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var userIDs pq.Int64Array
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, pq.Array(&key.Scopes), &key.CreatedAt,
+		&key.ExpiresAt, &key.RevokedAt, &key.LastUsedAt, &key.RotatedFromID, &userIDs, &key.Expired)
+	for _, userID := range userIDs {
+		key.UserIDs = append(key.UserIDs, int(userID))
+	}
+	return key, err
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAPIKey_BindsUsers(t *testing.T) {
+	repo, mock := newMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO api_keys")).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO api_key_users")).WithArgs(3, pq.Array([]int{1, 2})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	id, err := repo.CreateAPIKey(APIKey{Name: "merchant", Scopes: []string{"deposit:create"}, UserIDs: []int{1, 2}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAPIKeyByPrefix_Users(t *testing.T) {
+	repo, mock := newMockDB(t)
+
+	columns := []string{"id", "name", "prefix", "key_hash", "scopes", "created_at", "expires_at", "revoked_at",
+		"last_used_at", "rotated_from_id", "user_ids", "expired"}
+	mock.ExpectQuery(regexp.QuoteMeta("ARRAY(SELECT user_id FROM api_key_users")).WithArgs("pk_abc").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, "merchant", "pk_abc", "hash", "{deposit:create}", time.Now(), nil, nil, nil, nil, "{1,2}", false))
+
+	key, err := repo.GetAPIKeyByPrefix("pk_abc")
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, key.UserIDs)
+	assert.True(t, key.ActsFor(2))
+	assert.False(t, key.ActsFor(3))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRotateAPIKey_CopiesUsers(t *testing.T) {
+	repo, mock := newMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, scopes FROM api_keys")).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "scopes"}).AddRow("merchant", "{deposit:create}"))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO api_keys")).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectExec(regexp.QuoteMeta("SELECT $1, user_id FROM api_key_users WHERE api_key_id = $2")).WithArgs(4, 3).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE api_keys")).WithArgs(time.Hour.Seconds(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	newID, err := repo.RotateAPIKey(3, APIKey{Prefix: "pk_def", Hash: "hash"}, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 4, newID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
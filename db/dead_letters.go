@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ListDeadLetterEvents returns up to limit dead letter events matching filter, oldest failure first
+func (p *DB) ListDeadLetterEvents(ctx context.Context, filter DeadLetterFilter, limit int) ([]DeadLetterEvent, error) {
+	where, args := filter.whereClause()
+	args = append(args, limit)
+	query := fmt.Sprintf(`
+		SELECT id, outbox_event_id, transaction_id, payload, attempts, COALESCE(last_error, ''), created_at, failed_at, replayed_at, abandoned_at
+		FROM dead_letter_events
+		%s
+		ORDER BY failed_at ASC, id ASC
+		LIMIT $%d
+	`, where, len(args))
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letter events: %w", err)
+	}
+	defer rows.Close()
+
+	return scanDeadLetterEvents(rows)
+}
+
+// ProcessDeadLetterBatch locks up to limit dead letter events matching filter and hands them to publish in the order
+// they were originally recorded. Rows locked by other instances are skipped, and events already replayed are never
+// handed to publish again, whatever filter.IncludeReplayed.
+// Events for which publish returns nil are marked as replayed. Failures are recorded and the next automatic replay
+// of the event is delayed by policy. Events publish fails with ErrUnpublishable for, or that have failed
+// policy.MaxAttempts times in all when it is not zero, are abandoned: automatic replays leave them out.
+// It returns the number of events marked as replayed.
+func (p *DB) ProcessDeadLetterBatch(ctx context.Context, filter DeadLetterFilter, limit int, policy OutboxRetryPolicy, publish func(DeadLetterEvent) error) (int, error) {
+	filter.IncludeReplayed = false
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	where, args := filter.whereClause()
+	args = append(args, limit)
+	query := fmt.Sprintf(`
+		SELECT id, outbox_event_id, transaction_id, payload, attempts, COALESCE(last_error, ''), created_at, failed_at, replayed_at, abandoned_at
+		FROM dead_letter_events
+		%s
+		ORDER BY outbox_event_id ASC
+		LIMIT $%d
+		FOR UPDATE SKIP LOCKED
+	`, where, len(args))
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query dead letter events: %w", err)
+	}
+	events, err := scanDeadLetterEvents(rows)
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, event := range events {
+		if publishErr := publish(event); publishErr != nil {
+			if errors.Is(publishErr, ErrUnpublishable) || (policy.MaxAttempts > 0 && event.Attempts+1 >= policy.MaxAttempts) {
+				_, err = tx.ExecContext(ctx,
+					`UPDATE dead_letter_events
+					 SET attempts = attempts + 1, last_error = $1, abandoned_at = CURRENT_TIMESTAMP
+					 WHERE id = $2`,
+					publishErr.Error(), event.ID)
+			} else {
+				_, err = tx.ExecContext(ctx,
+					`UPDATE dead_letter_events
+					 SET attempts = attempts + 1, last_error = $1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+					 WHERE id = $3`,
+					publishErr.Error(), policy.Delay(event.Attempts+1).Seconds(), event.ID)
+			}
+		} else {
+			_, err = tx.ExecContext(ctx,
+				`UPDATE dead_letter_events SET attempts = attempts + 1, replayed_at = CURRENT_TIMESTAMP WHERE id = $1`,
+				event.ID)
+			replayed++
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to update dead letter event %d: %w", event.ID, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return replayed, nil
+}
+
+// moveToDeadLetters moves an outbox event that failed its last allowed publish attempt to the dead_letter_events table
+func moveToDeadLetters(ctx context.Context, tx *sql.Tx, event OutboxEvent, publishErr error) error {
+	query := `INSERT INTO dead_letter_events (outbox_event_id, transaction_id, payload, attempts, last_error, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := tx.ExecContext(ctx, query, event.ID, event.TransactionID, event.Payload, event.Attempts+1, publishErr.Error(), event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert dead letter event: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM outbox_events WHERE id = $1`, event.ID); err != nil {
+		return fmt.Errorf("failed to delete outbox event: %w", err)
+	}
+	return nil
+}
+
+func (f DeadLetterFilter) whereClause() (string, []any) {
+	var conditions []string
+	var args []any
+
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.ID != 0 {
+		add("id = $%d", f.ID)
+	}
+	if f.TransactionID != 0 {
+		add("transaction_id = $%d", f.TransactionID)
+	}
+	if !f.From.IsZero() {
+		add("failed_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("failed_at < $%d", f.To)
+	}
+	if !f.IncludeReplayed {
+		conditions = append(conditions, "replayed_at IS NULL")
+	}
+	if f.Due {
+		conditions = append(conditions, "abandoned_at IS NULL", "(next_attempt_at IS NULL OR next_attempt_at <= CURRENT_TIMESTAMP)")
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func scanDeadLetterEvents(rows *sql.Rows) ([]DeadLetterEvent, error) {
+	var events []DeadLetterEvent
+	for rows.Next() {
+		var event DeadLetterEvent
+		if err := rows.Scan(&event.ID, &event.OutboxEventID, &event.TransactionID, &event.Payload, &event.Attempts,
+			&event.LastError, &event.CreatedAt, &event.FailedAt, &event.ReplayedAt, &event.AbandonedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return events, nil
+}
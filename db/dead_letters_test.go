@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterFilter_WhereClause(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		filter    DeadLetterFilter
+		wantWhere string
+		wantArgs  []any
+	}{
+		{
+			name:      "pending events by default",
+			wantWhere: "WHERE replayed_at IS NULL",
+		},
+		{
+			name:   "replayed events included",
+			filter: DeadLetterFilter{IncludeReplayed: true},
+		},
+		{
+			name:      "every condition",
+			filter:    DeadLetterFilter{ID: 3, TransactionID: 7, From: from, To: to},
+			wantWhere: "WHERE id = $1 AND transaction_id = $2 AND failed_at >= $3 AND failed_at < $4 AND replayed_at IS NULL",
+			wantArgs:  []any{int64(3), 7, from, to},
+		},
+		{
+			name:      "placeholders follow the conditions set",
+			filter:    DeadLetterFilter{TransactionID: 7, To: to, IncludeReplayed: true},
+			wantWhere: "WHERE transaction_id = $1 AND failed_at < $2",
+			wantArgs:  []any{7, to},
+		},
+		{
+			name:      "events due for an automatic replay",
+			filter:    DeadLetterFilter{Due: true},
+			wantWhere: "WHERE replayed_at IS NULL AND abandoned_at IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= CURRENT_TIMESTAMP)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := tt.filter.whereClause()
+			assert.Equal(t, tt.wantWhere, where)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+var deadLetterColumns = []string{"id", "outbox_event_id", "transaction_id", "payload", "attempts", "last_error",
+	"created_at", "failed_at", "replayed_at", "abandoned_at"}
+
+func TestProcessDeadLetterBatch_Replay(t *testing.T) {
+	repo, mock := newMockDB(t)
+	failed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`(?s)WHERE transaction_id = \$1 AND replayed_at IS NULL.*ORDER BY outbox_event_id ASC.*LIMIT \$2.*FOR UPDATE SKIP LOCKED`).
+		WithArgs(7, 10).
+		WillReturnRows(sqlmock.NewRows(deadLetterColumns).
+			AddRow(1, 11, 7, []byte(`{"id":"a"}`), 10, "broker unavailable", failed, failed, nil, nil).
+			AddRow(2, 12, 7, []byte(`{"id":"b"}`), 10, "broker unavailable", failed, failed, nil, nil).
+			AddRow(3, 13, 7, []byte(`not an event`), 10, "undecodable", failed, failed, nil, nil).
+			AddRow(4, 14, 7, []byte(`{"id":"d"}`), 49, "broker unavailable", failed, failed, nil, nil))
+	mock.ExpectExec(regexp.QuoteMeta("SET attempts = attempts + 1, replayed_at = CURRENT_TIMESTAMP WHERE id = $1")).
+		WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// a failure delays the next automatic replay
+	mock.ExpectExec(regexp.QuoteMeta("SET attempts = attempts + 1, last_error = $1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)")).
+		WithArgs("still unavailable", float64(6*60*60), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// an event that can never be published, or that has failed too many times, is abandoned
+	mock.ExpectExec(regexp.QuoteMeta("SET attempts = attempts + 1, last_error = $1, abandoned_at = CURRENT_TIMESTAMP")).
+		WithArgs("event cannot be published: invalid payload", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("SET attempts = attempts + 1, last_error = $1, abandoned_at = CURRENT_TIMESTAMP")).
+		WithArgs("still unavailable", 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	policy := OutboxRetryPolicy{MaxAttempts: 50, BaseDelay: 5 * time.Minute, MaxDelay: 6 * time.Hour}
+	var published []int64
+	replayed, err := repo.ProcessDeadLetterBatch(context.Background(), DeadLetterFilter{TransactionID: 7}, 10, policy,
+		func(event DeadLetterEvent) error {
+			published = append(published, event.OutboxEventID)
+			switch event.ID {
+			case 3:
+				return fmt.Errorf("%w: invalid payload", ErrUnpublishable)
+			case 2, 4:
+				return errors.New("still unavailable")
+			}
+			return nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, []int64{11, 12, 13, 14}, published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProcessDeadLetterBatch_SkipsReplayed(t *testing.T) {
+	repo, mock := newMockDB(t)
+
+	// asking for replayed events does not hand them to publish again
+	mock.ExpectBegin()
+	mock.ExpectQuery(`(?s)WHERE replayed_at IS NULL.*FOR UPDATE SKIP LOCKED`).WithArgs(10).
+		WillReturnRows(sqlmock.NewRows(deadLetterColumns))
+	mock.ExpectCommit()
+
+	replayed, err := repo.ProcessDeadLetterBatch(context.Background(), DeadLetterFilter{IncludeReplayed: true}, 10, OutboxRetryPolicy{},
+		func(DeadLetterEvent) error {
+			t.Error("no event should be published")
+			return nil
+		})
+	require.NoError(t, err)
+	assert.Zero(t, replayed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
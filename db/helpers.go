@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -58,6 +59,19 @@ func New(dataSourceName string) (*DB, error) {
 	return &DB{db: db}, nil
 }
 
+// Close closes the connections to the database
+func (p *DB) Close() error {
+	return p.db.Close()
+}
+
+// Ping checks that the database is reachable
+func (p *DB) Ping(ctx context.Context) error {
+	if err := p.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	return nil
+}
+
 func (p *DB) CreateUser(user User) error {
 	query := `INSERT INTO users (username, email, country_id, created_at, updated_at) 
 			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
@@ -155,7 +169,7 @@ func (p *DB) GetUserByID(userID int) (User, error) {
 	query := `SELECT u.id, u.username, u.email, u.password, u.created_at, u.updated_at,
        			c.id, c.name, c.code, c.currency
               FROM users u
-              JOIN countries c ON users.country_id = countries.id
+              JOIN countries c ON u.country_id = c.id
               WHERE u.id = $1;`
 
 	// Execute the query
@@ -358,7 +372,7 @@ func (p *DB) GetGatewayByName(gatewayName string) (Gateway, error) {
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 
-			return gateway, fmt.Errorf("no gateway found with name: %s", gatewayName)
+			return gateway, fmt.Errorf("no gateway found with name %s: %w", gatewayName, ErrDataNotFound)
 		}
 		return gateway, fmt.Errorf("error querying gateway: %v", err)
 	}
@@ -378,7 +392,7 @@ func (p *DB) GetUserAccount(userID int) (*UserAccount, error) {
 	account := &UserAccount{}
 	if err := row.Scan(&account.ID, &account.UserID, &account.Balance, &account.Currency, &account.CreatedAt, &account.UpdatedAt); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("user account not found for user_id: %d", userID)
+			return nil, fmt.Errorf("user account not found for user_id %d: %w", userID, ErrDataNotFound)
 		}
 		return nil, err
 	}
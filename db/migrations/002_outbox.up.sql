@@ -0,0 +1,16 @@
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'outbox_events') THEN
+        CREATE TABLE outbox_events (
+                               id BIGSERIAL PRIMARY KEY,
+                               transaction_id INT NOT NULL,                   -- Ordering key of the event
+                               data_format SMALLINT NOT NULL DEFAULT 0,      -- dto.DataFormat used to select the topic
+                               payload BYTEA NOT NULL,
+                               attempts INT NOT NULL DEFAULT 0,
+                               last_error TEXT,
+                               created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                               sent_at TIMESTAMP
+        );
+        CREATE INDEX idx_outbox_events_pending ON outbox_events (transaction_id, id) WHERE sent_at IS NULL;
+    END IF;
+END $$;
@@ -0,0 +1,2 @@
+-- Kafka topics and their serialization are now chosen by producer configuration rather than by the request data format
+ALTER TABLE outbox_events DROP COLUMN IF EXISTS data_format;
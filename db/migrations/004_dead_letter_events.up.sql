@@ -0,0 +1,18 @@
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'dead_letter_events') THEN
+        CREATE TABLE dead_letter_events (
+                                    id BIGSERIAL PRIMARY KEY,
+                                    outbox_event_id BIGINT NOT NULL UNIQUE,        -- ID the event had in outbox_events
+                                    transaction_id INT NOT NULL,
+                                    payload BYTEA NOT NULL,
+                                    attempts INT NOT NULL DEFAULT 0,              -- Publish attempts, including those made from the outbox
+                                    last_error TEXT,
+                                    created_at TIMESTAMP NOT NULL,                -- When the event was recorded in the outbox
+                                    failed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                    replayed_at TIMESTAMP
+        );
+        CREATE INDEX idx_dead_letter_events_transaction_id ON dead_letter_events (transaction_id);
+        CREATE INDEX idx_dead_letter_events_failed_at ON dead_letter_events (failed_at);
+    END IF;
+END $$;
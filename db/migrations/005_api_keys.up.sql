@@ -0,0 +1,17 @@
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'api_keys') THEN
+        CREATE TABLE api_keys (
+                          id SERIAL PRIMARY KEY,
+                          name VARCHAR(255) NOT NULL,                  -- Merchant or service the key is issued to
+                          prefix VARCHAR(32) NOT NULL UNIQUE,          -- Public part of the key, used to identify it
+                          key_hash CHAR(64) NOT NULL,                  -- SHA-256 of the full key
+                          scopes TEXT[] NOT NULL DEFAULT '{}',
+                          created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                          expires_at TIMESTAMP,                        -- Set when the key is rotated, ending its overlap period
+                          revoked_at TIMESTAMP,
+                          last_used_at TIMESTAMP,
+                          rotated_from_id INT REFERENCES api_keys (id)
+        );
+    END IF;
+END $$;
@@ -0,0 +1,24 @@
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'user_totp') THEN
+        CREATE TABLE user_totp (
+                           user_id INT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+                           secret BYTEA NOT NULL,                           -- Encrypted shared secret
+                           confirmed_at TIMESTAMP,                          -- Set once the user proved the authenticator app is set up
+                           last_used_step BIGINT NOT NULL DEFAULT 0,        -- Time step of the last accepted code, preventing replays
+                           failed_attempts INT NOT NULL DEFAULT 0,          -- Consecutive failed verifications
+                           locked_until TIMESTAMP,
+                           created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'totp_recovery_codes') THEN
+        CREATE TABLE totp_recovery_codes (
+                                     id SERIAL PRIMARY KEY,
+                                     user_id INT NOT NULL REFERENCES user_totp (user_id) ON DELETE CASCADE,
+                                     code_hash CHAR(64) NOT NULL,             -- SHA-256 of the recovery code
+                                     used_at TIMESTAMP,
+                                     UNIQUE (user_id, code_hash)
+        );
+    END IF;
+END $$;
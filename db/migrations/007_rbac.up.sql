@@ -0,0 +1,55 @@
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'roles') THEN
+        CREATE TABLE roles (
+                       id SERIAL PRIMARY KEY,
+                       name VARCHAR(100) NOT NULL UNIQUE,
+                       description TEXT
+        );
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'permissions') THEN
+        CREATE TABLE permissions (
+                             id SERIAL PRIMARY KEY,
+                             name VARCHAR(100) NOT NULL UNIQUE,       -- <resource>:<action>, e.g. gateways:write
+                             description TEXT
+        );
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'role_permissions') THEN
+        CREATE TABLE role_permissions (
+                                  role_id INT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+                                  permission_id INT NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+                                  PRIMARY KEY (role_id, permission_id)
+        );
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'user_roles') THEN
+        CREATE TABLE user_roles (
+                            user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+                            role_id INT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+                            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                            PRIMARY KEY (user_id, role_id)
+        );
+    END IF;
+END $$;
+
+INSERT INTO permissions (name, description) VALUES
+    ('gateways:read', 'View payment gateways and their priorities'),
+    ('gateways:write', 'Manage payment gateways and their priorities'),
+    ('transactions:read', 'View the transactions of any user'),
+    ('refunds:write', 'Refund transactions'),
+    ('accounts:read', 'View the accounts of any user'),
+    ('accounts:write', 'Manage user accounts and roles')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Operates the platform'),
+    ('support', 'Assists users with their transactions and accounts')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'admin'
+   OR (r.name = 'support' AND p.name IN ('gateways:read', 'transactions:read', 'accounts:read'))
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,24 @@
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'vault_entries') THEN
+        CREATE TABLE vault_entries (
+                               id SERIAL PRIMARY KEY,
+                               token VARCHAR(64) NOT NULL UNIQUE,       -- Opaque value standing for the sensitive one throughout the app
+                               user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+                               value BYTEA NOT NULL,                    -- Encrypted sensitive value
+                               created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'vault_access_log') THEN
+        CREATE TABLE vault_access_log (
+                                  id SERIAL PRIMARY KEY,
+                                  vault_entry_id INT NOT NULL REFERENCES vault_entries (id) ON DELETE CASCADE,
+                                  accessor VARCHAR(255) NOT NULL,       -- Payment gateway the value was disclosed to
+                                  transaction_id INT REFERENCES transactions (id),
+                                  purpose VARCHAR(255) NOT NULL,
+                                  accessed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_vault_access_log_entry ON vault_access_log (vault_entry_id);
+    END IF;
+END $$;
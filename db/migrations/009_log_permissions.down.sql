@@ -0,0 +1 @@
+DELETE FROM permissions WHERE name IN ('logs:read', 'logs:write');
@@ -0,0 +1,10 @@
+INSERT INTO permissions (name, description) VALUES
+    ('logs:read', 'View log levels'),
+    ('logs:write', 'Change log levels')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE p.name IN ('logs:read', 'logs:write')
+  AND (r.name = 'admin' OR (r.name = 'support' AND p.name = 'logs:read'))
+ON CONFLICT DO NOTHING;
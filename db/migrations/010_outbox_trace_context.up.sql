@@ -0,0 +1,2 @@
+-- W3C traceparent of the request that recorded the event, so that its publish continues the same trace
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS trace_context TEXT;
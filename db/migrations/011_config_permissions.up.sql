@@ -0,0 +1,8 @@
+INSERT INTO permissions (name, description) VALUES
+    ('config:read', 'View the redacted server configuration')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE p.name = 'config:read' AND r.name = 'admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,17 @@
+DROP INDEX IF EXISTS idx_transactions_created_at;
+DROP INDEX IF EXISTS idx_transactions_user_created_at;
+
+DO $$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'transactions' AND column_name = 'gateway_name') = 'character varying' THEN
+        UPDATE transactions t SET gateway_name = g.id::text FROM gateways g WHERE t.gateway_name = g.name;
+        ALTER TABLE transactions ALTER COLUMN gateway_name TYPE INT USING gateway_name::int;
+    END IF;
+
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'transactions' AND column_name = 'country_name') = 'character varying' THEN
+        UPDATE transactions t SET country_name = c.id::text FROM countries c WHERE t.country_name = c.name;
+        ALTER TABLE transactions ALTER COLUMN country_name TYPE INT USING country_name::int;
+    END IF;
+END $$;
@@ -0,0 +1,19 @@
+-- transactions record the names of their gateway and country, as the API writes them, rather than their IDs
+DO $$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'transactions' AND column_name = 'gateway_name') = 'integer' THEN
+        ALTER TABLE transactions ALTER COLUMN gateway_name TYPE VARCHAR(255) USING gateway_name::text;
+        UPDATE transactions t SET gateway_name = g.name FROM gateways g WHERE t.gateway_name = g.id::text;
+    END IF;
+
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'transactions' AND column_name = 'country_name') = 'integer' THEN
+        ALTER TABLE transactions ALTER COLUMN country_name TYPE VARCHAR(255) USING country_name::text;
+        UPDATE transactions t SET country_name = c.name FROM countries c WHERE t.country_name = c.id::text;
+    END IF;
+END $$;
+
+-- keyset pagination of the transactions of a user, and of all transactions
+CREATE INDEX IF NOT EXISTS idx_transactions_user_created_at ON transactions (user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_transactions_created_at ON transactions (created_at, id);
@@ -0,0 +1,2 @@
+-- Earliest time a failed outbox event is published again, so that retries back off during a broker outage
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP;
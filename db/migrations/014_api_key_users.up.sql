@@ -0,0 +1,12 @@
+DO $$
+BEGIN
+    -- Users an API key may act on behalf of; requests on behalf of any other user are rejected
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'api_key_users') THEN
+        CREATE TABLE api_key_users (
+                            api_key_id INT NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+                            user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+                            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                            PRIMARY KEY (api_key_id, user_id)
+        );
+    END IF;
+END $$;
@@ -0,0 +1 @@
+-- the currencies of withdrawals are kept, they were never known to differ from the account currency
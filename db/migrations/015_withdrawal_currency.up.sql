@@ -0,0 +1,5 @@
+-- withdrawals were recorded without currency; they are paid from the account, in its currency
+UPDATE transactions t
+SET currency = TRIM(a.currency)
+FROM user_accounts a
+WHERE t.type = 'withdrawal' AND t.currency = '' AND a.user_id = t.user_id;
@@ -0,0 +1,2 @@
+ALTER TABLE dead_letter_events DROP COLUMN IF EXISTS abandoned_at;
+ALTER TABLE dead_letter_events DROP COLUMN IF EXISTS next_attempt_at;
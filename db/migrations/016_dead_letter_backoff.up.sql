@@ -0,0 +1,4 @@
+-- Earliest time a dead letter event is replayed again automatically, so that failing replays back off
+ALTER TABLE dead_letter_events ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP;
+-- When automatic replays were given up, because the event can never be published or failed too many times
+ALTER TABLE dead_letter_events ADD COLUMN IF NOT EXISTS abandoned_at TIMESTAMP;
@@ -1,6 +1,10 @@
 package db
 
-import "time"
+import (
+	"slices"
+	"strings"
+	"time"
+)
 
 type User struct {
 	ID        int
@@ -41,6 +45,20 @@ type Transaction struct {
 	CreatedAt   time.Time
 }
 
+// BalanceEffect returns how much trx adds to the balance of its user: the amount of deposits once they succeed,
+// minus the amount of withdrawals unless they failed, as the funds are held while they are pending
+func (trx Transaction) BalanceEffect() float64 {
+	status := strings.ToLower(trx.Status)
+	switch {
+	case trx.Type == "deposit" && (status == "success" || status == "completed"):
+		return trx.Amount
+	case trx.Type == "withdrawal" && status != "failed":
+		return -trx.Amount
+	default:
+		return 0
+	}
+}
+
 type GatewayPriority struct {
 	Gateway   Gateway
 	CountryID int
@@ -58,3 +76,189 @@ type UserAccount struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// OutboxEvent is an event recorded in the same database transaction as the state change it describes.
+// Events sharing a TransactionID are relayed to Kafka in the order they were recorded.
+type OutboxEvent struct {
+	ID            int64
+	TransactionID int
+	Payload       []byte
+	Attempts      int
+	LastError     string
+	CreatedAt     time.Time
+	SentAt        *time.Time
+
+	// TraceContext is the W3C traceparent of the span the event was recorded in, if any
+	TraceContext string
+}
+
+// OutboxRetryPolicy spaces the publish attempts of an outbox event, so that a broker outage is ridden out
+// rather than dead-lettering every pending event
+type OutboxRetryPolicy struct {
+	// MaxAttempts is the number of failed attempts after which an event is moved to the dead letter store
+	MaxAttempts int
+
+	// BaseDelay is the delay after the first failed attempt, doubled after each further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Delay returns how long to wait before the next attempt of an event that has failed attempts times
+func (p OutboxRetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// OutboxEventBuilder builds the OutboxEvent for a transaction once it has been persisted
+type OutboxEventBuilder func(trx Transaction) (OutboxEvent, error)
+
+// DeadLetterEvent is an OutboxEvent that could not be published within the allowed number of attempts
+type DeadLetterEvent struct {
+	ID            int64
+	OutboxEventID int64
+	TransactionID int
+	Payload       []byte
+	Attempts      int
+	LastError     string
+	CreatedAt     time.Time
+	FailedAt      time.Time
+	ReplayedAt    *time.Time
+
+	// AbandonedAt is when automatic replays were given up
+	AbandonedAt *time.Time
+}
+
+// TransactionFilter selects transactions. Zero valued fields are ignored.
+type TransactionFilter struct {
+	UserID      int
+	Type        string
+	Status      string
+	Currency    string
+	GatewayName string
+
+	// From and To bound the time the transactions were created at
+	From time.Time
+	To   time.Time
+
+	// MinAmount and MaxAmount bound the amount of the transactions, inclusively
+	MinAmount float64
+	MaxAmount float64
+}
+
+// Orders transactions can be listed in, ties being broken by ID
+const (
+	SortByCreatedAt = "created_at"
+	SortByAmount    = "amount"
+)
+
+// TransactionPage selects a page of transactions
+type TransactionPage struct {
+	SortBy     string
+	Descending bool
+	Limit      int
+
+	// After is the last transaction of the previous page, nil for the first page.
+	// Only its ID and the field sorted by are used.
+	After *Transaction
+}
+
+// DeadLetterFilter selects dead letter events. Zero valued fields are ignored.
+type DeadLetterFilter struct {
+	ID            int64
+	TransactionID int
+
+	// From and To bound the time the events failed at
+	From time.Time
+	To   time.Time
+
+	// IncludeReplayed also selects events that have already been replayed successfully
+	IncludeReplayed bool
+
+	// Due only selects events whose next automatic replay is due, leaving out abandoned ones
+	Due bool
+}
+
+// APIKey authenticates a merchant or service calling the API on behalf of users
+type APIKey struct {
+	ID            int
+	Name          string
+	Prefix        string
+	Hash          string
+	Scopes        []string
+	CreatedAt     time.Time
+	ExpiresAt     *time.Time
+	RevokedAt     *time.Time
+	LastUsedAt    *time.Time
+	RotatedFromID *int
+
+	// Expired is whether ExpiresAt has passed, as judged by the database clock that set it
+	Expired bool
+
+	// UserIDs are the users the key may act on behalf of
+	UserIDs []int
+}
+
+// IsActive reports whether the key may be used, being neither revoked nor expired
+func (k APIKey) IsActive() bool {
+	return k.RevokedAt == nil && !k.Expired
+}
+
+// ActsFor reports whether the key may act on behalf of the user userID
+func (k APIKey) ActsFor(userID int) bool {
+	return slices.Contains(k.UserIDs, userID)
+}
+
+// HasScope reports whether the key grants scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// UserTOTP is the time-based one-time password (RFC 6238) authenticator a user enrolled
+type UserTOTP struct {
+	UserID int
+
+	// Secret is the encrypted shared secret
+	Secret []byte
+
+	// ConfirmedAt is nil until the user proves their authenticator app generates valid codes
+	ConfirmedAt *time.Time
+
+	// LastUsedStep is the time step of the last accepted code; codes of earlier or equal steps are rejected
+	LastUsedStep int64
+
+	FailedAttempts int
+	LockedUntil    *time.Time
+	CreatedAt      time.Time
+
+	// Locked reports whether LockedUntil has not passed yet, as judged by the database clock that set it
+	Locked bool
+}
+
+// VaultEntry is a sensitive value, such as a withdrawal receiving account, stored encrypted and referred to by Token
+type VaultEntry struct {
+	ID     int
+	Token  string
+	UserID int
+
+	// Value is the encrypted sensitive value
+	Value     []byte
+	CreatedAt time.Time
+}
+
+// VaultAccess records the disclosure of a VaultEntry
+type VaultAccess struct {
+	Token string
+
+	// Accessor is the payment gateway the value is disclosed to
+	Accessor      string
+	TransactionID int
+	Purpose       string
+}
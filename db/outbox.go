@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CreateTransactionWithEvent inserts transaction and the OutboxEvent built for it within a single database transaction,
+// so that the event is recorded if and only if the transaction is.
+func (p *DB) CreateTransactionWithEvent(transaction Transaction, buildEvent OutboxEventBuilder) (int, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return -1, fmt.Errorf("failed to begin db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	transaction.Status = "pending"
+	query := `INSERT INTO transactions (amount, type, status, currency, gateway_name, country_name, user_id, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+
+	err = tx.QueryRow(query, transaction.Amount, transaction.Type, transaction.Status, transaction.Currency,
+		transaction.GatewayName, transaction.CountryName, transaction.UserID, time.Now()).Scan(&transaction.ID)
+	if err != nil {
+		return -1, fmt.Errorf("failed to insert transaction: %v", err)
+	}
+
+	event, err := buildEvent(transaction)
+	if err != nil {
+		return -1, fmt.Errorf("failed to build outbox event: %w", err)
+	}
+	event.TransactionID = transaction.ID
+	if err = insertOutboxEvent(tx, event); err != nil {
+		return -1, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return -1, fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return transaction.ID, nil
+}
+
+// UpdateTransactionStatusWithEvent updates the transaction status and records event within a single database transaction
+func (p *DB) UpdateTransactionStatusWithEvent(id int, newStatus string, event OutboxEvent) error {
+	return p.updateTransactionStatusWithEvent(id, "", newStatus, event)
+}
+
+// TransitionTransactionStatusWithEvent updates the transaction status from fromStatus to toStatus and records event
+// within a single database transaction. It returns ErrDataConflict, recording nothing, if the transaction status is
+// no longer fromStatus, such as when a gateway callback has already reported its outcome.
+func (p *DB) TransitionTransactionStatusWithEvent(id int, fromStatus, toStatus string, event OutboxEvent) error {
+	return p.updateTransactionStatusWithEvent(id, fromStatus, toStatus, event)
+}
+
+// updateTransactionStatusWithEvent updates the transaction status, only if it is fromStatus unless fromStatus is empty
+func (p *DB) updateTransactionStatusWithEvent(id int, fromStatus, toStatus string, event OutboxEvent) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE transactions
+        SET status = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND ($3 = '' OR status = $3)
+    `
+	result, err := tx.Exec(query, toStatus, id, fromStatus)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction status: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve rows affected: %w", err)
+	}
+	if rowsAffected == 0 && fromStatus != "" {
+		return fmt.Errorf("no %s transaction found with id %d: %w", fromStatus, id, ErrDataConflict)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no transaction found with id %d", id)
+	}
+
+	event.TransactionID = id
+	if err = insertOutboxEvent(tx, event); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return nil
+}
+
+// ProcessOutboxBatch locks up to limit pending outbox events and hands them to publish in the order they were recorded.
+// Only the oldest pending event of each transaction is eligible, and rows locked by other instances are skipped,
+// so that several relays can run concurrently without reordering events of the same transaction.
+// Events for which publish returns nil are marked as sent; failures are recorded and the event is not eligible again
+// until the delay of policy has elapsed, until it has failed policy.MaxAttempts times and is moved to the
+// dead_letter_events table. It returns the number of events marked as sent.
+func (p *DB) ProcessOutboxBatch(ctx context.Context, limit int, policy OutboxRetryPolicy, publish func(OutboxEvent) error) (int, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT o.id, o.transaction_id, o.payload, o.attempts, o.created_at, COALESCE(o.trace_context, '')
+		FROM outbox_events o
+		WHERE o.sent_at IS NULL
+		  AND (o.next_attempt_at IS NULL OR o.next_attempt_at <= CURRENT_TIMESTAMP)
+		  AND NOT EXISTS (
+		      SELECT 1 FROM outbox_events prev
+		      WHERE prev.transaction_id = o.transaction_id AND prev.sent_at IS NULL AND prev.id < o.id
+		  )
+		ORDER BY o.id ASC
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query outbox events: %w", err)
+	}
+
+	var events []OutboxEvent
+	for rows.Next() {
+		var event OutboxEvent
+		if err := rows.Scan(&event.ID, &event.TransactionID, &event.Payload, &event.Attempts, &event.CreatedAt, &event.TraceContext); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	sent := 0
+	for _, event := range events {
+		if publishErr := publish(event); publishErr != nil {
+			if event.Attempts+1 >= policy.MaxAttempts {
+				err = moveToDeadLetters(ctx, tx, event, publishErr)
+			} else {
+				_, err = tx.ExecContext(ctx,
+					`UPDATE outbox_events
+					 SET attempts = attempts + 1, last_error = $1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+					 WHERE id = $3`,
+					publishErr.Error(), policy.Delay(event.Attempts+1).Seconds(), event.ID)
+			}
+		} else {
+			_, err = tx.ExecContext(ctx,
+				`UPDATE outbox_events SET attempts = attempts + 1, last_error = NULL, sent_at = CURRENT_TIMESTAMP WHERE id = $1`,
+				event.ID)
+			sent++
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to update outbox event %d: %w", event.ID, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return sent, nil
+}
+
+func insertOutboxEvent(tx *sql.Tx, event OutboxEvent) error {
+	query := `INSERT INTO outbox_events (transaction_id, payload, trace_context, created_at)
+			  VALUES ($1, $2, NULLIF($3, ''), $4)`
+
+	if _, err := tx.Exec(query, event.TransactionID, event.Payload, event.TraceContext, time.Now()); err != nil {
+		return fmt.Errorf("failed to insert outbox event: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMockDB(t *testing.T) (*DB, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &DB{db: conn}, mock
+}
+
+var retryPolicy = OutboxRetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+var outboxColumns = []string{"id", "transaction_id", "payload", "attempts", "created_at", "trace_context"}
+
+// expectOutboxClaim expects the events due for publishing to be claimed
+func expectOutboxClaim(mock sqlmock.Sqlmock, limit int, rows *sqlmock.Rows) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`(?s)o.next_attempt_at <= CURRENT_TIMESTAMP.*FOR UPDATE SKIP LOCKED`).WithArgs(limit).WillReturnRows(rows)
+}
+
+func TestProcessOutboxBatch_Published(t *testing.T) {
+	repo, mock := newMockDB(t)
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expectOutboxClaim(mock, 10, sqlmock.NewRows(outboxColumns).
+		AddRow(1, 7, []byte(`{"id":"a"}`), 0, created, "").
+		AddRow(2, 8, []byte(`{"id":"b"}`), 1, created, "00-trace-span-01"))
+	markSent := regexp.QuoteMeta("SET attempts = attempts + 1, last_error = NULL, sent_at = CURRENT_TIMESTAMP WHERE id = $1")
+	mock.ExpectExec(markSent).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(markSent).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	var published []OutboxEvent
+	sent, err := repo.ProcessOutboxBatch(context.Background(), 10, retryPolicy, func(event OutboxEvent) error {
+		published = append(published, event)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	require.Len(t, published, 2)
+	assert.Equal(t, 7, published[0].TransactionID)
+	assert.Equal(t, "00-trace-span-01", published[1].TraceContext)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProcessOutboxBatch_PublishFailure(t *testing.T) {
+	repo, mock := newMockDB(t)
+
+	expectOutboxClaim(mock, 10, sqlmock.NewRows(outboxColumns).AddRow(1, 7, []byte(`{}`), 2, time.Now(), ""))
+	mock.ExpectExec(regexp.QuoteMeta("SET attempts = attempts + 1, last_error = $1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)")).
+		WithArgs("broker unavailable", 4.0, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sent, err := repo.ProcessOutboxBatch(context.Background(), 10, retryPolicy, func(OutboxEvent) error {
+		return errors.New("broker unavailable")
+	})
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+
+	// the event is left pending, neither marked as sent nor moved to the dead letters, until its third retry delay
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProcessOutboxBatch_MaxAttempts(t *testing.T) {
+	repo, mock := newMockDB(t)
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expectOutboxClaim(mock, 10, sqlmock.NewRows(outboxColumns).AddRow(1, 7, []byte(`{}`), 4, created, ""))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO dead_letter_events")).
+		WithArgs(1, 7, []byte(`{}`), 5, "broker unavailable", created).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM outbox_events WHERE id = $1")).WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sent, err := repo.ProcessOutboxBatch(context.Background(), 10, retryPolicy, func(OutboxEvent) error {
+		return errors.New("broker unavailable")
+	})
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProcessOutboxBatch_UpdateFailure(t *testing.T) {
+	repo, mock := newMockDB(t)
+
+	expectOutboxClaim(mock, 10, sqlmock.NewRows(outboxColumns).AddRow(1, 7, []byte(`{}`), 0, time.Now(), ""))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox_events")).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	sent, err := repo.ProcessOutboxBatch(context.Background(), 10, retryPolicy, func(OutboxEvent) error { return nil })
+	assert.ErrorContains(t, err, "failed to update outbox event 1")
+	assert.Zero(t, sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOutboxRetryPolicy_Delay(t *testing.T) {
+	policy := OutboxRetryPolicy{MaxAttempts: 20, BaseDelay: time.Second, MaxDelay: 5 * time.Minute}
+
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 2*time.Second, policy.Delay(2))
+	assert.Equal(t, 256*time.Second, policy.Delay(9))
+	assert.Equal(t, 5*time.Minute, policy.Delay(10))
+	assert.Equal(t, 5*time.Minute, policy.Delay(19))
+
+	// the attempts spread over about an hour, rather than over as many polls
+	var total time.Duration
+	for attempts := 1; attempts < policy.MaxAttempts; attempts++ {
+		total += policy.Delay(attempts)
+	}
+	assert.Greater(t, total, 55*time.Minute)
+}
+
+func TestTransitionTransactionStatusWithEvent(t *testing.T) {
+	repo, mock := newMockDB(t)
+	updateStatus := regexp.QuoteMeta("WHERE id = $2 AND ($3 = '' OR status = $3)")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(updateStatus).WithArgs("registered", 1, "pending").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox_events")).
+		WithArgs(1, []byte(`{}`), "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	require.NoError(t, repo.TransitionTransactionStatusWithEvent(1, "pending", "registered", OutboxEvent{Payload: []byte(`{}`)}))
+
+	// once the status has moved on, neither the status nor the event are recorded
+	mock.ExpectBegin()
+	mock.ExpectExec(updateStatus).WithArgs("registered", 1, "pending").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	err := repo.TransitionTransactionStatusWithEvent(1, "pending", "registered", OutboxEvent{Payload: []byte(`{}`)})
+	assert.ErrorIs(t, err, ErrDataConflict)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
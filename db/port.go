@@ -1,8 +1,19 @@
 package db
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
-var ErrDataNotFound = errors.New("data not found")
+var (
+	ErrDataNotFound = errors.New("data not found")
+	ErrDataConflict = errors.New("data conflicts with existing data")
+
+	// ErrUnpublishable is wrapped by publish callbacks for events that can never be published, such as those with
+	// an undecodable payload, so that they are not retried
+	ErrUnpublishable = errors.New("event cannot be published")
+)
 
 type Repository interface {
 	CreateUser(User) error
@@ -16,7 +27,18 @@ type Repository interface {
 	GetUserAccount(userID int) (*UserAccount, error)
 	UpdateUserBalance(userID int, amount float64) error
 	GetTransactionByID(int) (Transaction, error)
+	ListTransactions(ctx context.Context, filter TransactionFilter, page TransactionPage) ([]Transaction, error)
+	GetBalanceAt(ctx context.Context, userID int, currency string, at time.Time) (float64, error)
 	UpdateTransactionStatus(id int, newStatus string) error
+	CreateTransactionWithEvent(trx Transaction, buildEvent OutboxEventBuilder) (int, error)
+	UpdateTransactionStatusWithEvent(id int, newStatus string, event OutboxEvent) error
+	TransitionTransactionStatusWithEvent(id int, fromStatus, toStatus string, event OutboxEvent) error
+	ProcessOutboxBatch(ctx context.Context, limit int, policy OutboxRetryPolicy, publish func(OutboxEvent) error) (int, error)
+	ListDeadLetterEvents(ctx context.Context, filter DeadLetterFilter, limit int) ([]DeadLetterEvent, error)
+	ProcessDeadLetterBatch(ctx context.Context, filter DeadLetterFilter, limit int, policy OutboxRetryPolicy, publish func(DeadLetterEvent) error) (int, error)
+	GetGateways() ([]Gateway, error)
+	AssignUserRole(userID int, role string) error
+	RevokeUserRole(userID int, role string) error
 }
 
 type Mock struct{}
@@ -30,9 +52,40 @@ func (m *Mock) InsertGatewayPriority(GatewayPriority) error { return nil }
 func (m *Mock) GetGatewayPriorities(countryID int) ([]GatewayPriority, error) {
 	return make([]GatewayPriority, 0), nil
 }
-func (m *Mock) GetGatewayByName(string) (Gateway, error)               { return Gateway{}, nil }
-func (m *Mock) GetUserCountryByUserID(int) (Country, error)            { return Country{}, nil }
-func (m *Mock) GetUserAccount(userID int) (*UserAccount, error)        { return &UserAccount{}, nil }
-func (m *Mock) UpdateUserBalance(userID int, amount float64) error     { return nil }
-func (m *Mock) GetTransactionByID(int) (Transaction, error)            { return Transaction{}, nil }
+func (m *Mock) GetGatewayByName(string) (Gateway, error)           { return Gateway{}, nil }
+func (m *Mock) GetUserCountryByUserID(int) (Country, error)        { return Country{}, nil }
+func (m *Mock) GetUserAccount(userID int) (*UserAccount, error)    { return &UserAccount{}, nil }
+func (m *Mock) UpdateUserBalance(userID int, amount float64) error { return nil }
+func (m *Mock) GetTransactionByID(int) (Transaction, error)        { return Transaction{}, nil }
+func (m *Mock) ListTransactions(ctx context.Context, filter TransactionFilter, page TransactionPage) ([]Transaction, error) {
+	return make([]Transaction, 0), nil
+}
+func (m *Mock) GetBalanceAt(ctx context.Context, userID int, currency string, at time.Time) (float64, error) {
+	return 0, nil
+}
 func (m *Mock) UpdateTransactionStatus(id int, newStatus string) error { return nil }
+func (m *Mock) CreateTransactionWithEvent(trx Transaction, buildEvent OutboxEventBuilder) (int, error) {
+	trx.ID = 1
+	if _, err := buildEvent(trx); err != nil {
+		return -1, err
+	}
+	return trx.ID, nil
+}
+func (m *Mock) UpdateTransactionStatusWithEvent(id int, newStatus string, event OutboxEvent) error {
+	return nil
+}
+func (m *Mock) TransitionTransactionStatusWithEvent(id int, fromStatus, toStatus string, event OutboxEvent) error {
+	return nil
+}
+func (m *Mock) ProcessOutboxBatch(ctx context.Context, limit int, policy OutboxRetryPolicy, publish func(OutboxEvent) error) (int, error) {
+	return 0, nil
+}
+func (m *Mock) ListDeadLetterEvents(ctx context.Context, filter DeadLetterFilter, limit int) ([]DeadLetterEvent, error) {
+	return make([]DeadLetterEvent, 0), nil
+}
+func (m *Mock) ProcessDeadLetterBatch(ctx context.Context, filter DeadLetterFilter, limit int, policy OutboxRetryPolicy, publish func(DeadLetterEvent) error) (int, error) {
+	return 0, nil
+}
+func (m *Mock) GetGateways() ([]Gateway, error)              { return make([]Gateway, 0), nil }
+func (m *Mock) AssignUserRole(userID int, role string) error { return nil }
+func (m *Mock) RevokeUserRole(userID int, role string) error { return nil }
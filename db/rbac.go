@@ -0,0 +1,60 @@
+package db
+
+import (
+	"fmt"
+)
+
+// GetUserPermissions returns the names of the permissions granted to userID through their roles
+func (p *DB) GetUserPermissions(userID int) ([]string, error) {
+	query := `SELECT DISTINCT p.name
+			  FROM user_roles ur
+			  JOIN role_permissions rp ON rp.role_id = ur.role_id
+			  JOIN permissions p ON p.id = rp.permission_id
+			  WHERE ur.user_id = $1
+			  ORDER BY p.name`
+
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user permissions: %w", err)
+	}
+	defer rows.Close()
+
+	var permissions []string
+	for rows.Next() {
+		var permission string
+		if err = rows.Scan(&permission); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return permissions, nil
+}
+
+// AssignUserRole grants the role named role to userID
+func (p *DB) AssignUserRole(userID int, role string) error {
+	result, err := p.db.Exec(`INSERT INTO user_roles (user_id, role_id)
+							  SELECT $1, id FROM roles WHERE name = $2
+							  ON CONFLICT DO NOTHING`, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to assign role: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		var exists bool
+		if err = p.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, role).Scan(&exists); err == nil && !exists {
+			return ErrDataNotFound
+		}
+	}
+	return nil
+}
+
+// RevokeUserRole withdraws the role named role from userID
+func (p *DB) RevokeUserRole(userID int, role string) error {
+	_, err := p.db.Exec(`DELETE FROM user_roles WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)`, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to revoke role: %w", err)
+	}
+	return nil
+}
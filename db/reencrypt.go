@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// EncryptedColumn locates a column holding encrypted data
+type EncryptedColumn struct {
+	Table  string
+	Key    string
+	Column string
+}
+
+// Columns holding data encrypted by services.MaskData, directly or within serialized events
+var (
+	OutboxEventPayloads     = EncryptedColumn{Table: "outbox_events", Key: "id", Column: "payload"}
+	DeadLetterEventPayloads = EncryptedColumn{Table: "dead_letter_events", Key: "id", Column: "payload"}
+	TOTPSecrets             = EncryptedColumn{Table: "user_totp", Key: "user_id", Column: "secret"}
+	VaultValues             = EncryptedColumn{Table: "vault_entries", Key: "id", Column: "value"}
+)
+
+// RewriteEncryptedColumn passes every value of column to rewrite, batchSize rows at a time, storing the values
+// rewrite reports as changed. Each batch is locked and committed separately so that the table remains usable.
+// It returns the number of rows rewritten.
+func (p *DB) RewriteEncryptedColumn(ctx context.Context, column EncryptedColumn, batchSize int,
+	rewrite func([]byte) ([]byte, bool, error)) (int, error) {
+
+	// table and column names come from the predefined EncryptedColumn values, never from user input
+	selectQuery := fmt.Sprintf(`SELECT %[2]s, %[3]s FROM %[1]s WHERE %[2]s > $1 ORDER BY %[2]s LIMIT $2 FOR UPDATE`,
+		column.Table, column.Key, column.Column)
+	updateQuery := fmt.Sprintf(`UPDATE %[1]s SET %[3]s = $1 WHERE %[2]s = $2`, column.Table, column.Key, column.Column)
+
+	rewritten := 0
+	var lastKey int64
+	for {
+		tx, err := p.db.BeginTx(ctx, nil)
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to begin db transaction: %w", err)
+		}
+
+		rows, err := tx.QueryContext(ctx, selectQuery, lastKey, batchSize)
+		if err != nil {
+			tx.Rollback()
+			return rewritten, fmt.Errorf("failed to query %s: %w", column.Table, err)
+		}
+		type row struct {
+			key   int64
+			value []byte
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err = rows.Scan(&r.key, &r.value); err != nil {
+				rows.Close()
+				tx.Rollback()
+				return rewritten, fmt.Errorf("failed to scan %s: %w", column.Table, err)
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			tx.Rollback()
+			return rewritten, fmt.Errorf("rows iteration error: %w", err)
+		}
+
+		changed := 0
+		for _, r := range batch {
+			value, ok, err := rewrite(r.value)
+			if err != nil {
+				tx.Rollback()
+				return rewritten, fmt.Errorf("failed to rewrite %s %s %d: %w", column.Table, column.Key, r.key, err)
+			}
+			if !ok {
+				continue
+			}
+			if _, err = tx.ExecContext(ctx, updateQuery, value, r.key); err != nil {
+				tx.Rollback()
+				return rewritten, fmt.Errorf("failed to update %s %s %d: %w", column.Table, column.Key, r.key, err)
+			}
+			changed++
+		}
+
+		if err = tx.Commit(); err != nil {
+			return rewritten, fmt.Errorf("failed to commit db transaction: %w", err)
+		}
+		rewritten += changed
+
+		if len(batch) < batchSize {
+			return rewritten, nil
+		}
+		lastKey = batch[len(batch)-1].key
+	}
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rewriteOld stands for a re-encryption moving values off the old key, and failing on values no key decrypts
+func rewriteOld(value []byte) ([]byte, bool, error) {
+	switch {
+	case bytes.HasPrefix(value, []byte("old:")):
+		return append([]byte("new:"), value[len("old:"):]...), true, nil
+	case bytes.HasPrefix(value, []byte("new:")):
+		return value, false, nil
+	default:
+		return nil, false, errors.New("no key decrypts it")
+	}
+}
+
+func TestRewriteEncryptedColumn(t *testing.T) {
+	repo, mock := newMockDB(t)
+	selectBatch := regexp.QuoteMeta("SELECT id, secret FROM vault WHERE id > $1 ORDER BY id LIMIT $2 FOR UPDATE")
+	update := regexp.QuoteMeta("UPDATE vault SET secret = $1 WHERE id = $2")
+	column := EncryptedColumn{Table: "vault", Key: "id", Column: "secret"}
+
+	// rows encrypted with the old and the new key are mixed, only the former are rewritten
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectBatch).WithArgs(0, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "secret"}).
+		AddRow(1, []byte("old:a")).AddRow(2, []byte("new:b")))
+	mock.ExpectExec(update).WithArgs([]byte("new:a"), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectBatch).WithArgs(2, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "secret"}).
+		AddRow(5, []byte("old:c")))
+	mock.ExpectExec(update).WithArgs([]byte("new:c"), 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rewritten, err := repo.RewriteEncryptedColumn(context.Background(), column, 2, rewriteOld)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rewritten)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRewriteEncryptedColumn_Failure(t *testing.T) {
+	repo, mock := newMockDB(t)
+	column := EncryptedColumn{Table: "vault", Key: "id", Column: "secret"}
+
+	// a value that cannot be rewritten rolls its batch back, leaving the committed batches rewritten
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, secret FROM vault").WithArgs(0, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "secret"}).
+		AddRow(1, []byte("old:a")).AddRow(2, []byte("old:b")))
+	mock.ExpectExec("UPDATE vault").WithArgs([]byte("new:a"), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE vault").WithArgs([]byte("new:b"), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, secret FROM vault").WithArgs(2, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "secret"}).
+		AddRow(3, []byte("old:c")).AddRow(4, []byte("unknown")))
+	mock.ExpectExec("UPDATE vault").WithArgs([]byte("new:c"), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	rewritten, err := repo.RewriteEncryptedColumn(context.Background(), column, 2, rewriteOld)
+	assert.ErrorContains(t, err, "failed to rewrite vault id 4")
+	assert.Equal(t, 2, rewritten)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
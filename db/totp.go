@@ -0,0 +1,134 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SaveTOTPEnrollment stores a new, unconfirmed TOTP secret for userID, replacing any previous unconfirmed one.
+// It returns ErrDataConflict when the user already confirmed an authenticator.
+func (p *DB) SaveTOTPEnrollment(userID int, encryptedSecret []byte) error {
+	query := `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+			  ON CONFLICT (user_id) DO UPDATE
+			  SET secret = EXCLUDED.secret, last_used_step = 0, failed_attempts = 0, locked_until = NULL, created_at = CURRENT_TIMESTAMP
+			  WHERE user_totp.confirmed_at IS NULL`
+
+	result, err := p.db.Exec(query, userID, encryptedSecret)
+	if err != nil {
+		return fmt.Errorf("failed to save TOTP enrollment: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrDataConflict
+	}
+	return nil
+}
+
+// GetUserTOTP returns the TOTP authenticator of userID
+func (p *DB) GetUserTOTP(userID int) (UserTOTP, error) {
+	query := `SELECT user_id, secret, confirmed_at, last_used_step, failed_attempts, locked_until, created_at,
+			         COALESCE(locked_until > CURRENT_TIMESTAMP, FALSE)
+			  FROM user_totp
+			  WHERE user_id = $1`
+
+	var totp UserTOTP
+	err := p.db.QueryRow(query, userID).Scan(&totp.UserID, &totp.Secret, &totp.ConfirmedAt, &totp.LastUsedStep,
+		&totp.FailedAttempts, &totp.LockedUntil, &totp.CreatedAt, &totp.Locked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserTOTP{}, ErrDataNotFound
+		}
+		return UserTOTP{}, fmt.Errorf("failed to get TOTP authenticator: %w", err)
+	}
+	return totp, nil
+}
+
+// ConfirmTOTP marks the TOTP authenticator of userID as confirmed by a code of time step step,
+// and replaces its recovery codes with those whose hashes are given
+func (p *DB) ConfirmTOTP(userID int, step int64, recoveryCodeHashes []string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE user_totp SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = $2, failed_attempts = 0
+							WHERE user_id = $1 AND confirmed_at IS NULL AND last_used_step < $2`, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to confirm TOTP authenticator: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrDataConflict
+	}
+
+	if _, err = tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err = tx.Exec(`INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return nil
+}
+
+// RecordTOTPSuccess records that userID authenticated with a code of time step step.
+// It reports false, without recording anything, when a code of that step or a later one was already used.
+func (p *DB) RecordTOTPSuccess(userID int, step int64) (bool, error) {
+	result, err := p.db.Exec(`UPDATE user_totp SET last_used_step = $2, failed_attempts = 0
+							  WHERE user_id = $1 AND last_used_step < $2`, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP use: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP use: %w", err)
+	}
+	return affected == 1, nil
+}
+
+// RecordTOTPFailure records a failed verification for userID, locking the authenticator for lockout
+// once maxFailures consecutive verifications failed
+func (p *DB) RecordTOTPFailure(userID int, maxFailures int, lockout time.Duration) error {
+	query := `UPDATE user_totp
+			  SET failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+			      locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN CURRENT_TIMESTAMP + $3 * INTERVAL '1 second' ELSE locked_until END
+			  WHERE user_id = $1`
+
+	if _, err := p.db.Exec(query, userID, maxFailures, lockout.Seconds()); err != nil {
+		return fmt.Errorf("failed to record TOTP failure: %w", err)
+	}
+	return nil
+}
+
+// UseTOTPRecoveryCode consumes the unused recovery code of userID whose hash is codeHash.
+// It reports false when there is no such code.
+func (p *DB) UseTOTPRecoveryCode(userID int, codeHash string) (bool, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE totp_recovery_codes SET used_at = CURRENT_TIMESTAMP
+							WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	if _, err = tx.Exec(`UPDATE user_totp SET failed_attempts = 0 WHERE user_id = $1`, userID); err != nil {
+		return false, fmt.Errorf("failed to reset TOTP failures: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetUserTOTP_Locked(t *testing.T) {
+	repo, mock := newMockDB(t)
+
+	// the lockout is judged by the database, whatever the clock of the API says of locked_until
+	lockedUntil := time.Now().Add(-time.Hour)
+	columns := []string{"user_id", "secret", "confirmed_at", "last_used_step", "failed_attempts", "locked_until",
+		"created_at", "locked"}
+	mock.ExpectQuery(regexp.QuoteMeta("COALESCE(locked_until > CURRENT_TIMESTAMP, FALSE)")).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(7, []byte("secret"), time.Now(), 1, 0, lockedUntil, time.Now(), true))
+
+	totp, err := repo.GetUserTOTP(7)
+	require.NoError(t, err)
+	assert.True(t, totp.Locked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
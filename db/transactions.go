@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ListTransactions returns the page of transactions matching filter.
+// Pages are delimited by the last transaction of the previous page rather than an offset, so that transactions
+// created meanwhile neither shift nor repeat the following pages.
+func (p *DB) ListTransactions(ctx context.Context, filter TransactionFilter, page TransactionPage) ([]Transaction, error) {
+	conditions, args := filter.conditions()
+
+	column := SortByCreatedAt
+	if page.SortBy == SortByAmount {
+		column = SortByAmount
+	}
+	direction, comparison := "ASC", ">"
+	if page.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if page.After != nil {
+		var after any = page.After.CreatedAt
+		if column == SortByAmount {
+			after = page.After.Amount
+		}
+		args = append(args, after, page.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, comparison, len(args)-1, len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, page.Limit)
+	query := fmt.Sprintf(`
+		SELECT id, amount, type, status, created_at, currency, gateway_name, country_name, user_id
+		FROM transactions
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT $%d
+	`, where, column, direction, direction, len(args))
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", err)
+	}
+	defer rows.Close()
+
+	transactions := make([]Transaction, 0, page.Limit)
+	for rows.Next() {
+		var transaction Transaction
+		if err = rows.Scan(&transaction.ID, &transaction.Amount, &transaction.Type, &transaction.Status, &transaction.CreatedAt,
+			&transaction.Currency, &transaction.GatewayName, &transaction.CountryName, &transaction.UserID); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transactions: %w", err)
+	}
+	return transactions, nil
+}
+
+// GetBalanceAt returns the balance of the user in currency at the given time, computed from the transactions in that
+// currency created before it as Transaction.BalanceEffect does
+func (p *DB) GetBalanceAt(ctx context.Context, userID int, currency string, at time.Time) (float64, error) {
+	query := `
+		SELECT COALESCE(SUM(CASE
+			WHEN type = 'deposit' AND LOWER(status) IN ('success', 'completed') THEN amount
+			WHEN type = 'withdrawal' AND LOWER(status) <> 'failed' THEN -amount
+			ELSE 0
+		END), 0)
+		FROM transactions
+		WHERE user_id = $1 AND UPPER(currency) = UPPER($2) AND created_at < $3
+	`
+
+	var balance float64
+	if err := p.db.QueryRowContext(ctx, query, userID, currency, at).Scan(&balance); err != nil {
+		return 0, fmt.Errorf("failed to compute balance: %w", err)
+	}
+	return balance, nil
+}
+
+func (f TransactionFilter) conditions() ([]string, []any) {
+	var conditions []string
+	var args []any
+
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.UserID != 0 {
+		add("user_id = $%d", f.UserID)
+	}
+	if f.Type != "" {
+		add("type = $%d", f.Type)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if f.Currency != "" {
+		add("UPPER(currency) = UPPER($%d)", f.Currency)
+	}
+	if f.GatewayName != "" {
+		add("LOWER(gateway_name) = LOWER($%d)", f.GatewayName)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if f.MinAmount != 0 {
+		add("amount >= $%d", f.MinAmount)
+	}
+	if f.MaxAmount != 0 {
+		add("amount <= $%d", f.MaxAmount)
+	}
+	return conditions, args
+}
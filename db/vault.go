@@ -0,0 +1,52 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// CreateVaultEntry stores the encrypted value of entry under entry.Token
+func (p *DB) CreateVaultEntry(entry VaultEntry) error {
+	query := `INSERT INTO vault_entries (token, user_id, value) VALUES ($1, $2, $3)`
+
+	if _, err := p.db.Exec(query, entry.Token, entry.UserID, entry.Value); err != nil {
+		return fmt.Errorf("failed to create vault entry: %w", err)
+	}
+	return nil
+}
+
+// AccessVaultEntry returns the entry stored under access.Token, recording the access in the same db transaction
+// so that no value is disclosed without a trace. It returns ErrDataNotFound for unknown tokens.
+func (p *DB) AccessVaultEntry(access VaultAccess) (VaultEntry, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return VaultEntry{}, fmt.Errorf("failed to begin db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var entry VaultEntry
+	err = tx.QueryRow(`SELECT id, token, user_id, value, created_at FROM vault_entries WHERE token = $1`, access.Token).
+		Scan(&entry.ID, &entry.Token, &entry.UserID, &entry.Value, &entry.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VaultEntry{}, ErrDataNotFound
+		}
+		return VaultEntry{}, fmt.Errorf("failed to get vault entry: %w", err)
+	}
+
+	var transactionID sql.NullInt64
+	if access.TransactionID > 0 {
+		transactionID = sql.NullInt64{Int64: int64(access.TransactionID), Valid: true}
+	}
+	_, err = tx.Exec(`INSERT INTO vault_access_log (vault_entry_id, accessor, transaction_id, purpose) VALUES ($1, $2, $3, $4)`,
+		entry.ID, access.Accessor, transactionID, access.Purpose)
+	if err != nil {
+		return VaultEntry{}, fmt.Errorf("failed to record vault access: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return VaultEntry{}, fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return entry, nil
+}
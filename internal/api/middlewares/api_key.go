@@ -0,0 +1,142 @@
+package middlewares
+
+import (
+	"errors"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/go-chi/chi/v5/middleware"
+	"net/http"
+	"strconv"
+)
+
+// Scopes granted to API keys
+const (
+	ScopeDepositCreate    = "deposit:create"
+	ScopeWithdrawalCreate = "withdrawal:create"
+	ScopeTransactionsRead = "transactions:read"
+	ScopeAccountsRead     = "accounts:read"
+)
+
+// Scopes lists all scopes an API key may be granted
+var Scopes = []string{ScopeDepositCreate, ScopeWithdrawalCreate, ScopeTransactionsRead, ScopeAccountsRead}
+
+const (
+	// APIKeyHeader carries the API key of server-to-server requests
+	APIKeyHeader = "X-API-Key"
+
+	// OnBehalfOfHeader carries the ID of the user an API key request is made on behalf of
+	OnBehalfOfHeader = "X-On-Behalf-Of"
+)
+
+// APIKeyStore looks up API keys and records their use
+type APIKeyStore interface {
+	GetAPIKeyByPrefix(prefix string) (db.APIKey, error)
+	TouchAPIKey(id int) error
+}
+
+// APIKeyAuthenticator authenticates merchants calling the API server-to-server on behalf of users
+type APIKeyAuthenticator struct {
+	store APIKeyStore
+	log   *logger.Logger
+}
+
+func NewAPIKeyAuthenticator(store APIKeyStore, log *logger.Logger) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{store: store, log: log}
+}
+
+// Authenticate is a middleware authenticating requests carrying an API key, on behalf of the user
+// identified by the OnBehalfOfHeader, and setting the resulting Principal in the request context.
+// Requests on behalf of a user the key is not bound to are rejected.
+// Requests without an API key are passed through, so that it composes with JWTAuthenticator.Authenticate.
+func (a *APIKeyAuthenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(APIKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiKey, err := a.Verify(key)
+		if err != nil {
+			if !errors.Is(err, ErrUnauthenticated) {
+				a.log.Error("error verifying API key", logger.NewField("Error", err.Error()))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := strconv.Atoi(r.Header.Get(OnBehalfOfHeader))
+		if err != nil || userID <= 0 {
+			http.Error(w, "missing or invalid "+OnBehalfOfHeader+" header", http.StatusBadRequest)
+			return
+		}
+		if !apiKey.ActsFor(userID) {
+			a.log.Warn("access denied", logger.ComponentAudit,
+				logger.NewField("Reason", "API key not bound to user"),
+				logger.NewField("User-ID", userID),
+				logger.NewField("API-Key-ID", apiKey.ID),
+				logger.NewField("Method", r.Method),
+				logger.NewField("Path", r.URL.Path),
+				logger.NewField("Remote-Addr", r.RemoteAddr),
+				logger.NewField("Request-ID", middleware.GetReqID(r.Context())))
+			http.Error(w, "API key may not act on behalf of this user", http.StatusForbidden)
+			return
+		}
+
+		if err = a.store.TouchAPIKey(apiKey.ID); err != nil {
+			a.log.Warn("error recording API key use", logger.NewField("Error", err.Error()),
+				logger.NewField("API-Key-Prefix", apiKey.Prefix))
+		}
+
+		principal := Principal{
+			UserID:   userID,
+			Subject:  apiKey.Name,
+			APIKeyID: apiKey.ID,
+			Scopes:   apiKey.Scopes,
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// Verify returns the active API key matching key.
+// It returns ErrUnauthenticated when key is unknown, revoked or expired.
+func (a *APIKeyAuthenticator) Verify(key string) (db.APIKey, error) {
+	prefix, err := services.APIKeyPrefix(key)
+	if err != nil {
+		return db.APIKey{}, ErrUnauthenticated
+	}
+
+	apiKey, err := a.store.GetAPIKeyByPrefix(prefix)
+	if err != nil {
+		if errors.Is(err, db.ErrDataNotFound) {
+			return db.APIKey{}, ErrUnauthenticated
+		}
+		return db.APIKey{}, err
+	}
+
+	if !services.VerifyAPIKey(key, apiKey.Hash) || !apiKey.IsActive() {
+		return db.APIKey{}, ErrUnauthenticated
+	}
+	return apiKey, nil
+}
+
+// RequireScope is a middleware rejecting requests whose principal is not granted scope
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := PrincipalFromContext(r.Context())
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !principal.HasScope(scope) {
+				http.Error(w, "API key lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type apiKeyStoreStub struct {
+	keys    map[string]db.APIKey
+	touched []int
+}
+
+func (s *apiKeyStoreStub) GetAPIKeyByPrefix(prefix string) (db.APIKey, error) {
+	key, ok := s.keys[prefix]
+	if !ok {
+		return db.APIKey{}, db.ErrDataNotFound
+	}
+	return key, nil
+}
+
+func (s *apiKeyStoreStub) TouchAPIKey(id int) error {
+	s.touched = append(s.touched, id)
+	return nil
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	plain, prefix, hash, err := services.GenerateAPIKey()
+	require.NoError(t, err)
+	rotatedPlain, rotatedPrefix, rotatedHash, err := services.GenerateAPIKey()
+	require.NoError(t, err)
+	revokedPlain, revokedPrefix, revokedHash, err := services.GenerateAPIKey()
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-time.Minute)
+	revoked := time.Now().Add(time.Hour)
+	store := &apiKeyStoreStub{keys: map[string]db.APIKey{
+		prefix:        {ID: 1, Name: "merchant", Prefix: prefix, Hash: hash, Scopes: []string{ScopeDepositCreate}, UserIDs: []int{42}},
+		rotatedPrefix: {ID: 2, Name: "merchant", Prefix: rotatedPrefix, Hash: rotatedHash, ExpiresAt: &expired, Expired: true, UserIDs: []int{42}},
+		revokedPrefix: {ID: 3, Name: "merchant", Prefix: revokedPrefix, Hash: revokedHash, RevokedAt: &revoked, UserIDs: []int{42}},
+	}}
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+
+	jwtAuthenticator, err := NewJWTAuthenticator(JWTConfig{Algorithms: []string{"HS256"}, HMACSecret: []byte("secret")})
+	require.NoError(t, err)
+
+	var principal Principal
+	handler := NewAPIKeyAuthenticator(store, log).Authenticate(jwtAuthenticator.Authenticate(
+		RequireScope(ScopeDepositCreate)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ = PrincipalFromContext(r.Context())
+		}))))
+
+	serve := func(key, onBehalfOf string) int {
+		req := httptest.NewRequest(http.MethodPost, "/deposit", nil)
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		if onBehalfOf != "" {
+			req.Header.Set(OnBehalfOfHeader, onBehalfOf)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(plain, "42"))
+	assert.Equal(t, Principal{UserID: 42, Subject: "merchant", APIKeyID: 1, Scopes: []string{ScopeDepositCreate}}, principal)
+	assert.Equal(t, []int{1}, store.touched)
+
+	assert.Equal(t, http.StatusBadRequest, serve(plain, ""))
+	assert.Equal(t, http.StatusForbidden, serve(plain, "43"), "the key is not bound to this user")
+	assert.Equal(t, http.StatusUnauthorized, serve(plain+"x", "42"))
+	assert.Equal(t, http.StatusUnauthorized, serve(rotatedPlain, "42"))
+
+	// a revoked key is rejected whatever the clock that recorded its revocation says
+	assert.Equal(t, http.StatusUnauthorized, serve(revokedPlain, "42"))
+
+	// without an API key, the request falls through to bearer token authentication
+	assert.Equal(t, http.StatusUnauthorized, serve("", "42"))
+}
+
+func TestRequireScope(t *testing.T) {
+	handler := RequireScope(ScopeWithdrawalCreate)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(principal Principal) int {
+		req := httptest.NewRequest(http.MethodPost, "/withdrawal", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(WithPrincipal(req.Context(), principal)))
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(Principal{UserID: 1}))
+	assert.Equal(t, http.StatusOK, serve(Principal{UserID: 1, APIKeyID: 1, Scopes: []string{ScopeWithdrawalCreate}}))
+	assert.Equal(t, http.StatusForbidden, serve(Principal{UserID: 1, APIKeyID: 1, Scopes: []string{ScopeDepositCreate}}))
+}
@@ -2,25 +2,225 @@ package middlewares
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type ContextKey string
 
-const userIDKey ContextKey = "user_id"
+const principalKey ContextKey = "principal"
+
+var ErrUnauthenticated = errors.New("request is not authenticated")
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+
+	// UserID is the ID of the user the request is made by or on behalf of
+	UserID int
+
+	// Subject is the subject the credentials were issued to
+	Subject string
+
+	// APIKeyID is the ID of the API key the request was authenticated with, or 0 for end users
+	APIKeyID int
+
+	// Scopes restricts what an API key may be used for. End users are not restricted by scopes.
+	Scopes []string
+}
+
+// HasScope reports whether the principal may perform operations requiring scope
+func (p Principal) HasScope(scope string) bool {
+	if p.APIKeyID == 0 {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPrincipal returns a copy of ctx carrying principal
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFromContext returns the principal set by an authentication middleware
+func PrincipalFromContext(ctx context.Context) (Principal, error) {
+	principal, ok := ctx.Value(principalKey).(Principal)
+	if !ok {
+		return Principal{}, ErrUnauthenticated
+	}
+	return principal, nil
+}
+
+// JWTConfig configures how bearer tokens are validated
+type JWTConfig struct {
+
+	// Algorithms lists the accepted signing algorithms, among HS256, RS256 and EdDSA
+	Algorithms []string
+
+	// HMACSecret verifies HS256 signatures
+	HMACSecret []byte
+
+	// JWKSURL locates the JSON Web Key Set verifying RS256 and EdDSA signatures.
+	// It is either an http(s) URL or a file path, optionally prefixed with file://
+	JWKSURL string
+
+	// JWKSCacheTTL is how long a fetched key set is used before being fetched again
+	JWKSCacheTTL time.Duration
+
+	// Issuer and Audience are required to match the iss and aud claims when set
+	Issuer   string
+	Audience string
+
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat claims
+	Leeway time.Duration
+}
+
+// JWTAuthenticator authenticates requests bearing a JSON Web Token whose subject is a user ID
+type JWTAuthenticator struct {
+	config JWTConfig
+	parser *jwt.Parser
+	keys   *jwks
+}
 
-func Authenticate(next http.Handler) http.Handler {
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+	if len(config.Algorithms) == 0 {
+		return nil, errors.New("no JWT signing algorithm configured")
+	}
+	for _, alg := range config.Algorithms {
+		switch alg {
+		case jwt.SigningMethodHS256.Alg():
+			if len(config.HMACSecret) == 0 {
+				return nil, errors.New("HS256 requires an HMAC secret")
+			}
+		case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg():
+			if config.JWKSURL == "" {
+				return nil, fmt.Errorf("%s requires a JWKS URL", alg)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported JWT signing algorithm: %s", alg)
+		}
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(config.Algorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(config.Leeway),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	authenticator := &JWTAuthenticator{
+		config: config,
+		parser: jwt.NewParser(options...),
+	}
+	if config.JWKSURL != "" {
+		authenticator.keys = newJWKS(config.JWKSURL, config.JWKSCacheTTL)
+	}
+	return authenticator, nil
+}
+
+// Authenticate is a middleware rejecting requests without a valid bearer token,
+// and setting the Principal the token was issued to in the request context.
+// Requests already authenticated by a preceding middleware are passed through.
+func (a *JWTAuthenticator) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.WithValue(r.Context(), userIDKey, 1)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		if _, err := PrincipalFromContext(r.Context()); err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		principal, err := a.Verify(r.Context(), token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
 	})
 }
 
+// Verify validates token and returns the Principal it was issued to
+func (a *JWTAuthenticator) Verify(ctx context.Context, token string) (Principal, error) {
+	var claims jwt.RegisteredClaims
+	_, err := a.parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return a.verificationKey(ctx, t)
+	})
+	if err != nil {
+		return Principal{}, fmt.Errorf("invalid token: %w", err)
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return Principal{}, fmt.Errorf("invalid token subject: %q", claims.Subject)
+	}
+	return Principal{UserID: userID, Subject: claims.Subject}, nil
+}
+
+// verificationKey returns the key verifying the signature of t.
+// Keys are selected by the algorithm family so that, e.g., an RSA public key is never used as an HMAC secret.
+func (a *JWTAuthenticator) verificationKey(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return a.config.HMACSecret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodEd25519:
+		kid, _ := t.Header["kid"].(string)
+		key, err := a.keys.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if _, ok := t.Method.(*jwt.SigningMethodRSA); ok {
+				return key, nil
+			}
+		case ed25519.PublicKey:
+			if _, ok := t.Method.(*jwt.SigningMethodEd25519); ok {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("key %q cannot verify %s signatures", kid, t.Method.Alg())
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %s", t.Method.Alg())
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
 func getUserIDFromContext(ctx context.Context) (string, error) {
-	userID, ok := ctx.Value(userIDKey).(string)
-	if !ok {
-		return "", errors.New("user_id not found in context")
+	principal, err := PrincipalFromContext(ctx)
+	if err != nil {
+		return "", err
 	}
-	return userID, nil
+	return strconv.Itoa(principal.UserID), nil
 }
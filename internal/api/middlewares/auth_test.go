@@ -0,0 +1,115 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTAuthenticator_HS256(t *testing.T) {
+	secret := []byte("test-secret")
+	authenticator, err := NewJWTAuthenticator(JWTConfig{
+		Algorithms: []string{"HS256"},
+		HMACSecret: secret,
+		Issuer:     "https://auth.example.com",
+		Audience:   "payment-gateways",
+	})
+	require.NoError(t, err)
+
+	sign := func(claims jwt.RegisteredClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		require.NoError(t, err)
+		return token
+	}
+	valid := jwt.RegisteredClaims{
+		Subject:   "7",
+		Issuer:    "https://auth.example.com",
+		Audience:  jwt.ClaimStrings{"payment-gateways"},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+
+	principal, err := authenticator.Verify(context.Background(), sign(valid))
+	require.NoError(t, err)
+	assert.Equal(t, 7, principal.UserID)
+
+	wrongAudience := valid
+	wrongAudience.Audience = jwt.ClaimStrings{"another-service"}
+	_, err = authenticator.Verify(context.Background(), sign(wrongAudience))
+	assert.Error(t, err)
+
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	_, err = authenticator.Verify(context.Background(), sign(expired))
+	assert.Error(t, err)
+
+	withoutExpiry := valid
+	withoutExpiry.ExpiresAt = nil
+	_, err = authenticator.Verify(context.Background(), sign(withoutExpiry))
+	assert.Error(t, err)
+}
+
+func TestJWTAuthenticator_EdDSAFromJWKSFile(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kid": "key-1", "kty": "OKP", "crv": "Ed25519", "use": "sig",
+		"x": base64.RawURLEncoding.EncodeToString(publicKey),
+	}}})
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(jwksPath, jwks, 0600))
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{
+		Algorithms:   []string{"EdDSA"},
+		JWKSURL:      "file://" + jwksPath,
+		JWKSCacheTTL: time.Minute,
+	})
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.RegisteredClaims{
+		Subject:   "3",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString(privateKey)
+	require.NoError(t, err)
+
+	handler := authenticator.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := PrincipalFromContext(r.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 3, principal.UserID)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/deposit", nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// an HS256 token must not be accepted when only EdDSA is allowed
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "3",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}).SignedString([]byte(publicKey))
+	require.NoError(t, err)
+
+	req = httptest.NewRequest(http.MethodPost, "/deposit", nil)
+	req.Header.Set("Authorization", "Bearer "+forged)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
@@ -0,0 +1,114 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"net/http"
+	"os"
+)
+
+const callbackGatewayKey ContextKey = "callback-gateway"
+
+// GatewayCertificates lists the client certificates a payment gateway posts callbacks with,
+// identified by subject distinguished name or by SPKI pin (base64 encoded SHA-256 of the public key)
+type GatewayCertificates struct {
+	Gateway  string   `json:"gateway"`
+	Subjects []string `json:"subjects"`
+	SPKIPins []string `json:"spki_pins"`
+}
+
+// ClientCertIdentifier identifies the payment gateway making a callback request from its TLS client certificate
+type ClientCertIdentifier struct {
+	bySubject map[string]string
+	byPin     map[string]string
+	log       *logger.Logger
+}
+
+// LoadClientCertIdentifier reads a JSON array of GatewayCertificates from path
+func LoadClientCertIdentifier(path string, log *logger.Logger) (*ClientCertIdentifier, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading gateway certificates: %w", err)
+	}
+	var gateways []GatewayCertificates
+	if err = json.Unmarshal(raw, &gateways); err != nil {
+		return nil, fmt.Errorf("error parsing gateway certificates: %w", err)
+	}
+	return NewClientCertIdentifier(gateways, log)
+}
+
+func NewClientCertIdentifier(gateways []GatewayCertificates, log *logger.Logger) (*ClientCertIdentifier, error) {
+	identifier := &ClientCertIdentifier{
+		bySubject: make(map[string]string),
+		byPin:     make(map[string]string),
+		log:       log,
+	}
+
+	for _, gateway := range gateways {
+		for _, subject := range gateway.Subjects {
+			if other, ok := identifier.bySubject[subject]; ok && other != gateway.Gateway {
+				return nil, fmt.Errorf("subject %q is mapped to both %s and %s", subject, other, gateway.Gateway)
+			}
+			identifier.bySubject[subject] = gateway.Gateway
+		}
+		for _, pin := range gateway.SPKIPins {
+			if digest, err := base64.StdEncoding.DecodeString(pin); err != nil || len(digest) != sha256.Size {
+				return nil, fmt.Errorf("invalid SPKI pin %q of %s", pin, gateway.Gateway)
+			}
+			if other, ok := identifier.byPin[pin]; ok && other != gateway.Gateway {
+				return nil, fmt.Errorf("SPKI pin %q is mapped to both %s and %s", pin, other, gateway.Gateway)
+			}
+			identifier.byPin[pin] = gateway.Gateway
+		}
+	}
+	return identifier, nil
+}
+
+// Identify is a middleware rejecting requests without a verified client certificate mapped to a payment gateway,
+// and setting the name of that gateway in the request context
+func (c *ClientCertIdentifier) Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		certificate := r.TLS.VerifiedChains[0][0]
+		gateway, ok := c.Gateway(certificate)
+		if !ok {
+			c.log.Warn("callback with unknown client certificate", logger.ComponentAudit,
+				logger.NewField("Subject", certificate.Subject.String()), logger.NewField("SPKI-Pin", SPKIPin(certificate)),
+				logger.NewField("Path", r.URL.Path), logger.NewField("Remote-Addr", r.RemoteAddr))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callbackGatewayKey, gateway)))
+	})
+}
+
+// Gateway returns the payment gateway certificate is mapped to, matching its SPKI pin first
+func (c *ClientCertIdentifier) Gateway(certificate *x509.Certificate) (string, bool) {
+	if gateway, ok := c.byPin[SPKIPin(certificate)]; ok {
+		return gateway, true
+	}
+	gateway, ok := c.bySubject[certificate.Subject.String()]
+	return gateway, ok
+}
+
+// CallbackGatewayFromContext returns the payment gateway identified by ClientCertIdentifier.Identify, if any
+func CallbackGatewayFromContext(ctx context.Context) (string, bool) {
+	gateway, ok := ctx.Value(callbackGatewayKey).(string)
+	return gateway, ok
+}
+
+// SPKIPin returns the base64 encoded SHA-256 of the public key of certificate
+func SPKIPin(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,74 @@
+package middlewares
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func selfSignedCertificate(t *testing.T, subject pkix.Name) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return certificate
+}
+
+func TestClientCertIdentifier(t *testing.T) {
+	stripe := selfSignedCertificate(t, pkix.Name{CommonName: "callbacks.stripe.com", Organization: []string{"Stripe"}})
+	paypal := selfSignedCertificate(t, pkix.Name{CommonName: "callbacks.paypal.com"})
+	unknown := selfSignedCertificate(t, pkix.Name{CommonName: "attacker.example.com"})
+
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+	identifier, err := NewClientCertIdentifier([]GatewayCertificates{
+		{Gateway: "Stripe", Subjects: []string{stripe.Subject.String()}},
+		{Gateway: "PayPal", SPKIPins: []string{SPKIPin(paypal)}},
+	}, log)
+	require.NoError(t, err)
+
+	var gateway string
+	handler := identifier.Identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gateway, _ = CallbackGatewayFromContext(r.Context())
+	}))
+
+	serve := func(certificate *x509.Certificate) int {
+		req := httptest.NewRequest(http.MethodPut, "/deposit/1", nil)
+		if certificate != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(stripe))
+	assert.Equal(t, "Stripe", gateway)
+	assert.Equal(t, http.StatusOK, serve(paypal))
+	assert.Equal(t, "PayPal", gateway)
+	assert.Equal(t, http.StatusForbidden, serve(unknown))
+	assert.Equal(t, http.StatusUnauthorized, serve(nil))
+
+	_, err = NewClientCertIdentifier([]GatewayCertificates{{Gateway: "Stripe", SPKIPins: []string{"not-a-pin"}}}, log)
+	assert.Error(t, err)
+}
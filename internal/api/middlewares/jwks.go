@@ -0,0 +1,220 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minJWKSRefreshInterval limits how often the key set is fetched again, when a key ID is unknown or a fetch failed
+const minJWKSRefreshInterval = 10 * time.Second
+
+// jwks is a cached JSON Web Key Set (RFC 7517) loaded from a URL or a file
+type jwks struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+
+	// attemptedAt is when the key set was last fetched, successfully or not, and fetchErr the error of that fetch
+	attemptedAt time.Time
+	fetchErr    error
+
+	// fetching is closed once the fetch in progress, if any, completes
+	fetching chan struct{}
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+
+	// RSA public key
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// OKP (Ed25519) public key
+	X string `json:"x"`
+}
+
+func newJWKS(url string, ttl time.Duration) *jwks {
+	return &jwks{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// key returns the public key identified by kid, fetching the key set when the cached one has expired
+// or does not contain kid. An empty kid is accepted when the set holds a single key.
+// A single fetch runs at a time, outside the lock, and fetches are at least minJWKSRefreshInterval apart
+// so that an unavailable source does not stall every request.
+func (j *jwks) key(ctx context.Context, kid string) (interface{}, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for {
+		stale := j.keys == nil || time.Since(j.fetchedAt) > j.ttl
+		if !stale {
+			if key, ok := j.lookup(kid); ok {
+				return key, nil
+			}
+			stale = time.Since(j.fetchedAt) > minJWKSRefreshInterval
+		}
+		if !stale {
+			break
+		}
+
+		if j.fetching != nil {
+			// wait for the fetch in progress rather than starting another one
+			fetching := j.fetching
+			j.mu.Unlock()
+			select {
+			case <-fetching:
+			case <-ctx.Done():
+				j.mu.Lock()
+				return nil, ctx.Err()
+			}
+			j.mu.Lock()
+			continue
+		}
+		if time.Since(j.attemptedAt) < minJWKSRefreshInterval {
+			break
+		}
+
+		j.fetching = make(chan struct{})
+		j.attemptedAt = time.Now()
+		j.mu.Unlock()
+		// the fetch is shared with the requests waiting for it, so it outlives the cancellation of this one
+		keys, err := j.fetch(context.WithoutCancel(ctx))
+		j.mu.Lock()
+
+		j.fetchErr = err
+		if err == nil {
+			j.keys = keys
+			j.fetchedAt = time.Now()
+		}
+		close(j.fetching)
+		j.fetching = nil
+		break
+	}
+
+	// keep verifying with the previous key set while the source is unavailable
+	if j.keys == nil && j.fetchErr != nil {
+		return nil, j.fetchErr
+	}
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (j *jwks) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+func (j *jwks) fetch(ctx context.Context) (map[string]interface{}, error) {
+	var raw []byte
+	var err error
+
+	if strings.HasPrefix(j.url, "http://") || strings.HasPrefix(j.url, "https://") {
+		raw, err = j.download(ctx)
+	} else {
+		raw, err = os.ReadFile(strings.TrimPrefix(j.url, "file://"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching JWKS: %w", err)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("error parsing JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if (jwk.Use != "" && jwk.Use != "sig") || (jwk.Kty != "RSA" && jwk.Kty != "OKP") {
+			continue
+		}
+		// a key that cannot be parsed is skipped, so that it does not prevent verifying with the others
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (j *jwks) download(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
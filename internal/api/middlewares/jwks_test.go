@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKS_SkipsInvalidKeys(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kid": "broken", "kty": "OKP", "crv": "Ed25519", "x": "not base64!"},
+			{"kid": "key-1", "kty": "OKP", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(publicKey)},
+		}})
+	}))
+	defer server.Close()
+
+	set := newJWKS(server.URL, time.Minute)
+	key, err := set.key(context.Background(), "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, ed25519.PublicKey(publicKey), key)
+
+	_, err = set.key(context.Background(), "broken")
+	assert.ErrorContains(t, err, "unknown key id")
+}
+
+func TestJWKS_UnavailableSource(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	set := newJWKS(server.URL, time.Minute)
+
+	// concurrent requests share a single fetch
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = set.key(context.Background(), "key-1")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for _, err := range errs {
+		assert.ErrorContains(t, err, "unexpected status 503")
+	}
+
+	// the failure is not retried before minJWKSRefreshInterval
+	_, err := set.key(context.Background(), "key-1")
+	assert.ErrorContains(t, err, "unexpected status 503")
+	assert.Equal(t, int32(1), fetches.Load())
+}
@@ -3,8 +3,10 @@ package middlewares
 import (
 	"context"
 	"fmt"
+	"github.com/ercross/payment_gateways/internal/metrics"
 	"github.com/redis/go-redis/v9"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,6 +22,13 @@ const serviceName = "payment-gateways"
 type DistributedRateLimiter struct {
 	client *redis.Client
 
+	// name keeps the counters of this limiter apart from those of the other limiters
+	name  string
+	limit atomic.Pointer[rateLimit]
+}
+
+type rateLimit struct {
+
 	// Maximum number of requests allowed within window
 	requestLimit int
 
@@ -27,12 +36,15 @@ type DistributedRateLimiter struct {
 	window time.Duration
 }
 
-func NewDistributedRateLimiter(client *redis.Client, requestLimit int, window time.Duration) *DistributedRateLimiter {
-	return &DistributedRateLimiter{
-		client:       client,
-		requestLimit: requestLimit,
-		window:       window,
-	}
+func NewDistributedRateLimiter(client *redis.Client, name string, requestLimit int, window time.Duration) *DistributedRateLimiter {
+	rl := &DistributedRateLimiter{client: client, name: name}
+	rl.SetLimit(requestLimit, window)
+	return rl
+}
+
+// SetLimit changes the limit applied to the following requests. Windows already started keep their expiry.
+func (rl *DistributedRateLimiter) SetLimit(requestLimit int, window time.Duration) {
+	rl.limit.Store(&rateLimit{requestLimit: requestLimit, window: window})
 }
 
 func (rl *DistributedRateLimiter) Middleware(next http.Handler) http.Handler {
@@ -51,6 +63,7 @@ func (rl *DistributedRateLimiter) Middleware(next http.Handler) http.Handler {
 		}
 
 		if !allowed {
+			metrics.RateLimitRejected()
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
@@ -63,6 +76,7 @@ func (rl *DistributedRateLimiter) Middleware(next http.Handler) http.Handler {
 func (rl *DistributedRateLimiter) allow(ctx context.Context, key string) (bool, error) {
 
 	key = rl.constructRateLimitKey(key)
+	limit := rl.limit.Load()
 
 	// Increment the count for the current window
 	count, err := rl.client.Incr(ctx, key).Result()
@@ -72,15 +86,15 @@ func (rl *DistributedRateLimiter) allow(ctx context.Context, key string) (bool,
 
 	// Set an expiration on the key if this is the first request
 	if count == 1 {
-		if err = rl.client.Expire(ctx, key, rl.window).Err(); err != nil {
+		if err = rl.client.Expire(ctx, key, limit.window).Err(); err != nil {
 			return false, err
 		}
 	}
 
 	// Check if the count exceeds the limit
-	return count <= int64(rl.requestLimit), nil
+	return count <= int64(limit.requestLimit), nil
 }
 
 func (rl *DistributedRateLimiter) constructRateLimitKey(key string) string {
-	return fmt.Sprintf("ratelimit_%s:%s", serviceName, key)
+	return fmt.Sprintf("ratelimit_%s:%s:%s", serviceName, rl.name, key)
 }
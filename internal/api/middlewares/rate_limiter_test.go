@@ -35,7 +35,7 @@ func TestDistributedRateLimiter_Middleware(t *testing.T) {
 	redisClient := mustConnectRedis()
 	defer redisClient.Close()
 
-	drl := NewDistributedRateLimiter(redisClient, 5, time.Second*10)
+	drl := NewDistributedRateLimiter(redisClient, "test", 5, time.Second*10)
 
 	handler := drl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -58,6 +58,7 @@ func TestDistributedRateLimiter_Middleware(t *testing.T) {
 				time.Sleep(time.Millisecond * time.Duration(rand.Intn(100)))
 
 				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req = req.WithContext(WithPrincipal(req.Context(), Principal{UserID: 1}))
 				w := httptest.NewRecorder()
 
 				handler.ServeHTTP(w, req)
@@ -0,0 +1,96 @@
+package middlewares
+
+import (
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/go-chi/chi/v5/middleware"
+	"net/http"
+	"slices"
+)
+
+// Permissions granted to users through roles
+const (
+	PermissionGatewaysRead     = "gateways:read"
+	PermissionGatewaysWrite    = "gateways:write"
+	PermissionTransactionsRead = "transactions:read"
+	PermissionRefundsWrite     = "refunds:write"
+	PermissionAccountsRead     = "accounts:read"
+	PermissionAccountsWrite    = "accounts:write"
+	PermissionLogsRead         = "logs:read"
+	PermissionLogsWrite        = "logs:write"
+	PermissionConfigRead       = "config:read"
+)
+
+// PermissionStore resolves the permissions granted to a user through their roles
+type PermissionStore interface {
+	GetUserPermissions(userID int) ([]string, error)
+}
+
+// Authorizer restricts routes to principals granted a permission, auditing denied attempts
+type Authorizer struct {
+	store PermissionStore
+	log   *logger.Logger
+}
+
+func NewAuthorizer(store PermissionStore, log *logger.Logger) *Authorizer {
+	return &Authorizer{store: store, log: log}
+}
+
+// Permissions returns the permissions granted to principal.
+// API keys are restricted to their scopes and are never granted permissions.
+func (a *Authorizer) Permissions(principal Principal) ([]string, error) {
+	if principal.APIKeyID != 0 {
+		return nil, nil
+	}
+	return a.store.GetUserPermissions(principal.UserID)
+}
+
+// HasPermission reports whether principal is granted permission
+func (a *Authorizer) HasPermission(principal Principal, permission string) (bool, error) {
+	permissions, err := a.Permissions(principal)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(permissions, permission), nil
+}
+
+// RequirePermission is a middleware rejecting requests whose principal is not granted permission
+func (a *Authorizer) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := PrincipalFromContext(r.Context())
+			if err != nil {
+				a.audit(r, principal, permission, "unauthenticated")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			granted, err := a.HasPermission(principal, permission)
+			if err != nil {
+				a.log.Error("error resolving permissions", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+					logger.NewField("User-ID", principal.UserID), logger.NewField("Request-ID", middleware.GetReqID(r.Context())))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			if !granted {
+				a.audit(r, principal, permission, "permission not granted")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// audit records an access attempt denied for reason
+func (a *Authorizer) audit(r *http.Request, principal Principal, permission, reason string) {
+	a.log.Warn("access denied", logger.ComponentAudit,
+		logger.NewField("Reason", reason),
+		logger.NewField("Permission", permission),
+		logger.NewField("User-ID", principal.UserID),
+		logger.NewField("API-Key-ID", principal.APIKeyID),
+		logger.NewField("Method", r.Method),
+		logger.NewField("Path", r.URL.Path),
+		logger.NewField("Remote-Addr", r.RemoteAddr),
+		logger.NewField("Request-ID", middleware.GetReqID(r.Context())))
+}
@@ -0,0 +1,57 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type permissionStoreStub map[int][]string
+
+func (s permissionStoreStub) GetUserPermissions(userID int) ([]string, error) {
+	return s[userID], nil
+}
+
+func TestAuthorizer_RequirePermission(t *testing.T) {
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+
+	authorizer := NewAuthorizer(permissionStoreStub{
+		1: {PermissionGatewaysRead, PermissionGatewaysWrite},
+		2: {PermissionGatewaysRead},
+	}, log)
+	handler := authorizer.RequirePermission(PermissionGatewaysWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(principal *Principal) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/gateway-priorities", nil)
+		if principal != nil {
+			req = req.WithContext(WithPrincipal(req.Context(), *principal))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(&Principal{UserID: 1}))
+	assert.Equal(t, http.StatusForbidden, serve(&Principal{UserID: 2}))
+	assert.Equal(t, http.StatusForbidden, serve(&Principal{UserID: 1, APIKeyID: 1, Scopes: Scopes}))
+	assert.Equal(t, http.StatusUnauthorized, serve(nil))
+}
+
+func TestAuthorizer_APIKeyPrincipal(t *testing.T) {
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+	authorizer := NewAuthorizer(permissionStoreStub{1: {PermissionTransactionsRead}}, log)
+
+	// an API key acting on behalf of a staff user is not granted the staff permissions
+	staff, err := authorizer.HasPermission(Principal{UserID: 1}, PermissionTransactionsRead)
+	require.NoError(t, err)
+	assert.True(t, staff)
+	staff, err = authorizer.HasPermission(Principal{UserID: 1, APIKeyID: 1, Scopes: Scopes}, PermissionTransactionsRead)
+	require.NoError(t, err)
+	assert.False(t, staff)
+}
@@ -4,9 +4,15 @@ import (
 	"github.com/ercross/payment_gateways/db"
 	"github.com/ercross/payment_gateways/internal/api/middlewares"
 	v1 "github.com/ercross/payment_gateways/internal/api/v1"
-	"github.com/ercross/payment_gateways/internal/kafka"
+	"github.com/ercross/payment_gateways/internal/config"
+	"github.com/ercross/payment_gateways/internal/health"
 	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/metrics"
+	"github.com/ercross/payment_gateways/internal/mfa"
 	cache "github.com/ercross/payment_gateways/internal/redis"
+	"github.com/ercross/payment_gateways/internal/settings"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	"github.com/ercross/payment_gateways/internal/vault"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"net/http"
@@ -15,19 +21,47 @@ import (
 func NewServer(
 	repo db.Repository,
 	log *logger.Logger,
-	publisher kafka.EventPublisher,
 	dstrCache cache.DistributedCache,
 	dstrRL *middlewares.DistributedRateLimiter,
-	baseURL string,
+	mfaRL *middlewares.DistributedRateLimiter,
+	authenticator *middlewares.JWTAuthenticator,
+	apiKeys *middlewares.APIKeyAuthenticator,
+	totp *mfa.TOTP,
+	tokenizer vault.Tokenizer,
+	detokenizer vault.Detokenizer,
+	authorizer *middlewares.Authorizer,
+	gatewayIdentifier *middlewares.ClientCertIdentifier,
+	store *settings.Store,
+	cfg *config.Config,
 ) http.Handler {
 	mux := chi.NewRouter()
 	mux.Use(middleware.RequestID)
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
 	mux.Use(logger.RequestLogger(log))
 	mux.Use(middleware.Recoverer)
-	mux.Use(middlewares.CORSMiddleware(baseURL))
+	mux.Use(middlewares.CORSMiddleware(cfg.Server.BaseURL))
 	mux.Use(middlewares.SecurityMiddleware)
 
-	mux.Mount("/api/v1", v1.AddRoutes(repo, log, publisher, dstrCache, dstrRL, baseURL))
+	mux.Get("/healthz", health.LivenessHandler())
+	mux.Mount("/api/v1", v1.AddRoutes(repo, log, dstrCache, dstrRL, mfaRL, authenticator, apiKeys, totp, tokenizer, detokenizer, authorizer, gatewayIdentifier, store, cfg))
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	return mux
+}
+
+// NewMetricsServer serves the Prometheus metrics and the dependency checks. The metrics include transaction amounts
+// by currency and country, and the checks reach every dependency and report their errors, so it is meant to listen
+// on a port reachable by the metrics collector and the orchestrator only, apart from the API.
+func NewMetricsServer(checker *health.Checker) http.Handler {
+	mux := chi.NewRouter()
+	mux.Use(middleware.Recoverer)
+
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Get("/readyz", checker.ReadinessHandler())
+	mux.Get("/status", checker.StatusHandler())
 	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 	})
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ercross/payment_gateways/internal/config"
+	"github.com/ercross/payment_gateways/internal/health"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsServedApart(t *testing.T) {
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+	metrics.RateLimitRejected()
+
+	serve := func(handler http.Handler, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	// metrics expose transaction amounts by currency and country, and the dependency checks reach every dependency
+	// and report their errors, so the API does not serve them
+	public := NewServer(nil, log, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, &config.Config{})
+	for _, path := range []string{"/metrics", "/readyz", "/status"} {
+		assert.Equal(t, http.StatusNotFound, serve(public, path).Code, path)
+	}
+	assert.Equal(t, http.StatusOK, serve(public, "/healthz").Code)
+
+	private := NewMetricsServer(health.NewChecker())
+	rr := serve(private, "/metrics")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "payment_gateways_")
+	assert.Equal(t, http.StatusOK, serve(private, "/readyz").Code)
+	assert.Equal(t, http.StatusOK, serve(private, "/status").Code)
+}
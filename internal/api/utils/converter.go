@@ -23,3 +23,17 @@ func ConvertWithdrawalRequestToTransaction(wr dto.WithdrawalRequest) db.Transact
 		UserID: wr.UserID,
 	}
 }
+
+func ConvertTransactionToDTO(trx db.Transaction) dto.Transaction {
+	return dto.Transaction{
+		ID:        trx.ID,
+		UserID:    trx.UserID,
+		Type:      trx.Type,
+		Status:    trx.Status,
+		Amount:    trx.Amount,
+		Currency:  trx.Currency,
+		Gateway:   trx.GatewayName,
+		Country:   trx.CountryName,
+		CreatedAt: trx.CreatedAt,
+	}
+}
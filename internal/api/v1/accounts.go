@@ -0,0 +1,278 @@
+package v1
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/api/middlewares"
+	"github.com/ercross/payment_gateways/internal/api/utils"
+	"github.com/ercross/payment_gateways/internal/api/v1/dto"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxStatementPeriod bounds the period of a statement, so that it is computed in a single request
+	maxStatementPeriod = 366 * 24 * time.Hour
+
+	statementPageSize = 500
+)
+
+// getAccount responds with the balance of the authenticated user
+//
+// Sample Request (GET /accounts/me)
+func getAccount(repo db.Repository, log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		principal, err := middlewares.PrincipalFromContext(r.Context())
+		if err != nil {
+			sendAuthorizationError(w, r, err, dataFormat)
+			return
+		}
+
+		account, ok := userAccount(w, r, repo, log, principal.UserID, dataFormat)
+		if !ok {
+			return
+		}
+
+		sendAPIResponse(w, r, http.StatusOK, "Account retrieved", dto.Account{
+			UserID:    account.UserID,
+			Balance:   account.Balance,
+			Currency:  strings.TrimSpace(account.Currency),
+			UpdatedAt: account.UpdatedAt,
+		}, dataFormat)
+	}
+}
+
+// getStatement responds with the statement of the authenticated user from the from date, inclusive, to the to date,
+// exclusive, by default the current month. Balances are computed from the transaction history in the account currency,
+// as db.Transaction.BalanceEffect does. They do not reconcile with the balance getAccount responds with, which is the
+// stored balance: it is not debited when a withdrawal is initiated, is credited when a withdrawal fails, and includes
+// any balance the account was seeded with. The statement is exported as CSV when format is csv or text/csv is
+// accepted, and as JSON or XML otherwise.
+//
+// Sample Request (GET /accounts/me/statement?from=2024-01-01&to=2024-02-01&format=csv)
+func getStatement(repo db.Repository, log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		exportCSV, err := statementFormat(r)
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+			return
+		}
+
+		from, to, err := statementPeriod(r, time.Now().UTC())
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+			return
+		}
+
+		principal, err := middlewares.PrincipalFromContext(r.Context())
+		if err != nil {
+			sendAuthorizationError(w, r, err, dataFormat)
+			return
+		}
+
+		account, ok := userAccount(w, r, repo, log, principal.UserID, dataFormat)
+		if !ok {
+			return
+		}
+
+		currency := strings.TrimSpace(account.Currency)
+		opening, err := repo.GetBalanceAt(r.Context(), principal.UserID, currency, from)
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to compute opening balance", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+				logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
+		var transactions []db.Transaction
+		filter := db.TransactionFilter{UserID: principal.UserID, Currency: currency, From: from, To: to}
+		page := db.TransactionPage{SortBy: db.SortByCreatedAt, Limit: statementPageSize}
+		for {
+			batch, err := repo.ListTransactions(r.Context(), filter, page)
+			if err != nil {
+				sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+				log.Error("failed to list transactions", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+					logger.NewField("Request-ID", requestID(r)))
+				return
+			}
+			transactions = append(transactions, batch...)
+			if len(batch) < page.Limit {
+				break
+			}
+			page.After = &batch[len(batch)-1]
+		}
+
+		statement := buildStatement(principal.UserID, currency, from, to, opening, transactions)
+		if exportCSV {
+			writeStatementCSV(w, r, log, statement)
+			return
+		}
+		sendAPIResponse(w, r, http.StatusOK, "Statement retrieved", statement, dataFormat)
+	}
+}
+
+// userAccount returns the account of the user. It responds to the request itself when it returns false.
+func userAccount(
+	w http.ResponseWriter,
+	r *http.Request,
+	repo db.Repository,
+	log *logger.Logger,
+	userID int,
+	dataFormat dto.DataFormat,
+) (*db.UserAccount, bool) {
+	account, err := repo.GetUserAccount(userID)
+	if errors.Is(err, db.ErrDataNotFound) {
+		sendAPIResponse(w, r, http.StatusNotFound, "Account not found", nil, dataFormat)
+		return nil, false
+	}
+	if err != nil {
+		sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+		log.Error("failed to get user account", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+			logger.NewField("Request-ID", requestID(r)))
+		return nil, false
+	}
+	return account, true
+}
+
+// statementFormat reports whether the statement is exported as CSV rather than in the response data format
+func statementFormat(r *http.Request) (bool, error) {
+	switch format := strings.ToLower(r.URL.Query().Get("format")); format {
+	case "csv":
+		return true, nil
+	case "json", "xml":
+		return false, nil
+	case "":
+		return strings.Contains(r.Header.Get("Accept"), "text/csv"), nil
+	default:
+		return false, errors.New("format must be csv, json or xml")
+	}
+}
+
+// statementPeriod returns the period in the from and to query parameters, by default the month of now
+func statementPeriod(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	from, err := parseQueryTime(query, "from")
+	if err != nil {
+		return from, from, err
+	}
+	to, err := parseQueryTime(query, "to")
+	if err != nil {
+		return from, to, err
+	}
+
+	if from.IsZero() {
+		from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	if to.IsZero() {
+		to = now
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("from must be before to")
+	}
+	if to.Sub(from) > maxStatementPeriod {
+		return from, to, errors.New("statements cover a year at most")
+	}
+	return from, to, nil
+}
+
+// buildStatement lists the transactions changing the balance, oldest first, with the balance after each of them
+func buildStatement(userID int, currency string, from, to time.Time, opening float64, transactions []db.Transaction) dto.Statement {
+	statement := dto.Statement{
+		UserID:         userID,
+		Currency:       currency,
+		From:           from,
+		To:             to,
+		OpeningBalance: roundCents(opening),
+		Entries:        make([]dto.StatementEntry, 0, len(transactions)),
+	}
+
+	balance := statement.OpeningBalance
+	for _, trx := range transactions {
+		effect := trx.BalanceEffect()
+		if effect == 0 {
+			continue
+		}
+		balance = roundCents(balance + effect)
+
+		entry := dto.StatementEntry{
+			Date:          trx.CreatedAt,
+			TransactionID: trx.ID,
+			Description:   statementDescription(trx),
+			Status:        trx.Status,
+			Balance:       balance,
+		}
+		if effect > 0 {
+			entry.Credit = effect
+			statement.TotalCredits = roundCents(statement.TotalCredits + effect)
+		} else {
+			entry.Debit = -effect
+			statement.TotalDebits = roundCents(statement.TotalDebits - effect)
+		}
+		statement.Entries = append(statement.Entries, entry)
+	}
+	statement.ClosingBalance = balance
+	return statement
+}
+
+func statementDescription(trx db.Transaction) string {
+	description := "Deposit"
+	if trx.Type == "withdrawal" {
+		description = "Withdrawal"
+	}
+	if trx.GatewayName != "" {
+		description += " via " + trx.GatewayName
+	}
+	return description
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// writeStatementCSV writes the entries of statement between its opening and closing balances
+func writeStatementCSV(w http.ResponseWriter, r *http.Request, log *logger.Logger, statement dto.Statement) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="statement_%s_%s.csv"`,
+		statement.From.Format(time.DateOnly), statement.To.Format(time.DateOnly)))
+	w.WriteHeader(http.StatusOK)
+
+	amount := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', 2, 64)
+	}
+
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"date", "transaction_id", "description", "status", "credit", "debit", "balance", "currency"})
+	_ = writer.Write([]string{statement.From.Format(time.RFC3339), "", "Opening balance", "", "", "",
+		amount(statement.OpeningBalance), statement.Currency})
+	for _, entry := range statement.Entries {
+		credit, debit := "", ""
+		if entry.Credit > 0 {
+			credit = amount(entry.Credit)
+		}
+		if entry.Debit > 0 {
+			debit = amount(entry.Debit)
+		}
+		_ = writer.Write([]string{entry.Date.Format(time.RFC3339), strconv.Itoa(entry.TransactionID), entry.Description,
+			entry.Status, credit, debit, amount(entry.Balance), statement.Currency})
+	}
+	_ = writer.Write([]string{statement.To.Format(time.RFC3339), "", "Closing balance", "", amount(statement.TotalCredits),
+		amount(statement.TotalDebits), amount(statement.ClosingBalance), statement.Currency})
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Warn("failed to write statement", logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)))
+	}
+}
@@ -0,0 +1,199 @@
+package v1
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// accountsRepo serves the account and transactions of user 1, oldest transaction first, in pages
+type accountsRepo struct {
+	db.Mock
+	opening      float64
+	transactions []db.Transaction
+	openingAt    time.Time
+	currencies   []string
+}
+
+func (m *accountsRepo) GetUserAccount(userID int) (*db.UserAccount, error) {
+	if userID != 1 {
+		return nil, db.ErrDataNotFound
+	}
+	return &db.UserAccount{UserID: 1, Balance: 250, Currency: "EUR"}, nil
+}
+
+func (m *accountsRepo) GetBalanceAt(_ context.Context, _ int, currency string, at time.Time) (float64, error) {
+	m.openingAt = at
+	m.currencies = append(m.currencies, currency)
+	return m.opening, nil
+}
+
+func (m *accountsRepo) ListTransactions(_ context.Context, filter db.TransactionFilter, page db.TransactionPage) ([]db.Transaction, error) {
+	m.currencies = append(m.currencies, filter.Currency)
+	start := 0
+	if page.After != nil {
+		for i, trx := range m.transactions {
+			if trx.ID == page.After.ID {
+				start = i + 1
+			}
+		}
+	}
+	end := min(start+page.Limit, len(m.transactions))
+	return m.transactions[start:end], nil
+}
+
+func newAccountsRouter(t *testing.T, repo db.Repository) http.Handler {
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Get("/accounts/me", getAccount(repo, log))
+	router.Get("/accounts/me/statement", getStatement(repo, log))
+	return router
+}
+
+func TestGetAccount(t *testing.T) {
+	router := newAccountsRouter(t, &accountsRepo{})
+
+	rr := serve(router, "/accounts/me", 1, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Data struct {
+			Balance  float64 `json:"balance"`
+			Currency string  `json:"currency"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 250.0, response.Data.Balance)
+	assert.Equal(t, "EUR", response.Data.Currency)
+
+	assert.Equal(t, http.StatusNotFound, serve(router, "/accounts/me", 2, "").Code)
+}
+
+func statementTransactions() []db.Transaction {
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	return []db.Transaction{
+		{ID: 1, Type: "deposit", Status: "success", Amount: 50, GatewayName: "Stripe", CreatedAt: day},
+		{ID: 2, Type: "deposit", Status: "pending", Amount: 20, CreatedAt: day.Add(time.Hour)},
+		{ID: 3, Type: "withdrawal", Status: "pending", Amount: 30.5, GatewayName: "PayPal", CreatedAt: day.Add(2 * time.Hour)},
+		{ID: 4, Type: "withdrawal", Status: "failed", Amount: 10, CreatedAt: day.Add(3 * time.Hour)},
+	}
+}
+
+func TestGetStatement_RunningBalance(t *testing.T) {
+	repo := &accountsRepo{opening: 100, transactions: statementTransactions()}
+	router := newAccountsRouter(t, repo)
+
+	rr := serve(router, "/accounts/me/statement?from=2024-01-01&to=2024-02-01", 1, "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), repo.openingAt)
+	for _, currency := range repo.currencies {
+		assert.Equal(t, "EUR", currency, "only transactions in the account currency are summed")
+	}
+
+	var response struct {
+		Data struct {
+			Currency       string  `json:"currency"`
+			OpeningBalance float64 `json:"opening_balance"`
+			TotalCredits   float64 `json:"total_credits"`
+			TotalDebits    float64 `json:"total_debits"`
+			ClosingBalance float64 `json:"closing_balance"`
+			Entries        []struct {
+				TransactionID int     `json:"transaction_id"`
+				Balance       float64 `json:"balance"`
+			} `json:"entries"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+
+	// pending deposits and failed withdrawals leave the balance unchanged
+	statement := response.Data
+	require.Len(t, statement.Entries, 2)
+	assert.Equal(t, 1, statement.Entries[0].TransactionID)
+	assert.Equal(t, 150.0, statement.Entries[0].Balance)
+	assert.Equal(t, 3, statement.Entries[1].TransactionID)
+	assert.Equal(t, 119.5, statement.Entries[1].Balance)
+	assert.Equal(t, 100.0, statement.OpeningBalance)
+	assert.Equal(t, 50.0, statement.TotalCredits)
+	assert.Equal(t, 30.5, statement.TotalDebits)
+	assert.Equal(t, 119.5, statement.ClosingBalance)
+	assert.Equal(t, "EUR", statement.Currency)
+}
+
+func TestGetStatement_CSV(t *testing.T) {
+	router := newAccountsRouter(t, &accountsRepo{opening: 100, transactions: statementTransactions()})
+
+	for _, rr := range []*httptest.ResponseRecorder{
+		serve(router, "/accounts/me/statement?from=2024-01-01&to=2024-02-01&format=csv", 1, ""),
+		serve(router, "/accounts/me/statement?from=2024-01-01&to=2024-02-01", 1, "text/csv"),
+	} {
+		response := rr.Result()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "text/csv; charset=utf-8", response.Header.Get("Content-Type"))
+		assert.Contains(t, response.Header.Get("Content-Disposition"), "statement_2024-01-01_2024-02-01.csv")
+
+		records, err := csv.NewReader(response.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 5)
+		assert.Equal(t, []string{"date", "transaction_id", "description", "status", "credit", "debit", "balance", "currency"}, records[0])
+		assert.Equal(t, "100.00", records[1][6])
+		assert.Equal(t, []string{"2024-01-10T00:00:00Z", "1", "Deposit via Stripe", "success", "50.00", "", "150.00", "EUR"}, records[2])
+		assert.Equal(t, []string{"2024-01-10T02:00:00Z", "3", "Withdrawal via PayPal", "pending", "", "30.50", "119.50", "EUR"}, records[3])
+		assert.Equal(t, "Closing balance", records[4][2])
+		assert.Equal(t, "119.50", records[4][6])
+	}
+}
+
+func TestGetStatement_XML(t *testing.T) {
+	router := newAccountsRouter(t, &accountsRepo{transactions: statementTransactions()})
+
+	rr := serve(router, "/accounts/me/statement?from=2024-01-01&to=2024-02-01&format=xml", 1, "application/xml")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		ClosingBalance float64 `xml:"data>closing_balance"`
+		Entries        []struct {
+			TransactionID int `xml:"transaction_id"`
+		} `xml:"data>entry"`
+	}
+	require.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Len(t, response.Entries, 2)
+	assert.Equal(t, 19.5, response.ClosingBalance)
+}
+
+func TestGetStatement_ReadsEveryPage(t *testing.T) {
+	var transactions []db.Transaction
+	for i := 1; i <= statementPageSize*2+1; i++ {
+		transactions = append(transactions, db.Transaction{ID: i, Type: "deposit", Status: "success", Amount: 1})
+	}
+	router := newAccountsRouter(t, &accountsRepo{transactions: transactions})
+
+	rr := serve(router, "/accounts/me/statement?from=2024-01-01&to=2024-02-01", 1, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"closing_balance":1001`)
+}
+
+func TestGetStatement_InvalidQuery(t *testing.T) {
+	router := newAccountsRouter(t, &accountsRepo{})
+
+	for _, query := range []string{
+		"format=pdf",
+		"from=2024-02-01&to=2024-01-01",
+		"from=2022-01-01&to=2024-01-01",
+		"to=tomorrow",
+	} {
+		assert.Equal(t, http.StatusBadRequest, serve(router, "/accounts/me/statement?"+query, 1, "").Code, query)
+	}
+	assert.Equal(t, http.StatusNotFound, serve(router, "/accounts/me/statement", 2, "").Code)
+}
@@ -0,0 +1,139 @@
+package v1
+
+import (
+	"errors"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/api/utils"
+	"github.com/ercross/payment_gateways/internal/api/v1/dto"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+// listGateways responds with all payment gateways
+func listGateways(repo db.Repository, log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		gateways, err := repo.GetGateways()
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to get gateways", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+				logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
+		sendAPIResponse(w, r, http.StatusOK, "Gateways retrieved", gateways, dataFormat)
+	}
+}
+
+// createGatewayPriority sets the priority of a payment gateway in a country
+//
+// Sample Request (POST /admin/gateway-priorities):
+//
+//	{
+//	    "country_id": 1,
+//	    "gateway_name": "Stripe",
+//	    "priority": 1,
+//	    "is_active": true
+//	}
+func createGatewayPriority(repo db.Repository, log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		var request dto.GatewayPriorityRequest
+		if err := utils.DecodeRequest(r, &request); err != nil {
+			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+			return
+		}
+		if err := utils.ValidateDTO(request, utils.ContentDataTypeToTag[dataFormat]); err != nil {
+			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+			return
+		}
+
+		gateway, err := repo.GetGatewayByName(request.GatewayName)
+		if err != nil {
+			if errors.Is(err, db.ErrDataNotFound) {
+				sendAPIResponse(w, r, http.StatusUnprocessableEntity, "Unknown payment gateway", nil, dataFormat)
+				return
+			}
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to get gateway", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+				logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
+		priority := db.GatewayPriority{
+			Gateway:   gateway,
+			CountryID: request.CountryID,
+			Priority:  request.Priority,
+			IsActive:  request.IsActive,
+		}
+		if err = repo.InsertGatewayPriority(priority); err != nil {
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to insert gateway priority", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+				logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
+		sendAPIResponse(w, r, http.StatusCreated, "Gateway priority created", nil, dataFormat)
+	}
+}
+
+// assignUserRole grants the role in the URL to the user in the URL
+func assignUserRole(repo db.Repository, log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		userID, err := strconv.Atoi(chi.URLParam(r, "user-id"))
+		if err != nil || userID <= 0 {
+			sendAPIResponse(w, r, http.StatusBadRequest, "Invalid user ID", nil, dataFormat)
+			return
+		}
+
+		if err = repo.AssignUserRole(userID, chi.URLParam(r, "role")); err != nil {
+			if errors.Is(err, db.ErrDataNotFound) {
+				sendAPIResponse(w, r, http.StatusNotFound, "Role not found", nil, dataFormat)
+				return
+			}
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to assign role", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+				logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
+		log.Info("role assigned", logger.ComponentAudit, logger.NewField("User-ID", userID),
+			logger.NewField("Role", chi.URLParam(r, "role")), logger.NewField("Request-ID", requestID(r)))
+		sendAPIResponse(w, r, http.StatusOK, "Role assigned", nil, dataFormat)
+	}
+}
+
+// revokeUserRole withdraws the role in the URL from the user in the URL
+func revokeUserRole(repo db.Repository, log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		userID, err := strconv.Atoi(chi.URLParam(r, "user-id"))
+		if err != nil || userID <= 0 {
+			sendAPIResponse(w, r, http.StatusBadRequest, "Invalid user ID", nil, dataFormat)
+			return
+		}
+
+		if err = repo.RevokeUserRole(userID, chi.URLParam(r, "role")); err != nil {
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to revoke role", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+				logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
+		log.Info("role revoked", logger.ComponentAudit, logger.NewField("User-ID", userID),
+			logger.NewField("Role", chi.URLParam(r, "role")), logger.NewField("Request-ID", requestID(r)))
+		sendAPIResponse(w, r, http.StatusOK, "Role revoked", nil, dataFormat)
+	}
+}
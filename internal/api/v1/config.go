@@ -0,0 +1,15 @@
+package v1
+
+import (
+	"github.com/ercross/payment_gateways/internal/api/utils"
+	"github.com/ercross/payment_gateways/internal/config"
+	"net/http"
+)
+
+// showConfig responds with the settings the server was started with, where each was read from, and secrets redacted
+func showConfig(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataFormat := utils.DetermineResponseContentDataType(r)
+		sendAPIResponse(w, r, http.StatusOK, "Configuration retrieved", cfg.Settings(), dataFormat)
+	}
+}
@@ -1,5 +1,7 @@
 package dto
 
+import "time"
+
 type DataFormat int8
 
 const (
@@ -14,8 +16,8 @@ type WithdrawalRequest struct {
 	Amount             float64 `json:"amount" xml:"amount" validate:"required,gt=0"`
 	UserID             int     `json:"user_id" xml:"user_id" validate:"required"`
 	PaymentGatewayName string  `json:"payment_gateway_name" xml:"payment_gateway_name" validate:"required"`
-	ReceivingAccount   string  `json:"receiving_account_id" xml:"receiving_account_id" validate:"required"`
-	AuthenticationCode string  `json:"authentication_code" xml:"authentication_code" validate:"required"`
+	ReceivingAccount   string  `json:"receiving_account_id" xml:"receiving_account_id" validate:"required" log:"redact,partial"`
+	AuthenticationCode string  `json:"authentication_code" xml:"authentication_code" validate:"required" log:"-"`
 }
 
 type DepositRequest struct {
@@ -24,6 +26,81 @@ type DepositRequest struct {
 	Currency string  `json:"currency" xml:"currency" validate:"required"`
 }
 
+// TOTPConfirmationRequest confirms a TOTP enrollment with a code generated by the authenticator app
+type TOTPConfirmationRequest struct {
+	Code string `json:"code" xml:"code" validate:"required" log:"-"`
+}
+
+// TOTPRecoveryCodes lists the single-use codes accepted in place of a TOTP code
+type TOTPRecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes" xml:"recovery_code" log:"-"`
+}
+
+// GatewayPriorityRequest sets the priority of a payment gateway in a country
+type GatewayPriorityRequest struct {
+	CountryID   int    `json:"country_id" xml:"country_id" validate:"required"`
+	GatewayName string `json:"gateway_name" xml:"gateway_name" validate:"required"`
+	Priority    int    `json:"priority" xml:"priority" validate:"required,gt=0"`
+	IsActive    bool   `json:"is_active" xml:"is_active"`
+}
+
+// LogLevelRequest sets the level of a log component, reverting it after TTL when set
+type LogLevelRequest struct {
+	Level string `json:"level" xml:"level" validate:"required,oneof=debug info warn error"`
+	TTL   string `json:"ttl,omitempty" xml:"ttl,omitempty"`
+}
+
+// Transaction is a deposit or withdrawal as returned by the API
+type Transaction struct {
+	ID        int       `json:"id" xml:"id"`
+	UserID    int       `json:"user_id" xml:"user_id"`
+	Type      string    `json:"type" xml:"type"`
+	Status    string    `json:"status" xml:"status"`
+	Amount    float64   `json:"amount" xml:"amount"`
+	Currency  string    `json:"currency" xml:"currency"`
+	Gateway   string    `json:"gateway" xml:"gateway"`
+	Country   string    `json:"country" xml:"country"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+}
+
+// TransactionPage is a page of transactions. The next page is requested with NextCursor, empty on the last page.
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions" xml:"transaction"`
+	NextCursor   string        `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+}
+
+// Account is the balance of a user
+type Account struct {
+	UserID    int       `json:"user_id" xml:"user_id"`
+	Balance   float64   `json:"balance" xml:"balance"`
+	Currency  string    `json:"currency" xml:"currency"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// Statement lists the transactions changing the balance of a user from From, inclusive, to To, exclusive,
+// with the balance after each of them
+type Statement struct {
+	UserID         int              `json:"user_id" xml:"user_id"`
+	Currency       string           `json:"currency" xml:"currency"`
+	From           time.Time        `json:"from" xml:"from"`
+	To             time.Time        `json:"to" xml:"to"`
+	OpeningBalance float64          `json:"opening_balance" xml:"opening_balance"`
+	TotalCredits   float64          `json:"total_credits" xml:"total_credits"`
+	TotalDebits    float64          `json:"total_debits" xml:"total_debits"`
+	ClosingBalance float64          `json:"closing_balance" xml:"closing_balance"`
+	Entries        []StatementEntry `json:"entries" xml:"entry"`
+}
+
+type StatementEntry struct {
+	Date          time.Time `json:"date" xml:"date"`
+	TransactionID int       `json:"transaction_id" xml:"transaction_id"`
+	Description   string    `json:"description" xml:"description"`
+	Status        string    `json:"status" xml:"status"`
+	Credit        float64   `json:"credit" xml:"credit"`
+	Debit         float64   `json:"debit" xml:"debit"`
+	Balance       float64   `json:"balance" xml:"balance"`
+}
+
 // APIResponse is a standard response structure for the APIs
 type APIResponse struct {
 	StatusCode int         `json:"status_code" xml:"status_code"`
@@ -47,3 +124,15 @@ func (w *DepositRequest) IsDecodable() bool {
 func (w *TransactionStatusCallback) IsDecodable() bool {
 	return true
 }
+
+func (w *TOTPConfirmationRequest) IsDecodable() bool {
+	return true
+}
+
+func (w *GatewayPriorityRequest) IsDecodable() bool {
+	return true
+}
+
+func (w *LogLevelRequest) IsDecodable() bool {
+	return true
+}
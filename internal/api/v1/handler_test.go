@@ -4,21 +4,29 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/api/middlewares"
 	"github.com/ercross/payment_gateways/internal/api/v1/dto"
-	"github.com/ercross/payment_gateways/internal/kafka"
 	"github.com/ercross/payment_gateways/internal/logger"
 	cache "github.com/ercross/payment_gateways/internal/redis"
+	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/ercross/payment_gateways/internal/settings"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	services.InitEncryptionKey("0123456789abcdef")
+	os.Exit(m.Run())
+}
+
 func TestDeposit_Success(t *testing.T) {
 	mockRepo := new(db.Mock)
 	mockCache := new(cache.Mock)
 	log, _ := logger.NewSilentLogger()
-	publisher := &kafka.Mock{}
 
 	depositRequest := dto.DepositRequest{
 		UserID:   1,
@@ -27,9 +35,10 @@ func TestDeposit_Success(t *testing.T) {
 	}
 
 	req, _ := http.NewRequest(http.MethodPost, "/deposit", bytes.NewReader(encodeJSON(depositRequest)))
+	req = authenticated(req, 1)
 	rr := httptest.NewRecorder()
 
-	handler := handleDeposit(mockRepo, log, publisher, mockCache, "https://localhost:8080")
+	handler := handleDeposit(mockRepo, log, mockCache, settings.NewStore(settings.Settings{}), "https://localhost:8080", time.Minute*5)
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -39,7 +48,6 @@ func TestWithdraw_InsufficientBalance(t *testing.T) {
 	mockRepo := new(db.Mock)
 	mockCache := new(cache.Mock)
 	log, _ := logger.NewSilentLogger()
-	publisher := &kafka.Mock{}
 
 	withdrawRequest := dto.WithdrawalRequest{
 		Amount:             200.0,
@@ -50,9 +58,10 @@ func TestWithdraw_InsufficientBalance(t *testing.T) {
 	}
 
 	req, _ := http.NewRequest(http.MethodPost, "/withdraw", bytes.NewReader(encodeJSON(withdrawRequest)))
+	req = authenticated(req, 1)
 	rr := httptest.NewRecorder()
 
-	handler := initiateWithdrawal(mockRepo, log, publisher, mockCache, "https://localhost:8080")
+	handler := initiateWithdrawal(mockRepo, log, mockCache, settings.NewStore(settings.Settings{}), nil, nil, nil, "https://localhost:8080", time.Minute*5)
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
@@ -63,7 +72,6 @@ func TestDepositCallback_Success(t *testing.T) {
 	mockRepo := new(db.Mock)
 	mockCache := new(cache.Mock)
 	log, _ := logger.NewSilentLogger()
-	publisher := &kafka.Mock{}
 
 	callbackRequest := dto.TransactionStatusCallback{
 		TransactionID: 1,
@@ -73,7 +81,7 @@ func TestDepositCallback_Success(t *testing.T) {
 	req, _ := http.NewRequest(http.MethodPost, "/callback/deposit", bytes.NewReader(encodeJSON(callbackRequest)))
 	rr := httptest.NewRecorder()
 
-	handler := depositCallbackHandler(mockRepo, log, publisher, mockCache)
+	handler := depositCallbackHandler(mockRepo, log, mockCache)
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -83,7 +91,6 @@ func TestWithdrawCallback_Failure(t *testing.T) {
 	mockRepo := new(db.Mock)
 	mockCache := new(cache.Mock)
 	log, _ := logger.NewSilentLogger()
-	publisher := &kafka.Mock{}
 
 	callbackRequest := dto.TransactionStatusCallback{
 		TransactionID: 2,
@@ -93,7 +100,7 @@ func TestWithdrawCallback_Failure(t *testing.T) {
 	req, _ := http.NewRequest(http.MethodPost, "/callback/withdraw", bytes.NewReader(encodeJSON(callbackRequest)))
 	rr := httptest.NewRecorder()
 
-	handler := withdrawalCallbackHandler(mockRepo, log, publisher, mockCache)
+	handler := withdrawalCallbackHandler(mockRepo, log, mockCache)
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -104,11 +111,35 @@ func encodeJSON(data interface{}) []byte {
 	return b
 }
 
+func authenticated(r *http.Request, userID int) *http.Request {
+	return r.WithContext(middlewares.WithPrincipal(r.Context(), middlewares.Principal{UserID: userID}))
+}
+
+func TestDeposit_UserMismatch(t *testing.T) {
+	mockRepo := new(db.Mock)
+	mockCache := new(cache.Mock)
+	log, _ := logger.NewSilentLogger()
+
+	depositRequest := dto.DepositRequest{
+		UserID:   2,
+		Amount:   100.0,
+		Currency: "USD",
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/deposit", bytes.NewReader(encodeJSON(depositRequest)))
+	req = authenticated(req, 1)
+	rr := httptest.NewRecorder()
+
+	handler := handleDeposit(mockRepo, log, mockCache, settings.NewStore(settings.Settings{}), "https://localhost:8080", time.Minute*5)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
 func TestDeposit_InvalidUserID(t *testing.T) {
 	mockRepo := new(db.Mock)
 	mockCache := new(cache.Mock)
 	log, _ := logger.NewSilentLogger()
-	publisher := &kafka.Mock{}
 
 	depositRequest := dto.DepositRequest{
 		UserID:   0, // Invalid user ID
@@ -117,9 +148,10 @@ func TestDeposit_InvalidUserID(t *testing.T) {
 	}
 
 	req, _ := http.NewRequest(http.MethodPost, "/deposit", bytes.NewReader(encodeJSON(depositRequest)))
+	req = authenticated(req, 1)
 	rr := httptest.NewRecorder()
 
-	handler := handleDeposit(mockRepo, log, publisher, mockCache, "https://localhost:8080")
+	handler := handleDeposit(mockRepo, log, mockCache, settings.NewStore(settings.Settings{}), "https://localhost:8080", time.Minute*5)
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
@@ -130,7 +162,6 @@ func TestWithdraw_NegativeAmount(t *testing.T) {
 	mockRepo := new(db.Mock)
 	mockCache := new(cache.Mock)
 	log, _ := logger.NewSilentLogger()
-	publisher := &kafka.Mock{}
 
 	withdrawRequest := dto.WithdrawalRequest{
 		Amount:             -50.0, // Invalid amount
@@ -141,11 +172,34 @@ func TestWithdraw_NegativeAmount(t *testing.T) {
 	}
 
 	req, _ := http.NewRequest(http.MethodPost, "/withdraw", bytes.NewReader(encodeJSON(withdrawRequest)))
+	req = authenticated(req, 1)
 	rr := httptest.NewRecorder()
 
-	handler := initiateWithdrawal(mockRepo, log, publisher, mockCache, "https://localhost:8080")
+	handler := initiateWithdrawal(mockRepo, log, mockCache, settings.NewStore(settings.Settings{}), nil, nil, nil, "https://localhost:8080", time.Minute*5)
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 	assert.Contains(t, rr.Body.String(), "failed validation")
 }
+
+func TestDeposit_Disabled(t *testing.T) {
+	mockRepo := new(db.Mock)
+	mockCache := new(cache.Mock)
+	log, _ := logger.NewSilentLogger()
+
+	depositRequest := dto.DepositRequest{
+		UserID:   1,
+		Amount:   100.0,
+		Currency: "USD",
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/deposit", bytes.NewReader(encodeJSON(depositRequest)))
+	req = authenticated(req, 1)
+	rr := httptest.NewRecorder()
+
+	store := settings.NewStore(settings.Settings{Features: map[string]bool{settings.FeatureDeposits: false}})
+	handler := handleDeposit(mockRepo, log, mockCache, store, "https://localhost:8080", time.Minute*5)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
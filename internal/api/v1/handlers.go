@@ -1,16 +1,19 @@
 package v1
 
 import (
-	"context"
 	"errors"
 	"github.com/ercross/payment_gateways/db"
 	"github.com/ercross/payment_gateways/internal/api/utils"
 	"github.com/ercross/payment_gateways/internal/api/v1/dto"
-	"github.com/ercross/payment_gateways/internal/kafka"
+	"github.com/ercross/payment_gateways/internal/events"
 	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/metrics"
+	"github.com/ercross/payment_gateways/internal/mfa"
 	"github.com/ercross/payment_gateways/internal/payment_gateways"
 	cache "github.com/ercross/payment_gateways/internal/redis"
-	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/ercross/payment_gateways/internal/settings"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	"github.com/ercross/payment_gateways/internal/vault"
 	"net/http"
 	"strings"
 	"time"
@@ -31,14 +34,23 @@ var (
 func handleDeposit(
 	repo db.Repository,
 	log *logger.Logger,
-	publisher kafka.EventPublisher,
 	dstrCache cache.DistributedCache,
+	store *settings.Store,
 	baseURL string,
+	cacheTTL time.Duration,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dstrCache := tracing.Cache(r.Context(), dstrCache)
 
 		dataFormat := utils.DetermineResponseContentDataType(r)
 
+		current := store.Current()
+		if !current.Enabled(settings.FeatureDeposits) {
+			sendAPIResponse(w, r, http.StatusServiceUnavailable, "Deposits are temporarily disabled", nil, dataFormat)
+			return
+		}
+
 		// validate request
 		var depositRequest dto.DepositRequest
 		err := utils.DecodeRequest(r, &depositRequest)
@@ -51,6 +63,12 @@ func handleDeposit(
 			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
 			return
 		}
+
+		if err = authorizeUser(r, depositRequest.UserID); err != nil {
+			sendAuthorizationError(w, r, err, dataFormat)
+			return
+		}
+
 		trx := utils.ConvertDepositRequestToTransaction(depositRequest)
 
 		// get user country
@@ -72,7 +90,7 @@ func handleDeposit(
 		trx.CountryName = user.Country.Name
 
 		// select payment gateway
-		gatewayImpl, err := selectPaymentGateway(repo, user.Country.ID, log)
+		gatewayImpl, err := selectPaymentGateway(r.Context(), repo, current, user.Country.ID, log)
 		if err != nil {
 			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
 			log.Error("failed to get user", logger.ComponentDatabase,
@@ -91,7 +109,8 @@ func handleDeposit(
 		}
 		defer dstrCache.ReleaseLock(lock)
 
-		trxID, err := repo.CreateTransaction(trx)
+		trxID, err := repo.CreateTransactionWithEvent(trx, transactionEvent(r, events.TypeDepositInitiated, "",
+			events.SensitiveData{UserID: trx.UserID}))
 		if err != nil {
 			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
 			log.Error("failed to create transaction", logger.ComponentDatabase,
@@ -99,6 +118,7 @@ func handleDeposit(
 			return
 		}
 		trx.ID = trxID
+		metrics.ObserveTransaction(trx.Type, trx.Status, trx.Currency, trx.CountryName, trx.Amount)
 
 		// prepare response
 		sessionData, err := gatewayImpl.GenerateDepositCheckoutSessionData(trx, constructDepositCallbackUrl(baseURL, trx.ID))
@@ -113,25 +133,11 @@ func handleDeposit(
 
 		// cache transaction for faster retrieval
 		cacheKey = cache.ConstructTransactionIDKey(trx.ID)
-		err = dstrCache.Save(cacheKey, trx, time.Minute*5)
+		err = dstrCache.Save(cacheKey, trx, cacheTTL)
 		if err != nil {
 			log.Warn("failed to cache transaction", logger.ComponentRedis,
 				logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)))
 		}
-
-		// log to kafka
-		encryptedSessionData, err := services.MaskData(sessionData)
-		if err != nil {
-			log.Warn("error encrypting deposit session data", logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)))
-			return
-		}
-		err = publisher.PublishTransaction(context.Background(), trx.ID, encryptedSessionData, dataFormat)
-		if err != nil {
-			log.Warn("error publishing deposit transaction", logger.ComponentKafka,
-				logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)), logger.NewField("transaction-ID", trxID))
-			return
-		}
-		log.Info("Deposit transaction event published to Kafka", logger.ComponentKafka, logger.NewField("Request-ID", requestID(r)), logger.NewField("transaction-ID", trxID))
 	}
 }
 
@@ -145,13 +151,26 @@ func handleDeposit(
 func initiateWithdrawal(
 	repo db.Repository,
 	log *logger.Logger,
-	publisher kafka.EventPublisher,
 	dstrCache cache.DistributedCache,
+	store *settings.Store,
+	totp *mfa.TOTP,
+	tokenizer vault.Tokenizer,
+	detokenizer vault.Detokenizer,
 	baseURL string,
+	cacheTTL time.Duration,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dstrCache := tracing.Cache(r.Context(), dstrCache)
+
 		dataFormat := utils.DetermineResponseContentDataType(r)
 
+		current := store.Current()
+		if !current.Enabled(settings.FeatureWithdrawals) {
+			sendAPIResponse(w, r, http.StatusServiceUnavailable, "Withdrawals are temporarily disabled", nil, dataFormat)
+			return
+		}
+
 		var withdrawalRequest dto.WithdrawalRequest
 		err := utils.DecodeRequest(r, &withdrawalRequest)
 		if err != nil {
@@ -164,6 +183,11 @@ func initiateWithdrawal(
 			return
 		}
 
+		if err = authorizeUser(r, withdrawalRequest.UserID); err != nil {
+			sendAuthorizationError(w, r, err, dataFormat)
+			return
+		}
+
 		userAccount, err := repo.GetUserAccount(withdrawalRequest.UserID)
 		if err != nil {
 			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
@@ -177,20 +201,38 @@ func initiateWithdrawal(
 			return
 		}
 
-		err = services.VerifyUserAuthenticatorCode(withdrawalRequest.UserID, withdrawalRequest.AuthenticationCode)
+		err = totp.Verify(withdrawalRequest.UserID, withdrawalRequest.AuthenticationCode)
 		if err != nil {
-			sendAPIResponse(w, r, http.StatusUnauthorized, "Incorrect authentication code", nil, dataFormat)
+			sendMFAError(w, r, log, err, dataFormat)
 			return
 		}
 
-		gatewayImpl, err := gateways.PaymentGatewayFromName(withdrawalRequest.PaymentGatewayName)
+		gatewayImpl, err := gateways.PaymentGatewayFromName(withdrawalRequest.PaymentGatewayName, detokenizer)
 		if err != nil {
 			sendAPIResponse(w, r, http.StatusUnprocessableEntity, "Unknown payment gateway", nil, dataFormat)
 			return
 		}
+		if current.GatewayDisabled(gatewayImpl.Name()) {
+			sendAPIResponse(w, r, http.StatusUnprocessableEntity, "Payment gateway is disabled", nil, dataFormat)
+			return
+		}
+		gatewayImpl = gateways.Instrument(r.Context(), gatewayImpl)
 		trx := utils.ConvertWithdrawalRequestToTransaction(withdrawalRequest)
 
-		trxID, err := repo.CreateTransaction(trx)
+		// withdrawals are paid from the account, in its currency
+		trx.Currency = strings.TrimSpace(userAccount.Currency)
+
+		// the receiving account only exists as a vault token past this point
+		receivingAccountToken, err := tokenizer.Tokenize(trx.UserID, withdrawalRequest.ReceivingAccount)
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to tokenize receiving account", logger.ComponentDatabase,
+				logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
+		sensitive := events.SensitiveData{UserID: trx.UserID, ReceivingAccount: receivingAccountToken}
+		trxID, err := repo.CreateTransactionWithEvent(trx, transactionEvent(r, events.TypeWithdrawalInitiated, "", sensitive))
 		if err != nil {
 			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
 			log.Error("failed to create transaction", logger.ComponentDatabase,
@@ -198,8 +240,9 @@ func initiateWithdrawal(
 			return
 		}
 		trx.ID = trxID
+		metrics.ObserveTransaction(trx.Type, trx.Status, trx.Currency, trx.CountryName, trx.Amount)
 
-		err = gatewayImpl.RegisterWithdrawal(trx, constructWithdrawalCallbackUrl(baseURL, trx.ID), withdrawalRequest.ReceivingAccount)
+		err = gatewayImpl.RegisterWithdrawal(trx, constructWithdrawalCallbackUrl(baseURL, trx.ID), receivingAccountToken)
 		if err != nil {
 			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
 			log.Error("failed to register withdrawal", logger.NewField("Payment-Gateway", gatewayImpl.Name()),
@@ -207,34 +250,43 @@ func initiateWithdrawal(
 			return
 		}
 
+		// the registration is recorded together with its event, unless the gateway has already reported the outcome
+		registered := trx
+		registered.Status = "registered"
+		event, err := transactionEvent(r, events.TypeWithdrawalRegistered, trx.Status, sensitive)(registered)
+		if err == nil {
+			err = repo.TransitionTransactionStatusWithEvent(trx.ID, trx.Status, registered.Status, event)
+		}
+		switch {
+		case err == nil:
+			trx = registered
+		case errors.Is(err, db.ErrDataConflict):
+			log.Info("withdrawal outcome reported before its registration was recorded", logger.ComponentDatabase,
+				logger.NewField("Request-ID", requestID(r)), logger.NewField("transaction-ID", trx.ID))
+		default:
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to record withdrawal registration", logger.ComponentDatabase,
+				logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)), logger.NewField("transaction-ID", trx.ID))
+			return
+		}
+
 		sendAPIResponse(w, r, http.StatusOK, "Your withdrawal has been registered and will be processed shortly", trx, dataFormat)
 
 		// cache transaction for faster retrieval
 		cacheKey := cache.ConstructTransactionIDKey(trx.ID)
-		err = dstrCache.Save(cacheKey, trx, time.Minute*5)
+		err = dstrCache.Save(cacheKey, trx, cacheTTL)
 		if err != nil {
 			log.Warn("failed to cache transaction", logger.ComponentRedis,
 				logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)))
 		}
-
-		// log to kafka
-		encryptedTrx, err := services.MaskData(trx)
-		if err != nil {
-			log.Warn("error encrypting withdrawal transaction", logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)))
-			return
-		}
-		err = publisher.PublishTransaction(context.Background(), trx.ID, encryptedTrx, dataFormat)
-		if err != nil {
-			log.Warn("error publishing withdrawal transaction", logger.ComponentKafka,
-				logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)), logger.NewField("transaction-ID", trxID))
-			return
-		}
-		log.Info("Withdrawal initiation transaction event published to Kafka", logger.ComponentKafka, logger.NewField("Request-ID", requestID(r)), logger.NewField("transaction-ID", trxID))
 	}
 }
 
-func depositCallbackHandler(repo db.Repository, log *logger.Logger, publisher kafka.EventPublisher, dstrCache cache.DistributedCache) http.HandlerFunc {
+func depositCallbackHandler(repo db.Repository, log *logger.Logger, dstrCache cache.DistributedCache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dstrCache := tracing.Cache(r.Context(), dstrCache)
+
 		dataFormat := utils.DetermineResponseContentDataType(r)
 
 		// Parse request
@@ -272,12 +324,29 @@ func depositCallbackHandler(repo db.Repository, log *logger.Logger, publisher ka
 			}
 		}
 
+		if err = authorizeCallback(r, trx); err != nil {
+			sendAPIResponse(w, r, http.StatusForbidden, err.Error(), nil, dataFormat)
+			log.Warn("callback rejected", logger.ComponentAudit, logger.NewField("Error", err.Error()),
+				logger.NewField("TransactionID", trx.ID), logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
 		// Update transaction status
-		if err := repo.UpdateTransactionStatus(trx.ID, callbackRequest.Status); err != nil {
+		previousStatus := trx.Status
+		trx.Status = callbackRequest.Status
+		event, err := transactionEvent(r, events.TypeTransactionStatusChanged, previousStatus,
+			events.SensitiveData{UserID: trx.UserID})(trx)
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to build transaction status event", logger.NewField("Error", err.Error()))
+			return
+		}
+		if err := repo.UpdateTransactionStatusWithEvent(trx.ID, callbackRequest.Status, event); err != nil {
 			sendAPIResponse(w, r, http.StatusInternalServerError, "", nil, dataFormat)
 			log.Error("failed to update transaction status", logger.NewField("Error", err.Error()))
 			return
 		}
+		metrics.ObserveTransaction(trx.Type, trx.Status, trx.Currency, trx.CountryName, trx.Amount)
 
 		// Additional actions based on status
 		if strings.ToLower(callbackRequest.Status) == "success" {
@@ -293,25 +362,14 @@ func depositCallbackHandler(repo db.Repository, log *logger.Logger, publisher ka
 
 		cacheKey := cache.ConstructTransactionIDKey(trx.ID)
 		_ = dstrCache.Delete(cacheKey)
-
-		// log to kafka
-		encryptedTrx, err := services.MaskData(trx)
-		if err != nil {
-			log.Warn("error encrypting deposit transaction update", logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)))
-			return
-		}
-		err = publisher.PublishTransaction(context.Background(), trx.ID, encryptedTrx, dataFormat)
-		if err != nil {
-			log.Warn("error publishing deposit transaction status update", logger.ComponentKafka,
-				logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)), logger.NewField("transaction-ID", trx.ID))
-			return
-		}
-		log.Info("Deposit transaction status update event published to Kafka", logger.ComponentKafka, logger.NewField("Request-ID", requestID(r)), logger.NewField("transaction-ID", trx.ID))
 	}
 }
 
-func withdrawalCallbackHandler(repo db.Repository, log *logger.Logger, publisher kafka.EventPublisher, dstrCache cache.DistributedCache) http.HandlerFunc {
+func withdrawalCallbackHandler(repo db.Repository, log *logger.Logger, dstrCache cache.DistributedCache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dstrCache := tracing.Cache(r.Context(), dstrCache)
+
 		dataFormat := utils.DetermineResponseContentDataType(r)
 
 		// Parse request
@@ -344,12 +402,29 @@ func withdrawalCallbackHandler(repo db.Repository, log *logger.Logger, publisher
 			}
 		}
 
+		if err = authorizeCallback(r, trx); err != nil {
+			sendAPIResponse(w, r, http.StatusForbidden, err.Error(), nil, dataFormat)
+			log.Warn("callback rejected", logger.ComponentAudit, logger.NewField("Error", err.Error()),
+				logger.NewField("TransactionID", trx.ID), logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
 		// Update transaction status
-		if err := repo.UpdateTransactionStatus(trx.ID, callbackRequest.Status); err != nil {
+		previousStatus := trx.Status
+		trx.Status = callbackRequest.Status
+		event, err := transactionEvent(r, events.TypeTransactionStatusChanged, previousStatus,
+			events.SensitiveData{UserID: trx.UserID})(trx)
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to build transaction status event", logger.NewField("Error", err.Error()))
+			return
+		}
+		if err := repo.UpdateTransactionStatusWithEvent(trx.ID, callbackRequest.Status, event); err != nil {
 			sendAPIResponse(w, r, http.StatusInternalServerError, "", nil, dataFormat)
 			log.Error("failed to update transaction status", logger.NewField("Error", err.Error()))
 			return
 		}
+		metrics.ObserveTransaction(trx.Type, trx.Status, trx.Currency, trx.CountryName, trx.Amount)
 
 		// Additional actions based on status
 		if strings.ToLower(callbackRequest.Status) == "failed" {
@@ -365,20 +440,5 @@ func withdrawalCallbackHandler(repo db.Repository, log *logger.Logger, publisher
 
 		cacheKey := cache.ConstructTransactionIDKey(trx.ID)
 		_ = dstrCache.Delete(cacheKey)
-
-		// log to kafka
-		encryptedTrx, err := services.MaskData(trx)
-		if err != nil {
-			log.Warn("error encrypting deposit transaction update", logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)))
-			return
-		}
-		err = publisher.PublishTransaction(context.Background(), trx.ID, encryptedTrx, dataFormat)
-		if err != nil {
-			log.Warn("error publishing deposit transaction status update", logger.ComponentKafka,
-				logger.NewField("Error", err.Error()), logger.NewField("Request-ID", requestID(r)), logger.NewField("transaction-ID", trx.ID))
-			return
-		}
-		log.Info("Withdrawal transaction status update event published to Kafka", logger.ComponentKafka, logger.NewField("Request-ID", requestID(r)), logger.NewField("transaction-ID", trx.ID))
-
 	}
 }
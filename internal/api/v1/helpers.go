@@ -3,10 +3,16 @@ package v1
 import (
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/api/middlewares"
 	"github.com/ercross/payment_gateways/internal/api/v1/dto"
+	"github.com/ercross/payment_gateways/internal/events"
+	"github.com/ercross/payment_gateways/internal/tracing"
 	"github.com/go-chi/chi/v5/middleware"
 	"net/http"
+	"strings"
 )
 
 // sendAPIResponse sends a response in JSON or XML format based on the Content-Type header.
@@ -49,3 +55,56 @@ func constructDepositLockKey(amount float64, userID int, currency string, paymen
 func requestID(r *http.Request) string {
 	return middleware.GetReqID(r.Context())
 }
+
+// transactionEvent returns a db.OutboxEventBuilder that records an event of eventType for the transaction
+func transactionEvent(
+	r *http.Request,
+	eventType events.Type,
+	previousStatus string,
+	sensitive events.SensitiveData,
+) db.OutboxEventBuilder {
+	return func(trx db.Transaction) (db.OutboxEvent, error) {
+		event, err := events.NewTransactionEvent(eventType, trx, previousStatus, sensitive, requestID(r))
+		if err != nil {
+			return db.OutboxEvent{}, err
+		}
+		payload, err := event.Marshal()
+		if err != nil {
+			return db.OutboxEvent{}, fmt.Errorf("error serializing %s event: %w", eventType, err)
+		}
+		return db.OutboxEvent{Payload: payload, TraceContext: tracing.TraceParent(r.Context())}, nil
+	}
+}
+
+var errUserMismatch = errors.New("user_id does not match the authenticated user")
+
+// authorizeUser checks that the authenticated principal of r may act on behalf of userID
+func authorizeUser(r *http.Request, userID int) error {
+	principal, err := middlewares.PrincipalFromContext(r.Context())
+	if err != nil {
+		return err
+	}
+	if principal.UserID != userID {
+		return errUserMismatch
+	}
+	return nil
+}
+
+// sendAuthorizationError responds to a request rejected by authorizeUser
+func sendAuthorizationError(w http.ResponseWriter, r *http.Request, err error, dataFormat dto.DataFormat) {
+	if errors.Is(err, middlewares.ErrUnauthenticated) {
+		sendAPIResponse(w, r, http.StatusUnauthorized, "Unauthorized", nil, dataFormat)
+		return
+	}
+	sendAPIResponse(w, r, http.StatusForbidden, err.Error(), nil, dataFormat)
+}
+
+// authorizeCallback checks that the payment gateway identified by the client certificate of r, if any,
+// is the gateway processing trx
+func authorizeCallback(r *http.Request, trx db.Transaction) error {
+	gateway, ok := middlewares.CallbackGatewayFromContext(r.Context())
+	if !ok || strings.EqualFold(gateway, trx.GatewayName) {
+		return nil
+	}
+	return fmt.Errorf("transaction %d is not processed by %s", trx.ID, gateway)
+}
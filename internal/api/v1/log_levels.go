@@ -0,0 +1,90 @@
+package v1
+
+import (
+	"errors"
+	"github.com/ercross/payment_gateways/internal/api/utils"
+	"github.com/ercross/payment_gateways/internal/api/v1/dto"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"time"
+)
+
+// listLogLevels responds with the level of the root logger and of each component
+func listLogLevels(log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataFormat := utils.DetermineResponseContentDataType(r)
+		sendAPIResponse(w, r, http.StatusOK, "Log levels retrieved", log.Levels().Status(), dataFormat)
+	}
+}
+
+// setLogLevel sets the level of the component in the URL, "root" for entries without component
+//
+// Sample Request (PUT /admin/log-levels/Kafka):
+//
+//	{
+//	    "level": "debug",
+//	    "ttl": "15m"
+//	}
+func setLogLevel(log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		var request dto.LogLevelRequest
+		if err := utils.DecodeRequest(r, &request); err != nil {
+			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+			return
+		}
+		if err := utils.ValidateDTO(request, utils.ContentDataTypeToTag[dataFormat]); err != nil {
+			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+			return
+		}
+
+		level, err := logger.ParseLevel(request.Level)
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+			return
+		}
+		var ttl time.Duration
+		if request.TTL != "" {
+			if ttl, err = time.ParseDuration(request.TTL); err != nil || ttl <= 0 {
+				sendAPIResponse(w, r, http.StatusBadRequest, "Invalid TTL, expected a positive duration such as 15m", nil, dataFormat)
+				return
+			}
+		}
+
+		component := chi.URLParam(r, "component")
+		if err = log.Levels().Set(component, level, ttl); err != nil {
+			sendLogLevelError(w, r, err, dataFormat)
+			return
+		}
+
+		log.Ctx(r.Context()).Info("log level changed", logger.ComponentAudit, logger.NewField("Log-Component", component),
+			logger.NewField("Level", level.String()), logger.NewField("TTL", ttl.String()))
+		sendAPIResponse(w, r, http.StatusOK, "Log level set", log.Levels().Status(), dataFormat)
+	}
+}
+
+// resetLogLevel makes the component in the URL inherit the root level again
+func resetLogLevel(log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		component := chi.URLParam(r, "component")
+		if err := log.Levels().Reset(component); err != nil {
+			sendLogLevelError(w, r, err, dataFormat)
+			return
+		}
+
+		log.Ctx(r.Context()).Info("log level reset", logger.ComponentAudit, logger.NewField("Log-Component", component))
+		sendAPIResponse(w, r, http.StatusOK, "Log level reset", log.Levels().Status(), dataFormat)
+	}
+}
+
+func sendLogLevelError(w http.ResponseWriter, r *http.Request, err error, dataFormat dto.DataFormat) {
+	if errors.Is(err, logger.ErrUnknownComponent) {
+		sendAPIResponse(w, r, http.StatusNotFound, "Unknown log component", nil, dataFormat)
+		return
+	}
+	sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+}
@@ -0,0 +1,115 @@
+package v1
+
+import (
+	"errors"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/api/middlewares"
+	"github.com/ercross/payment_gateways/internal/api/utils"
+	"github.com/ercross/payment_gateways/internal/api/v1/dto"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/mfa"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	"net/http"
+)
+
+// enrollTOTP starts the TOTP enrollment of the authenticated user, responding with the secret
+// and the otpauth:// URI to add it to an authenticator app with
+//
+// Sample Request (POST /mfa/totp/enroll), no body
+func enrollTOTP(repo db.Repository, log *logger.Logger, totp *mfa.TOTP) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		userID, err := mfaUserID(r)
+		if err != nil {
+			sendAuthorizationError(w, r, err, dataFormat)
+			return
+		}
+
+		user, err := repo.GetUserByID(userID)
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to get user", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+				logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
+		enrollment, err := totp.Enroll(userID, user.Username)
+		if err != nil {
+			sendMFAError(w, r, log, err, dataFormat)
+			return
+		}
+
+		sendAPIResponse(w, r, http.StatusOK, "Add the secret to your authenticator app, then confirm it with a code", enrollment, dataFormat)
+	}
+}
+
+// confirmTOTP completes the TOTP enrollment of the authenticated user, responding with their recovery codes
+//
+// Sample Request (POST /mfa/totp/confirm):
+//
+//	{
+//	    "code": "123456"
+//	}
+func confirmTOTP(log *logger.Logger, totp *mfa.TOTP) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		var confirmation dto.TOTPConfirmationRequest
+		if err := utils.DecodeRequest(r, &confirmation); err != nil {
+			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+			return
+		}
+		if err := utils.ValidateDTO(confirmation, utils.ContentDataTypeToTag[dataFormat]); err != nil {
+			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+			return
+		}
+
+		userID, err := mfaUserID(r)
+		if err != nil {
+			sendAuthorizationError(w, r, err, dataFormat)
+			return
+		}
+
+		codes, err := totp.Confirm(userID, confirmation.Code)
+		if err != nil {
+			sendMFAError(w, r, log, err, dataFormat)
+			return
+		}
+
+		sendAPIResponse(w, r, http.StatusOK, "Two-factor authentication is set up. Store these recovery codes safely; they are only shown once",
+			dto.TOTPRecoveryCodes{RecoveryCodes: codes}, dataFormat)
+	}
+}
+
+var errMFARequiresUser = errors.New("two-factor authentication can only be set up by the user")
+
+// mfaUserID returns the ID of the user authenticated by r.
+// Merchants acting on behalf of users cannot manage their second factor.
+func mfaUserID(r *http.Request) (int, error) {
+	principal, err := middlewares.PrincipalFromContext(r.Context())
+	if err != nil {
+		return 0, err
+	}
+	if principal.APIKeyID != 0 {
+		return 0, errMFARequiresUser
+	}
+	return principal.UserID, nil
+}
+
+// sendMFAError responds to a request whose second factor could not be enrolled or verified
+func sendMFAError(w http.ResponseWriter, r *http.Request, log *logger.Logger, err error, dataFormat dto.DataFormat) {
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode):
+		sendAPIResponse(w, r, http.StatusUnauthorized, "Incorrect authentication code", nil, dataFormat)
+	case errors.Is(err, mfa.ErrLockedOut):
+		sendAPIResponse(w, r, http.StatusTooManyRequests, "Too many incorrect authentication codes. Please try again later", nil, dataFormat)
+	case errors.Is(err, mfa.ErrNotEnrolled), errors.Is(err, mfa.ErrAlreadyConfirmed):
+		sendAPIResponse(w, r, http.StatusConflict, err.Error(), nil, dataFormat)
+	default:
+		sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+		log.Error("failed to process authentication code", logger.NewField("Error", err.Error()),
+			logger.NewField("Request-ID", requestID(r)))
+	}
+}
@@ -1,24 +1,43 @@
 package v1
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/ercross/payment_gateways/db"
 	"github.com/ercross/payment_gateways/internal/logger"
 	"github.com/ercross/payment_gateways/internal/payment_gateways"
+	"github.com/ercross/payment_gateways/internal/settings"
 )
 
-func selectPaymentGateway(repo db.Repository, userCountryID int, log *logger.Logger) (gatewayImpl gateways.PaymentGateway, err error) {
+// selectPaymentGateway selects the first available gateway by priority for the country, skipping disabled gateways.
+// Priorities in current override those stored in the database.
+func selectPaymentGateway(
+	ctx context.Context,
+	repo db.Repository,
+	current *settings.Settings,
+	userCountryID int,
+	log *logger.Logger,
+) (gatewayImpl gateways.PaymentGateway, err error) {
 
-	priorityGateways, err := repo.GetGatewayPriorities(userCountryID)
-	if err != nil {
-		return gatewayImpl, fmt.Errorf("error getting gateway priorities: %w", err)
+	names, ok := current.Routing.Priorities[userCountryID]
+	if !ok {
+		priorityGateways, err := repo.GetGatewayPriorities(userCountryID)
+		if err != nil {
+			return gatewayImpl, fmt.Errorf("error getting gateway priorities: %w", err)
+		}
+		for _, pg := range priorityGateways {
+			names = append(names, pg.Gateway.Name)
+		}
 	}
 
-	if priorityGateways == nil || len(priorityGateways) == 0 {
+	if len(names) == 0 {
 
 		// select fallback gateway
-		gatewayImpl = gateways.GlobalDefault()
+		gatewayImpl = gateways.Instrument(ctx, gateways.GlobalDefault())
+		if current.GatewayDisabled(gatewayImpl.Name()) {
+			return nil, errors.New("default payment gateway is disabled")
+		}
 		if err = gatewayImpl.CheckAvailability(); err != nil {
 			return gatewayImpl, fmt.Errorf("error checking default gateway availability: %w", err)
 		}
@@ -28,15 +47,20 @@ func selectPaymentGateway(repo db.Repository, userCountryID int, log *logger.Log
 	} else {
 
 		// select from available gateways by priority
-		for _, pg := range priorityGateways {
-			gatewayImpl, err = gateways.PaymentGatewayFromName(pg.Gateway.Name)
+		for _, name := range names {
+			if current.GatewayDisabled(name) {
+				continue
+			}
+			// deposits never read receiving accounts
+			gatewayImpl, err = gateways.PaymentGatewayFromName(name, nil)
 			if err != nil {
-				log.Warn(err.Error(), logger.NewField("payment-gateway", pg.Gateway.Name))
+				log.Warn(err.Error(), logger.NewField("payment-gateway", name))
 				continue
 			}
+			gatewayImpl = gateways.Instrument(ctx, gatewayImpl)
 			err = gatewayImpl.CheckAvailability()
 			if err != nil {
-				log.Warn(err.Error(), logger.NewField("payment-gateway", pg.Gateway.Name))
+				log.Warn(err.Error(), logger.NewField("payment-gateway", name))
 				continue
 			}
 			return gatewayImpl, nil
@@ -3,9 +3,12 @@ package v1
 import (
 	"github.com/ercross/payment_gateways/db"
 	"github.com/ercross/payment_gateways/internal/api/middlewares"
-	"github.com/ercross/payment_gateways/internal/kafka"
+	"github.com/ercross/payment_gateways/internal/config"
 	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/mfa"
 	cache "github.com/ercross/payment_gateways/internal/redis"
+	"github.com/ercross/payment_gateways/internal/settings"
+	"github.com/ercross/payment_gateways/internal/vault"
 	"github.com/go-chi/chi/v5"
 	"net/http"
 )
@@ -18,45 +21,155 @@ const (
 func AddRoutes(
 	repo db.Repository,
 	log *logger.Logger,
-	publisher kafka.EventPublisher,
 	dstrCache cache.DistributedCache,
 	dstrRL *middlewares.DistributedRateLimiter,
-	baseURL string,
+	mfaRL *middlewares.DistributedRateLimiter,
+	authenticator *middlewares.JWTAuthenticator,
+	apiKeys *middlewares.APIKeyAuthenticator,
+	totp *mfa.TOTP,
+	tokenizer vault.Tokenizer,
+	detokenizer vault.Detokenizer,
+	authorizer *middlewares.Authorizer,
+	gatewayIdentifier *middlewares.ClientCertIdentifier,
+	store *settings.Store,
+	cfg *config.Config,
 ) http.Handler {
 	router := chi.NewRouter()
 
-	router.Mount("/callback", callbackRoutes(repo, log, publisher, dstrCache))
-	router.Mount("/", paymentsInitiationRoutes(repo, log, publisher, dstrCache, dstrRL, baseURL))
+	router.Mount("/callback", callbackRoutes(repo, log, dstrCache, gatewayIdentifier))
+	router.Mount("/admin", adminRoutes(repo, log, authenticator, authorizer, cfg))
+	router.Mount("/transactions", transactionRoutes(repo, log, authenticator, apiKeys, authorizer))
+	router.Mount("/accounts", accountRoutes(repo, log, authenticator, apiKeys))
+	router.Mount("/mfa", mfaRoutes(repo, log, mfaRL, authenticator, totp))
+	router.Mount("/", paymentsInitiationRoutes(repo, log, dstrCache, dstrRL, authenticator, apiKeys, totp, tokenizer, detokenizer, store, cfg))
 
 	return router
 }
 
+// callbackRoutes are called by payment gateways. When gatewayIdentifier is set, gateways must present a client certificate
+// and may only post callbacks for their own transactions.
 func callbackRoutes(repo db.Repository,
 	log *logger.Logger,
-	publisher kafka.EventPublisher,
 	dstrCache cache.DistributedCache,
+	gatewayIdentifier *middlewares.ClientCertIdentifier,
 ) http.Handler {
 	router := chi.NewRouter()
+	if gatewayIdentifier != nil {
+		router.Use(gatewayIdentifier.Identify)
+	}
 
-	router.Put("/withdrawal/{transaction-id}", withdrawalCallbackHandler(repo, log, publisher, dstrCache))
-	router.Put("/deposit/{transaction-id}", depositCallbackHandler(repo, log, publisher, dstrCache))
+	router.Put("/withdrawal/{transaction-id}", withdrawalCallbackHandler(repo, log, dstrCache))
+	router.Put("/deposit/{transaction-id}", depositCallbackHandler(repo, log, dstrCache))
 
 	return router
 }
 
 func paymentsInitiationRoutes(repo db.Repository,
 	log *logger.Logger,
-	publisher kafka.EventPublisher,
 	dstrCache cache.DistributedCache,
 	dstrRL *middlewares.DistributedRateLimiter,
-	baseURL string,
+	authenticator *middlewares.JWTAuthenticator,
+	apiKeys *middlewares.APIKeyAuthenticator,
+	totp *mfa.TOTP,
+	tokenizer vault.Tokenizer,
+	detokenizer vault.Detokenizer,
+	store *settings.Store,
+	cfg *config.Config,
 ) http.Handler {
 	router := chi.NewRouter()
-	router.Use(middlewares.Authenticate)
+
+	// merchants authenticate with an API key, users with a bearer token
+	router.Use(apiKeys.Authenticate)
+	router.Use(authenticator.Authenticate)
 	router.Use(dstrRL.Middleware)
 
-	router.Post("/withdrawal", initiateWithdrawal(repo, log, publisher, dstrCache, baseURL))
-	router.Post("/deposit", handleDeposit(repo, log, publisher, dstrCache, baseURL))
+	router.With(middlewares.RequireScope(middlewares.ScopeWithdrawalCreate)).
+		Post("/withdrawal", initiateWithdrawal(repo, log, dstrCache, store, totp, tokenizer, detokenizer, cfg.Server.BaseURL, cfg.Cache.TransactionTTL))
+	router.With(middlewares.RequireScope(middlewares.ScopeDepositCreate)).
+		Post("/deposit", handleDeposit(repo, log, dstrCache, store, cfg.Server.BaseURL, cfg.Cache.TransactionTTL))
+
+	return router
+}
+
+// mfaRoutes let users enroll an authenticator. They are rate limited apart from, and tighter than, payments,
+// so that confirmation codes cannot be guessed.
+func mfaRoutes(repo db.Repository,
+	log *logger.Logger,
+	mfaRL *middlewares.DistributedRateLimiter,
+	authenticator *middlewares.JWTAuthenticator,
+	totp *mfa.TOTP,
+) http.Handler {
+	router := chi.NewRouter()
+	router.Use(authenticator.Authenticate)
+	router.Use(mfaRL.Middleware)
+
+	router.Post("/totp/enroll", enrollTOTP(repo, log, totp))
+	router.Post("/totp/confirm", confirmTOTP(log, totp))
+
+	return router
+}
+
+// transactionRoutes let users, and merchants on their behalf, look up their transactions, and staff those of any user
+func transactionRoutes(repo db.Repository,
+	log *logger.Logger,
+	authenticator *middlewares.JWTAuthenticator,
+	apiKeys *middlewares.APIKeyAuthenticator,
+	authorizer *middlewares.Authorizer,
+) http.Handler {
+	router := chi.NewRouter()
+	router.Use(apiKeys.Authenticate)
+	router.Use(authenticator.Authenticate)
+	router.Use(middlewares.RequireScope(middlewares.ScopeTransactionsRead))
+
+	router.Get("/", listTransactions(repo, log, authorizer))
+	router.Get("/{transaction-id}", getTransaction(repo, log, authorizer))
+
+	return router
+}
+
+// accountRoutes let users, and merchants on their behalf, consult their balance and statements
+func accountRoutes(repo db.Repository,
+	log *logger.Logger,
+	authenticator *middlewares.JWTAuthenticator,
+	apiKeys *middlewares.APIKeyAuthenticator,
+) http.Handler {
+	router := chi.NewRouter()
+	router.Use(apiKeys.Authenticate)
+	router.Use(authenticator.Authenticate)
+	router.Use(middlewares.RequireScope(middlewares.ScopeAccountsRead))
+
+	router.Get("/me", getAccount(repo, log))
+	router.Get("/me/statement", getStatement(repo, log))
+
+	return router
+}
+
+// adminRoutes are reserved to staff, authenticated with a bearer token and authorized by the permissions of their roles
+func adminRoutes(repo db.Repository,
+	log *logger.Logger,
+	authenticator *middlewares.JWTAuthenticator,
+	authorizer *middlewares.Authorizer,
+	cfg *config.Config,
+) http.Handler {
+	router := chi.NewRouter()
+	router.Use(authenticator.Authenticate)
+
+	router.With(authorizer.RequirePermission(middlewares.PermissionGatewaysRead)).
+		Get("/gateways", listGateways(repo, log))
+	router.With(authorizer.RequirePermission(middlewares.PermissionGatewaysWrite)).
+		Post("/gateway-priorities", createGatewayPriority(repo, log))
+	router.With(authorizer.RequirePermission(middlewares.PermissionAccountsWrite)).
+		Put("/users/{user-id}/roles/{role}", assignUserRole(repo, log))
+	router.With(authorizer.RequirePermission(middlewares.PermissionAccountsWrite)).
+		Delete("/users/{user-id}/roles/{role}", revokeUserRole(repo, log))
+	router.With(authorizer.RequirePermission(middlewares.PermissionLogsRead)).
+		Get("/log-levels", listLogLevels(log))
+	router.With(authorizer.RequirePermission(middlewares.PermissionLogsWrite)).
+		Put("/log-levels/{component}", setLogLevel(log))
+	router.With(authorizer.RequirePermission(middlewares.PermissionLogsWrite)).
+		Delete("/log-levels/{component}", resetLogLevel(log))
+	router.With(authorizer.RequirePermission(middlewares.PermissionConfigRead)).
+		Get("/config", showConfig(cfg))
 
 	return router
 }
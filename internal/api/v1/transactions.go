@@ -0,0 +1,272 @@
+package v1
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/api/middlewares"
+	"github.com/ercross/payment_gateways/internal/api/utils"
+	"github.com/ercross/payment_gateways/internal/api/v1/dto"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTransactionPageSize = 50
+	maxTransactionPageSize     = 200
+)
+
+// transactionCursor is where the next page of transactions starts, and the order it was issued for
+type transactionCursor struct {
+	SortBy     string    `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	ID         int       `json:"i"`
+	CreatedAt  time.Time `json:"c"`
+	Amount     float64   `json:"a,omitempty"`
+}
+
+// getTransaction responds with the transaction in the URL.
+// Users only see their own transactions, staff granted transactions:read those of any user.
+//
+// Sample Request (GET /transactions/42)
+func getTransaction(repo db.Repository, log *logger.Logger, authorizer *middlewares.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		id, err := strconv.Atoi(chi.URLParam(r, "transaction-id"))
+		if err != nil || id <= 0 {
+			sendAPIResponse(w, r, http.StatusBadRequest, "Invalid transaction ID", nil, dataFormat)
+			return
+		}
+
+		owner, ok := transactionsOwner(w, r, log, authorizer, dataFormat)
+		if !ok {
+			return
+		}
+
+		trx, err := repo.GetTransactionByID(id)
+		if err != nil && !errors.Is(err, db.ErrDataNotFound) {
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to get transaction", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+				logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
+		// the transactions of other users are reported as missing, so as not to disclose they exist
+		if errors.Is(err, db.ErrDataNotFound) || (owner != 0 && trx.UserID != owner) {
+			sendAPIResponse(w, r, http.StatusNotFound, "Transaction not found", nil, dataFormat)
+			return
+		}
+
+		sendAPIResponse(w, r, http.StatusOK, "Transaction retrieved", utils.ConvertTransactionToDTO(trx), dataFormat)
+	}
+}
+
+// listTransactions responds with a page of transactions, the most recent first unless sorted otherwise.
+// Users only see their own transactions, staff granted transactions:read those of any user, or of user_id.
+//
+// Sample Request (GET /transactions?type=deposit&status=completed&currency=EUR&gateway=stripe&from=2024-01-01&to=2024-02-01&min_amount=10&max_amount=500&sort=amount&order=desc&limit=20)
+//
+// The next page is requested by adding the next_cursor of the response as the cursor parameter, with the same filters.
+func listTransactions(repo db.Repository, log *logger.Logger, authorizer *middlewares.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := tracing.Repository(r.Context(), repo)
+		dataFormat := utils.DetermineResponseContentDataType(r)
+
+		filter, page, err := parseTransactionQuery(r.URL.Query())
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusBadRequest, err.Error(), nil, dataFormat)
+			return
+		}
+
+		owner, ok := transactionsOwner(w, r, log, authorizer, dataFormat)
+		if !ok {
+			return
+		}
+		if owner != 0 {
+			if filter.UserID != 0 && filter.UserID != owner {
+				sendAuthorizationError(w, r, errUserMismatch, dataFormat)
+				return
+			}
+			filter.UserID = owner
+		}
+
+		// one more transaction than requested tells whether there is a next page
+		limit := page.Limit
+		page.Limit++
+		transactions, err := repo.ListTransactions(r.Context(), filter, page)
+		if err != nil {
+			sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+			log.Error("failed to list transactions", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+				logger.NewField("Request-ID", requestID(r)))
+			return
+		}
+
+		response := dto.TransactionPage{Transactions: make([]dto.Transaction, 0, len(transactions))}
+		if len(transactions) > limit {
+			transactions = transactions[:limit]
+			response.NextCursor = encodeTransactionCursor(page, transactions[limit-1])
+		}
+		for _, trx := range transactions {
+			response.Transactions = append(response.Transactions, utils.ConvertTransactionToDTO(trx))
+		}
+
+		sendAPIResponse(w, r, http.StatusOK, "Transactions retrieved", response, dataFormat)
+	}
+}
+
+// transactionsOwner returns the ID of the user whose transactions the principal of r may read, or 0 for staff who may
+// read the transactions of any user. It responds to the request itself when it returns false.
+func transactionsOwner(
+	w http.ResponseWriter,
+	r *http.Request,
+	log *logger.Logger,
+	authorizer *middlewares.Authorizer,
+	dataFormat dto.DataFormat,
+) (int, bool) {
+	principal, err := middlewares.PrincipalFromContext(r.Context())
+	if err != nil {
+		sendAuthorizationError(w, r, err, dataFormat)
+		return 0, false
+	}
+
+	staff, err := authorizer.HasPermission(principal, middlewares.PermissionTransactionsRead)
+	if err != nil {
+		sendAPIResponse(w, r, http.StatusInternalServerError, internalServerErrorMsg, nil, dataFormat)
+		log.Error("failed to get user permissions", logger.ComponentDatabase, logger.NewField("Error", err.Error()),
+			logger.NewField("Request-ID", requestID(r)))
+		return 0, false
+	}
+	if staff {
+		return 0, true
+	}
+	return principal.UserID, true
+}
+
+func parseTransactionQuery(query url.Values) (db.TransactionFilter, db.TransactionPage, error) {
+	filter := db.TransactionFilter{
+		Type:        query.Get("type"),
+		Status:      query.Get("status"),
+		Currency:    query.Get("currency"),
+		GatewayName: query.Get("gateway"),
+	}
+	page := db.TransactionPage{SortBy: db.SortByCreatedAt, Descending: true, Limit: defaultTransactionPageSize}
+	var err error
+
+	if filter.Type != "" && filter.Type != "deposit" && filter.Type != "withdrawal" {
+		return filter, page, errors.New("type must be deposit or withdrawal")
+	}
+	if value := query.Get("user_id"); value != "" {
+		if filter.UserID, err = strconv.Atoi(value); err != nil || filter.UserID <= 0 {
+			return filter, page, errors.New("invalid user_id")
+		}
+	}
+	if filter.From, err = parseQueryTime(query, "from"); err != nil {
+		return filter, page, err
+	}
+	if filter.To, err = parseQueryTime(query, "to"); err != nil {
+		return filter, page, err
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, page, errors.New("from must be before to")
+	}
+	if filter.MinAmount, err = parseQueryAmount(query, "min_amount"); err != nil {
+		return filter, page, err
+	}
+	if filter.MaxAmount, err = parseQueryAmount(query, "max_amount"); err != nil {
+		return filter, page, err
+	}
+	if filter.MaxAmount != 0 && filter.MinAmount > filter.MaxAmount {
+		return filter, page, errors.New("min_amount must not exceed max_amount")
+	}
+
+	switch sortBy := query.Get("sort"); sortBy {
+	case "", db.SortByCreatedAt:
+	case db.SortByAmount:
+		page.SortBy = db.SortByAmount
+	default:
+		return filter, page, errors.New("sort must be created_at or amount")
+	}
+	switch order := query.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		page.Descending = false
+	default:
+		return filter, page, errors.New("order must be asc or desc")
+	}
+	if value := query.Get("limit"); value != "" {
+		if page.Limit, err = strconv.Atoi(value); err != nil || page.Limit <= 0 || page.Limit > maxTransactionPageSize {
+			return filter, page, fmt.Errorf("limit must be between 1 and %d", maxTransactionPageSize)
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		if page.After, err = decodeTransactionCursor(cursor, page); err != nil {
+			return filter, page, err
+		}
+	}
+	return filter, page, nil
+}
+
+// parseQueryTime parses the query parameter name, either a date or an RFC 3339 time
+func parseQueryTime(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date (2006-01-02) or an RFC 3339 time", name)
+	}
+	return t, nil
+}
+
+func parseQueryAmount(query url.Values, name string) (float64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("%s must be a positive amount", name)
+	}
+	return amount, nil
+}
+
+func encodeTransactionCursor(page db.TransactionPage, last db.Transaction) string {
+	cursor, _ := json.Marshal(transactionCursor{
+		SortBy:     page.SortBy,
+		Descending: page.Descending,
+		ID:         last.ID,
+		CreatedAt:  last.CreatedAt,
+		Amount:     last.Amount,
+	})
+	return base64.RawURLEncoding.EncodeToString(cursor)
+}
+
+// decodeTransactionCursor returns the last transaction of the previous page, rejecting cursors issued for another order
+func decodeTransactionCursor(value string, page db.TransactionPage) (*db.Transaction, error) {
+	errInvalid := errors.New("invalid cursor")
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalid
+	}
+	var cursor transactionCursor
+	if err = json.Unmarshal(raw, &cursor); err != nil || cursor.ID <= 0 {
+		return nil, errInvalid
+	}
+	if cursor.SortBy != page.SortBy || cursor.Descending != page.Descending {
+		return nil, errors.New("cursor was issued for another sort order")
+	}
+	return &db.Transaction{ID: cursor.ID, CreatedAt: cursor.CreatedAt, Amount: cursor.Amount}, nil
+}
@@ -0,0 +1,214 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/api/middlewares"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// transactionsRepo serves transactions, recording the filter and page it was listed with
+type transactionsRepo struct {
+	db.Mock
+	transactions []db.Transaction
+	filter       db.TransactionFilter
+	page         db.TransactionPage
+}
+
+func (m *transactionsRepo) GetTransactionByID(id int) (db.Transaction, error) {
+	for _, trx := range m.transactions {
+		if trx.ID == id {
+			return trx, nil
+		}
+	}
+	return db.Transaction{}, db.ErrDataNotFound
+}
+
+func (m *transactionsRepo) ListTransactions(_ context.Context, filter db.TransactionFilter, page db.TransactionPage) ([]db.Transaction, error) {
+	m.filter, m.page = filter, page
+	if len(m.transactions) > page.Limit {
+		return m.transactions[:page.Limit], nil
+	}
+	return m.transactions, nil
+}
+
+// permissions grants transactions:read to the staff user
+type permissions struct{}
+
+const staffUserID = 99
+
+func (permissions) GetUserPermissions(userID int) ([]string, error) {
+	if userID == staffUserID {
+		return []string{middlewares.PermissionTransactionsRead}, nil
+	}
+	return nil, nil
+}
+
+func newTransactionsRouter(t *testing.T, repo db.Repository) http.Handler {
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+	authorizer := middlewares.NewAuthorizer(permissions{}, log)
+
+	router := chi.NewRouter()
+	router.Get("/transactions", listTransactions(repo, log, authorizer))
+	router.Get("/transactions/{transaction-id}", getTransaction(repo, log, authorizer))
+	return router
+}
+
+func serve(router http.Handler, path string, userID int, accept string) *httptest.ResponseRecorder {
+	req := authenticated(httptest.NewRequest(http.MethodGet, path, nil), userID)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGetTransaction_Ownership(t *testing.T) {
+	repo := &transactionsRepo{transactions: []db.Transaction{{ID: 1, UserID: 1, Amount: 100, Type: "deposit"}}}
+	router := newTransactionsRouter(t, repo)
+
+	assert.Equal(t, http.StatusOK, serve(router, "/transactions/1", 1, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(router, "/transactions/1", 2, "").Code, "other users must not see the transaction")
+	assert.Equal(t, http.StatusOK, serve(router, "/transactions/1", staffUserID, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(router, "/transactions/2", staffUserID, "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(router, "/transactions/abc", 1, "").Code)
+}
+
+func TestListTransactions_Filters(t *testing.T) {
+	repo := &transactionsRepo{}
+	router := newTransactionsRouter(t, repo)
+
+	query := url.Values{
+		"type":       {"deposit"},
+		"status":     {"completed"},
+		"currency":   {"EUR"},
+		"gateway":    {"stripe"},
+		"from":       {"2024-01-01"},
+		"to":         {"2024-02-01T00:00:00Z"},
+		"min_amount": {"10"},
+		"max_amount": {"500"},
+		"sort":       {"amount"},
+		"order":      {"asc"},
+		"limit":      {"20"},
+	}
+	rr := serve(router, "/transactions?"+query.Encode(), 1, "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	assert.Equal(t, db.TransactionFilter{
+		UserID:      1,
+		Type:        "deposit",
+		Status:      "completed",
+		Currency:    "EUR",
+		GatewayName: "stripe",
+		From:        time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		MinAmount:   10,
+		MaxAmount:   500,
+	}, repo.filter)
+	assert.Equal(t, db.SortByAmount, repo.page.SortBy)
+	assert.False(t, repo.page.Descending)
+	assert.Equal(t, 21, repo.page.Limit, "one more transaction is listed to detect the next page")
+}
+
+func TestListTransactions_Ownership(t *testing.T) {
+	repo := &transactionsRepo{}
+	router := newTransactionsRouter(t, repo)
+
+	assert.Equal(t, http.StatusForbidden, serve(router, "/transactions?user_id=2", 1, "").Code)
+
+	require.Equal(t, http.StatusOK, serve(router, "/transactions?user_id=2", staffUserID, "").Code)
+	assert.Equal(t, 2, repo.filter.UserID)
+
+	require.Equal(t, http.StatusOK, serve(router, "/transactions", staffUserID, "").Code)
+	assert.Zero(t, repo.filter.UserID, "staff list the transactions of every user by default")
+}
+
+func TestListTransactions_InvalidQuery(t *testing.T) {
+	router := newTransactionsRouter(t, &transactionsRepo{})
+
+	for _, query := range []string{
+		"type=refund",
+		"from=yesterday",
+		"from=2024-02-01&to=2024-01-01",
+		"min_amount=-1",
+		"min_amount=50&max_amount=10",
+		"sort=status",
+		"order=up",
+		"limit=0",
+		"limit=1000",
+		"cursor=not-a-cursor",
+	} {
+		assert.Equal(t, http.StatusBadRequest, serve(router, "/transactions?"+query, 1, "").Code, query)
+	}
+}
+
+func TestListTransactions_CursorPagination(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	repo := &transactionsRepo{transactions: []db.Transaction{
+		{ID: 3, UserID: 1, Amount: 30, CreatedAt: created.Add(2 * time.Hour)},
+		{ID: 2, UserID: 1, Amount: 20, CreatedAt: created.Add(time.Hour)},
+		{ID: 1, UserID: 1, Amount: 10, CreatedAt: created},
+	}}
+	router := newTransactionsRouter(t, repo)
+
+	rr := serve(router, "/transactions?limit=2", 1, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Data struct {
+			Transactions []struct {
+				ID int `json:"id"`
+			} `json:"transactions"`
+			NextCursor string `json:"next_cursor"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Data.Transactions, 2)
+	require.NotEmpty(t, response.Data.NextCursor)
+
+	require.Equal(t, http.StatusOK, serve(router, "/transactions?limit=2&cursor="+response.Data.NextCursor, 1, "").Code)
+	require.NotNil(t, repo.page.After)
+	assert.Equal(t, 2, repo.page.After.ID)
+	assert.True(t, created.Add(time.Hour).Equal(repo.page.After.CreatedAt))
+
+	// a cursor only continues the order it was issued for
+	assert.Equal(t, http.StatusBadRequest, serve(router, "/transactions?order=asc&cursor="+response.Data.NextCursor, 1, "").Code)
+
+	// the last page has no cursor
+	rr = serve(router, "/transactions?limit=5", 1, "")
+	response.Data.NextCursor = ""
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Empty(t, response.Data.NextCursor)
+}
+
+func TestListTransactions_XML(t *testing.T) {
+	repo := &transactionsRepo{transactions: []db.Transaction{{ID: 1, UserID: 1, Amount: 10, Currency: "EUR", GatewayName: "Stripe"}}}
+	router := newTransactionsRouter(t, repo)
+
+	rr := serve(router, "/transactions", 1, "application/xml")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/xml", rr.Header().Get("Content-Type"))
+
+	var response struct {
+		Transactions []struct {
+			ID      int    `xml:"id"`
+			Gateway string `xml:"gateway"`
+		} `xml:"data>transaction"`
+	}
+	require.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Transactions, 1)
+	assert.Equal(t, "Stripe", response.Transactions[0].Gateway)
+}
@@ -0,0 +1,177 @@
+// Package certs serves TLS certificates loaded from files, reloading them when the files change
+// so that certificates can be renewed without restarting the server.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+
+	// CertFile and KeyFile hold the PEM encoded server certificate chain and private key
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile holds the PEM encoded CAs client certificates are verified against.
+	// Client certificates are not requested when empty.
+	ClientCAFile string
+
+	// RequireClientCert rejects clients without a valid certificate.
+	// Otherwise, a client certificate is only verified when presented, leaving clients without one to other authentication methods.
+	RequireClientCert bool
+
+	// ReloadInterval is how often the files are checked for changes
+	ReloadInterval time.Duration
+}
+
+// Reloader provides TLS configurations using the latest certificates loaded from the configured files
+type Reloader struct {
+	config  Config
+	log     *logger.Logger
+	current atomic.Pointer[bundle]
+}
+
+// bundle is the set of certificates loaded from the files at a given time
+type bundle struct {
+	certificate tls.Certificate
+	clientCAs   *x509.CertPool
+	modTimes    map[string]time.Time
+}
+
+func NewReloader(config Config, log *logger.Logger) (*Reloader, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("TLS requires a certificate and a key file")
+	}
+	if config.RequireClientCert && config.ClientCAFile == "" {
+		return nil, errors.New("requiring client certificates requires a client CA file")
+	}
+
+	r := &Reloader{config: config, log: log}
+	b, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.current.Store(b)
+	return r, nil
+}
+
+// TLSConfig returns a server configuration whose certificates are those last loaded when each handshake starts
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.handshakeConfig(r.current.Load()), nil
+		},
+	}
+}
+
+func (r *Reloader) handshakeConfig(b *bundle) *tls.Config {
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{b.certificate},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if b.clientCAs != nil {
+		config.ClientCAs = b.clientCAs
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.config.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config
+}
+
+// Run reloads the certificates whenever the files change, until ctx is cancelled.
+// Certificates that fail to load are reported and the previous ones kept in use.
+func (r *Reloader) Run(ctx context.Context) {
+	interval := r.config.ReloadInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				r.log.Error("error reloading TLS certificates", logger.NewField("Error", err.Error()))
+			}
+		}
+	}
+}
+
+// Reload loads the certificates again if any of the files changed since they were last loaded
+func (r *Reloader) Reload() error {
+	previous := r.current.Load()
+	changed := false
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Equal(previous.modTimes[path]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	b, err := r.load()
+	if err != nil {
+		return err
+	}
+	r.current.Store(b)
+	r.log.Info("TLS certificates reloaded", logger.NewField("Certificate", r.config.CertFile))
+	return nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) load() (*bundle, error) {
+	b := &bundle{modTimes: make(map[string]time.Time)}
+
+	// record modification times first, so that a file changing while being loaded is loaded again
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		b.modTimes[path] = info.ModTime()
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading server certificate: %w", err)
+	}
+	b.certificate = certificate
+
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA file: %w", err)
+		}
+		b.clientCAs = x509.NewCertPool()
+		if !b.clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in client CA file %s", r.config.ClientCAFile)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,75 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed certificate for commonName and its key to certFile and keyFile
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+func servedCommonName(t *testing.T, r *Reloader) string {
+	config, err := r.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestReloader_ReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writeCertificate(t, certFile, keyFile, "old.example.com")
+
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+	reloader, err := NewReloader(Config{CertFile: certFile, KeyFile: keyFile}, log)
+	require.NoError(t, err)
+	assert.Equal(t, "old.example.com", servedCommonName(t, reloader))
+
+	writeCertificate(t, certFile, keyFile, "new.example.com")
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+
+	require.NoError(t, reloader.Reload())
+	assert.Equal(t, "new.example.com", servedCommonName(t, reloader))
+
+	// a broken key keeps the previous certificate in use
+	require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0600))
+	require.NoError(t, os.Chtimes(keyFile, later.Add(time.Second), later.Add(time.Second)))
+	assert.Error(t, reloader.Reload())
+	assert.Equal(t, "new.example.com", servedCommonName(t, reloader))
+}
@@ -0,0 +1,455 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources of settings, by increasing precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Redacted replaces the value of secret settings in Settings
+const Redacted = "[REDACTED]"
+
+// Config is the configuration of the API server.
+//
+// Each setting is read from, by increasing precedence, its default tag, the YAML file given by the -config flag
+// or the CONFIG_FILE environment variable, the environment variable of its env tag, and the command line flag of
+// its flag tag. The value of an environment variable may also be read from the file its <NAME>_FILE variable names,
+// unless another setting uses that variable, which keeps secrets such as DATABASE_URL_FILE out of the environment.
+type Config struct {
+	Server     Server          `yaml:"server"`
+	Database   Database        `yaml:"database"`
+	Redis      Redis           `yaml:"redis"`
+	Kafka      Kafka           `yaml:"kafka"`
+	Encryption Encryption      `yaml:"encryption"`
+	JWT        JWT             `yaml:"jwt"`
+	Logging    Logging         `yaml:"logging"`
+	Tracing    Tracing         `yaml:"tracing"`
+	RateLimit  RateLimit       `yaml:"rate_limit"`
+	MFALimit   MFARateLimit    `yaml:"mfa_rate_limit"`
+	Dynamic    DynamicSettings `yaml:"dynamic"`
+	Cache      Cache           `yaml:"cache"`
+
+	// sources records where each setting was read from, by path
+	sources map[string]string
+}
+
+type Server struct {
+	Port            string        `yaml:"port" env:"API_PORT" flag:"port" validate:"required"`
+	MetricsPort     string        `yaml:"metrics_port" env:"METRICS_PORT" flag:"metrics-port" default:"9090" validate:"required,nefield=Port"`
+	BaseURL         string        `yaml:"base_url" env:"API_URL" flag:"base-url"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"30s" validate:"gt=0"`
+	TLS             TLS           `yaml:"tls"`
+}
+
+type TLS struct {
+	CertFile          string `yaml:"cert_file" env:"TLS_CERT_FILE" validate:"required_with=ClientCertsFile"`
+	KeyFile           string `yaml:"key_file" env:"TLS_KEY_FILE" validate:"required_with=CertFile"`
+	ClientCAFile      string `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" validate:"required_with=ClientCertsFile"`
+	RequireClientCert bool   `yaml:"require_client_cert" env:"TLS_REQUIRE_CLIENT_CERT"`
+
+	// ClientCertsFile maps the client certificates of callbacks to gateways, which requires TLS
+	// and ClientCAFile to verify the certificates against
+	ClientCertsFile string `yaml:"client_certs_file" env:"CALLBACK_CLIENT_CERTS_FILE"`
+}
+
+type Database struct {
+	URL           string `yaml:"url" env:"DATABASE_URL" flag:"database-url" secret:"true" validate:"required"`
+	MigrationsDir string `yaml:"migrations_dir" env:"MIGRATIONS"`
+}
+
+type Redis struct {
+	Addr     string `yaml:"addr" env:"REDIS_ADDR" flag:"redis-addr" validate:"required"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD" secret:"true"`
+	DB       int    `yaml:"db" env:"REDIS_DB" validate:"gte=0"`
+
+	// LockTTL is how long a distributed lock is held when its holder does not release it
+	LockTTL time.Duration `yaml:"lock_ttl" env:"REDIS_LOCK_TTL" default:"8s" validate:"gt=0"`
+}
+
+type Kafka struct {
+	BrokerURL          string `yaml:"broker_url" env:"KAFKA_BROKER_URL" flag:"kafka-broker-url" validate:"required"`
+	Topics             string `yaml:"topics" env:"KAFKA_TOPICS" default:"transactions.json:json" validate:"required"`
+	SchemaRegistryFile string `yaml:"schema_registry_file" env:"SCHEMA_REGISTRY_FILE" default:"schema_registry.json"`
+
+	// OutboxMaxAttempts failed publishes, spaced from OutboxRetryDelay doubling up to OutboxMaxRetryDelay,
+	// move an outbox event to the dead letter store: about an hour of broker outage with the defaults
+	OutboxMaxAttempts   int           `yaml:"outbox_max_attempts" env:"OUTBOX_MAX_ATTEMPTS" default:"20" validate:"gt=0"`
+	OutboxRetryDelay    time.Duration `yaml:"outbox_retry_delay" env:"OUTBOX_RETRY_DELAY" default:"1s" validate:"gt=0"`
+	OutboxMaxRetryDelay time.Duration `yaml:"outbox_max_retry_delay" env:"OUTBOX_MAX_RETRY_DELAY" default:"5m" validate:"gtefield=OutboxRetryDelay"`
+
+	// Dead letter events are replayed automatically until they have failed DeadLetterMaxAttempts times in all,
+	// counting the attempts made from the outbox, spaced from DeadLetterRetryDelay doubling up to DeadLetterMaxRetryDelay
+	DeadLetterMaxAttempts   int           `yaml:"dead_letter_max_attempts" env:"DEAD_LETTER_MAX_ATTEMPTS" default:"50" validate:"gtfield=OutboxMaxAttempts"`
+	DeadLetterRetryDelay    time.Duration `yaml:"dead_letter_retry_delay" env:"DEAD_LETTER_RETRY_DELAY" default:"5m" validate:"gt=0"`
+	DeadLetterMaxRetryDelay time.Duration `yaml:"dead_letter_max_retry_delay" env:"DEAD_LETTER_MAX_RETRY_DELAY" default:"6h" validate:"gtefield=DeadLetterRetryDelay"`
+}
+
+type Encryption struct {
+	KeysFile string `yaml:"keys_file" env:"ENCRYPTION_KEYS_FILE"`
+	Keys     string `yaml:"keys" env:"ENCRYPTION_KEYS" secret:"true"`
+	Key      string `yaml:"key" env:"ENCRYPTION_KEY" secret:"true"`
+
+	// BuiltinKey keeps data encrypted with the key built into earlier versions readable, until cmd/reencrypt has run.
+	// The key is public, so it is opted into when upgrading only.
+	BuiltinKey bool `yaml:"builtin_key" env:"ENCRYPTION_BUILTIN_KEY"`
+
+	LocalKMSKeysFile string `yaml:"local_kms_keys_file" env:"LOCAL_KMS_KEYS_FILE"`
+	KMSURL           string `yaml:"kms_url" env:"KMS_URL" validate:"omitempty,url"`
+	KMSKeyID         string `yaml:"kms_key_id" env:"KMS_KEY_ID" validate:"required_with=KMSURL"`
+	KMSToken         string `yaml:"kms_token" env:"KMS_TOKEN" secret:"true"`
+}
+
+type JWT struct {
+	HMACSecret string   `yaml:"hmac_secret" env:"JWT_HMAC_SECRET" secret:"true" validate:"required_without=JWKSURL"`
+	JWKSURL    string   `yaml:"jwks_url" env:"JWT_JWKS_URL" validate:"omitempty,url"`
+	Issuer     string   `yaml:"issuer" env:"JWT_ISSUER"`
+	Audience   string   `yaml:"audience" env:"JWT_AUDIENCE"`
+	Algorithms []string `yaml:"algorithms" env:"JWT_ALGORITHMS" validate:"dive,oneof=HS256 RS256 EdDSA"`
+}
+
+type Logging struct {
+	// Level is the root level, which the per component levels inherit unless set
+	Level        string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" default:"info" validate:"oneof=debug info warn error"`
+	File         string `yaml:"file" env:"LOG_FILE"`
+	RemoteURL    string `yaml:"remote_url" env:"LOG_REMOTE_URL" secret:"true" validate:"omitempty,url"`
+	RemoteFormat string `yaml:"remote_format" env:"LOG_REMOTE_FORMAT" validate:"omitempty,oneof=ndjson loki"`
+}
+
+type Tracing struct {
+	ServiceName  string  `yaml:"service_name" env:"OTEL_SERVICE_NAME" default:"payment_gateways"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" validate:"omitempty,url"`
+	SampleRatio  float64 `yaml:"sample_ratio" env:"OTEL_TRACES_SAMPLER_ARG" default:"1" validate:"gte=0,lte=1"`
+}
+
+// RateLimit is how many payment requests each user may send per window
+type RateLimit struct {
+	Requests int           `yaml:"requests" env:"RATE_LIMIT_REQUESTS" default:"3" validate:"gt=0"`
+	Window   time.Duration `yaml:"window" env:"RATE_LIMIT_WINDOW" default:"1m" validate:"gt=0"`
+}
+
+// MFARateLimit is how many attempts to enroll or confirm an authenticator each user may make per window,
+// kept low to slow down guessing of TOTP codes
+type MFARateLimit struct {
+	Requests int           `yaml:"requests" env:"MFA_RATE_LIMIT_REQUESTS" default:"5" validate:"gt=0"`
+	Window   time.Duration `yaml:"window" env:"MFA_RATE_LIMIT_WINDOW" default:"15m" validate:"gt=0"`
+}
+
+// DynamicSettings locates the settings reloaded while the server runs, overriding RateLimit
+type DynamicSettings struct {
+	File           string        `yaml:"file" env:"DYNAMIC_SETTINGS_FILE"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"DYNAMIC_SETTINGS_RELOAD_INTERVAL" default:"10s" validate:"gt=0"`
+}
+
+type Cache struct {
+	TransactionTTL time.Duration `yaml:"transaction_ttl" env:"CACHE_TRANSACTION_TTL" default:"5m" validate:"gt=0"`
+}
+
+// Setting is the value of a setting and where it was read from
+type Setting struct {
+	Name   string `json:"name" xml:"name"`
+	Env    string `json:"env,omitempty" xml:"env,omitempty"`
+	Value  string `json:"value" xml:"value"`
+	Source string `json:"source" xml:"source"`
+}
+
+// setting is a field of Config with its tags
+type setting struct {
+	path   string
+	value  reflect.Value
+	env    string
+	flag   string
+	def    string
+	secret bool
+}
+
+// Load loads the configuration from args, the command line arguments including the program name,
+// the environment read through lookupEnv, such as os.LookupEnv, and the configuration file, then validates it.
+// Every invalid setting is reported in the returned error.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	config := &Config{sources: make(map[string]string)}
+	settings := collect(reflect.ValueOf(config).Elem(), "")
+
+	var errs []error
+	set := func(s setting, raw, source string) {
+		if err := parse(s.value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name(), err))
+			return
+		}
+		config.sources[s.path] = source
+	}
+
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	configFile := flags.String("config", "", "path of a YAML configuration file, overriding CONFIG_FILE")
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		name := s.flag
+		flags.Func(name, "overrides "+s.env, func(value string) error {
+			flagValues[name] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+
+	for _, s := range settings {
+		if s.def != "" {
+			set(s, s.def, SourceDefault)
+		}
+	}
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := config.loadFile(path, settings); err != nil {
+			return nil, err
+		}
+	}
+
+	envs := make(map[string]bool, len(settings))
+	for _, s := range settings {
+		envs[s.env] = true
+	}
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok && value != "" {
+			set(s, value, SourceEnv)
+			continue
+		}
+		if envs[s.env+"_FILE"] {
+			continue
+		}
+		if file, ok := lookupEnv(s.env + "_FILE"); ok {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s_FILE: %w", s.env, err))
+				continue
+			}
+			set(s, strings.TrimRight(string(content), "\r\n"), SourceEnv)
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := flagValues[s.flag]; ok {
+			set(s, value, SourceFlag)
+		}
+	}
+
+	if len(errs) == 0 {
+		errs = append(errs, config.validate())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return config, nil
+}
+
+// loadFile reads the settings of the YAML file at path, rejecting unknown keys
+func (c *Config) loadFile(path string, settings []setting) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening configuration file: %w", err)
+	}
+	defer file.Close()
+
+	// the file is decoded onto a copy, so as to know which settings it sets
+	var node yaml.Node
+	decoder := yaml.NewDecoder(file)
+	if err = decoder.Decode(&node); err != nil {
+		return fmt.Errorf("error reading configuration file %s: %w", path, err)
+	}
+	if len(node.Content) == 0 {
+		return nil
+	}
+	if err = decodeStrict(&node, c); err != nil {
+		return fmt.Errorf("error reading configuration file %s: %w", path, err)
+	}
+	for _, s := range settings {
+		if lookupNode(node.Content[0], strings.Split(s.path, ".")) {
+			c.sources[s.path] = SourceFile
+		}
+	}
+	return nil
+}
+
+// decodeStrict decodes node into out, failing on keys matching no setting
+func decodeStrict(node *yaml.Node, out any) error {
+	raw, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(strings.NewReader(string(raw)))
+	decoder.KnownFields(true)
+	return decoder.Decode(out)
+}
+
+// lookupNode reports whether the mapping node holds a value at path
+func lookupNode(node *yaml.Node, path []string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return true
+		}
+		return lookupNode(node.Content[i+1], path[1:])
+	}
+	return false
+}
+
+func (c *Config) validate() error {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		if env := field.Tag.Get("env"); env != "" {
+			return env
+		}
+		return field.Name
+	})
+
+	err := validate.Struct(c)
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+	errs := make([]error, len(validationErrors))
+	for i, e := range validationErrors {
+		if e.Param() != "" {
+			errs[i] = fmt.Errorf("%s: failed validation %s=%s", e.Field(), e.ActualTag(), e.Param())
+		} else {
+			errs[i] = fmt.Errorf("%s: failed validation %s", e.Field(), e.ActualTag())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Settings returns every setting, with where it was read from, and the value of secrets redacted
+func (c *Config) Settings() []Setting {
+	settings := collect(reflect.ValueOf(c).Elem(), "")
+	result := make([]Setting, 0, len(settings))
+	for _, s := range settings {
+		value := format(s.value)
+		if s.secret && value != "" {
+			value = Redacted
+		}
+		source, ok := c.sources[s.path]
+		if !ok {
+			source = SourceDefault
+		}
+		result = append(result, Setting{Name: s.path, Env: s.env, Value: value, Source: source})
+	}
+	return result
+}
+
+func (s setting) name() string {
+	if s.env != "" {
+		return s.env
+	}
+	return s.path
+}
+
+// collect returns the settings of the struct v, whose fields are named after prefix
+func collect(v reflect.Value, prefix string) []setting {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		path := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+			settings = append(settings, collect(v.Field(i), path+".")...)
+			continue
+		}
+		settings = append(settings, setting{
+			path:   path,
+			value:  v.Field(i),
+			env:    field.Tag.Get("env"),
+			flag:   field.Tag.Get("flag"),
+			def:    field.Tag.Get("default"),
+			secret: field.Tag.Get("secret") == "true",
+		})
+	}
+	return settings
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parse sets v to raw, a comma separated list for slices
+func parse(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		v.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+func format(v reflect.Value) string {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(values, ",")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
@@ -0,0 +1,197 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hamba/avro/v2"
+)
+
+// avroSchema describes the records written by avroCodec.
+// New fields must declare a default so that consumers on the new schema can read older messages.
+const avroSchema = `{
+  "type": "record",
+  "name": "Envelope",
+  "namespace": "payment_gateways.events.v1",
+  "fields": [
+    {"name": "specversion", "type": "string"},
+    {"name": "id", "type": "string"},
+    {"name": "type", "type": "string"},
+    {"name": "source", "type": "string"},
+    {"name": "subject", "type": "string"},
+    {"name": "time", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+    {"name": "datacontenttype", "type": "string"},
+    {"name": "dataschema", "type": "string"},
+    {"name": "schemaversion", "type": "int"},
+    {"name": "correlationid", "type": "string", "default": ""},
+    {"name": "data", "type": {
+      "type": "record",
+      "name": "TransactionData",
+      "fields": [
+        {"name": "transaction_id", "type": "long"},
+        {"name": "type", "type": "string"},
+        {"name": "status", "type": "string"},
+        {"name": "previous_status", "type": "string", "default": ""},
+        {"name": "amount", "type": "double"},
+        {"name": "currency", "type": "string"},
+        {"name": "gateway_name", "type": "string"},
+        {"name": "country_name", "type": "string"},
+        {"name": "sensitive", "type": "bytes"}
+      ]
+    }}
+  ]
+}`
+
+type avroEnvelope struct {
+	SpecVersion     string              `avro:"specversion"`
+	ID              string              `avro:"id"`
+	Type            string              `avro:"type"`
+	Source          string              `avro:"source"`
+	Subject         string              `avro:"subject"`
+	Time            time.Time           `avro:"time"`
+	DataContentType string              `avro:"datacontenttype"`
+	DataSchema      string              `avro:"dataschema"`
+	SchemaVersion   int                 `avro:"schemaversion"`
+	CorrelationID   string              `avro:"correlationid"`
+	Data            avroTransactionData `avro:"data"`
+}
+
+type avroTransactionData struct {
+	TransactionID  int64   `avro:"transaction_id"`
+	Type           string  `avro:"type"`
+	Status         string  `avro:"status"`
+	PreviousStatus string  `avro:"previous_status"`
+	Amount         float64 `avro:"amount"`
+	Currency       string  `avro:"currency"`
+	GatewayName    string  `avro:"gateway_name"`
+	CountryName    string  `avro:"country_name"`
+	Sensitive      []byte  `avro:"sensitive"`
+}
+
+// avroCodec serializes envelopes as Avro records of avroSchema
+type avroCodec struct {
+	schemaID int
+	schema   avro.Schema
+	registry SchemaRegistry
+
+	// writerSchemas caches the parsed schemas messages were written with, by schema ID
+	writerSchemas sync.Map
+}
+
+func newAvroCodec(schemaID int, registry SchemaRegistry) (*avroCodec, error) {
+	schema, err := avro.Parse(avroSchema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %w", err)
+	}
+	return &avroCodec{schemaID: schemaID, schema: schema, registry: registry}, nil
+}
+
+func (c *avroCodec) Name() string        { return CodecAvro }
+func (c *avroCodec) ContentType() string { return "application/avro" }
+
+func (c *avroCodec) Encode(e Envelope) ([]byte, error) {
+	data, err := e.TransactionData()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := avro.Marshal(c.schema, avroEnvelope{
+		SpecVersion:     e.SpecVersion,
+		ID:              e.ID,
+		Type:            string(e.Type),
+		Source:          e.Source,
+		Subject:         e.Subject,
+		Time:            e.Time,
+		DataContentType: e.DataContentType,
+		DataSchema:      e.DataSchema,
+		SchemaVersion:   e.SchemaVersion,
+		CorrelationID:   e.CorrelationID,
+		Data: avroTransactionData{
+			TransactionID:  int64(data.TransactionID),
+			Type:           data.Type,
+			Status:         data.Status,
+			PreviousStatus: data.PreviousStatus,
+			Amount:         data.Amount,
+			Currency:       data.Currency,
+			GatewayName:    data.GatewayName,
+			CountryName:    data.CountryName,
+			Sensitive:      data.Sensitive,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding avro envelope: %w", err)
+	}
+	return frame(c.schemaID, payload), nil
+}
+
+func (c *avroCodec) Decode(raw []byte) (Envelope, error) {
+	schemaID, payload, err := unframe(raw)
+	if err != nil {
+		return Envelope{}, err
+	}
+	writerSchema, err := c.writerSchema(schemaID)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	var record avroEnvelope
+	if err = avro.Unmarshal(writerSchema, payload, &record); err != nil {
+		return Envelope{}, fmt.Errorf("error decoding avro envelope: %w", err)
+	}
+
+	data, err := json.Marshal(TransactionData{
+		TransactionID:  int(record.Data.TransactionID),
+		Type:           record.Data.Type,
+		Status:         record.Data.Status,
+		PreviousStatus: record.Data.PreviousStatus,
+		Amount:         record.Data.Amount,
+		Currency:       record.Data.Currency,
+		GatewayName:    record.Data.GatewayName,
+		CountryName:    record.Data.CountryName,
+		Sensitive:      record.Data.Sensitive,
+	})
+	if err != nil {
+		return Envelope{}, fmt.Errorf("error serializing event data: %w", err)
+	}
+
+	return Envelope{
+		SpecVersion:     record.SpecVersion,
+		ID:              record.ID,
+		Type:            Type(record.Type),
+		Source:          record.Source,
+		Subject:         record.Subject,
+		Time:            record.Time.UTC(),
+		DataContentType: record.DataContentType,
+		DataSchema:      record.DataSchema,
+		SchemaVersion:   record.SchemaVersion,
+		CorrelationID:   record.CorrelationID,
+		Data:            data,
+	}, nil
+}
+
+// writerSchema returns the schema a message was written with, so that messages written with older versions
+// of avroSchema can still be decoded
+func (c *avroCodec) writerSchema(schemaID int) (avro.Schema, error) {
+	if schemaID == c.schemaID {
+		return c.schema, nil
+	}
+	if cached, ok := c.writerSchemas.Load(schemaID); ok {
+		return cached.(avro.Schema), nil
+	}
+
+	registered, err := c.registry.SchemaByID(schemaID)
+	if err != nil {
+		return nil, err
+	}
+	if registered.Type != SchemaTypeAvro {
+		return nil, fmt.Errorf("message written with non avro schema %d", schemaID)
+	}
+	schema, err := avro.Parse(registered.Definition)
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema %d: %w", schemaID, err)
+	}
+	c.writerSchemas.Store(schemaID, schema)
+	return schema, nil
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	CodecJSON     = "json"
+	CodecProtobuf = "protobuf"
+	CodecAvro     = "avro"
+)
+
+// wireMagicByte prefixes messages serialized by a schema backed Codec, followed by the 4 byte big-endian schema ID
+// (compatible with the Confluent wire format)
+const wireMagicByte byte = 0
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Codec serializes event envelopes for a Kafka topic
+type Codec interface {
+
+	// Name is the name the Codec is selected by in topic configuration
+	Name() string
+
+	// ContentType is the MIME type of messages produced by Encode
+	ContentType() string
+
+	Encode(e Envelope) ([]byte, error)
+	Decode(raw []byte) (Envelope, error)
+}
+
+// NewCodec returns the Codec called name.
+// Schema backed codecs register their schema under subject in registry when created,
+// so that an incompatible schema change fails at startup rather than when consumers read it.
+func NewCodec(name string, registry SchemaRegistry, subject string) (Codec, error) {
+	switch name {
+	case CodecJSON:
+		return new(jsonCodec), nil
+	case CodecProtobuf:
+		schema, err := registry.Register(subject, SchemaTypeProtobuf, protobufSchema)
+		if err != nil {
+			return nil, fmt.Errorf("error registering protobuf schema for %s: %w", subject, err)
+		}
+		return &protobufCodec{schemaID: schema.ID, registry: registry}, nil
+	case CodecAvro:
+		schema, err := registry.Register(subject, SchemaTypeAvro, avroSchema)
+		if err != nil {
+			return nil, fmt.Errorf("error registering avro schema for %s: %w", subject, err)
+		}
+		return newAvroCodec(schema.ID, registry)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+	}
+}
+
+// jsonCodec serializes envelopes in CloudEvents JSON structured content mode
+type jsonCodec struct{}
+
+func (c *jsonCodec) Name() string        { return CodecJSON }
+func (c *jsonCodec) ContentType() string { return "application/cloudevents+json" }
+
+func (c *jsonCodec) Encode(e Envelope) ([]byte, error) {
+	return e.Marshal()
+}
+
+func (c *jsonCodec) Decode(raw []byte) (Envelope, error) {
+	return Unmarshal(raw)
+}
+
+// frame prefixes payload with the wire format header identifying schemaID
+func frame(schemaID int, payload []byte) []byte {
+	framed := make([]byte, 5, 5+len(payload))
+	framed[0] = wireMagicByte
+	binary.BigEndian.PutUint32(framed[1:5], uint32(schemaID))
+	return append(framed, payload...)
+}
+
+// unframe splits a message produced by frame into its schema ID and payload
+func unframe(raw []byte) (schemaID int, payload []byte, err error) {
+	if len(raw) < 5 || raw[0] != wireMagicByte {
+		return 0, nil, errors.New("message is not in schema registry wire format")
+	}
+	return int(binary.BigEndian.Uint32(raw[1:5])), raw[5:], nil
+}
@@ -0,0 +1,71 @@
+package events
+
+import (
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	services.InitEncryptionKey("0123456789abcdef")
+	registry, err := NewFileRegistry(filepath.Join(t.TempDir(), "registry.json"))
+	require.NoError(t, err)
+
+	trx := db.Transaction{ID: 9, Amount: 12.5, Type: "deposit", Status: "pending", Currency: "EUR", GatewayName: "stripe"}
+	event, err := NewTransactionEvent(TypeDepositInitiated, trx, "", SensitiveData{UserID: 3}, "req-9")
+	require.NoError(t, err)
+
+	for _, name := range []string{CodecJSON, CodecProtobuf, CodecAvro} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := NewCodec(name, registry, "transactions."+name+"-value")
+			require.NoError(t, err)
+
+			raw, err := codec.Encode(event)
+			require.NoError(t, err)
+
+			decoded, err := codec.Decode(raw)
+			require.NoError(t, err)
+			assert.Equal(t, event.ID, decoded.ID)
+			assert.Equal(t, event.Type, decoded.Type)
+			assert.WithinDuration(t, event.Time, decoded.Time, time.Microsecond)
+			assert.Equal(t, event.CorrelationID, decoded.CorrelationID)
+			assert.JSONEq(t, string(event.Data), string(decoded.Data))
+		})
+	}
+}
+
+func TestFileRegistry_RejectsIncompatibleSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	registry, err := NewFileRegistry(path)
+	require.NoError(t, err)
+
+	first, err := registry.Register("transactions.avro-value", SchemaTypeAvro, avroSchema)
+	require.NoError(t, err)
+
+	again, err := registry.Register("transactions.avro-value", SchemaTypeAvro, avroSchema)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+
+	// a new field without a default cannot be read from messages written with the first version
+	incompatible := strings.Replace(avroSchema, `{"name": "specversion", "type": "string"},`,
+		`{"name": "specversion", "type": "string"}, {"name": "tenant", "type": "string"},`, 1)
+	_, err = registry.Register("transactions.avro-value", SchemaTypeAvro, incompatible)
+	assert.ErrorIs(t, err, ErrIncompatibleSchema)
+
+	protobufV2 := strings.Replace(protobufSchema, "string correlationid = 10;", "int64 correlationid = 10;", 1)
+	_, err = registry.Register("transactions.protobuf-value", SchemaTypeProtobuf, protobufSchema)
+	require.NoError(t, err)
+	_, err = registry.Register("transactions.protobuf-value", SchemaTypeProtobuf, protobufV2)
+	assert.ErrorIs(t, err, ErrIncompatibleSchema)
+
+	reloaded, err := NewFileRegistry(path)
+	require.NoError(t, err)
+	latest, err := reloaded.LatestSchema("transactions.avro-value")
+	require.NoError(t, err)
+	assert.Equal(t, first, latest)
+}
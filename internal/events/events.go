@@ -0,0 +1,210 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/services"
+	"time"
+)
+
+// Type identifies what happened to a transaction. Consumers should dispatch on Type rather than on topic.
+type Type string
+
+const (
+	TypeDepositInitiated         Type = "deposit.initiated"
+	TypeWithdrawalInitiated      Type = "withdrawal.initiated"
+	TypeWithdrawalRegistered     Type = "withdrawal.registered"
+	TypeTransactionStatusChanged Type = "transaction.status_changed"
+)
+
+const (
+	// SpecVersion is the CloudEvents specification version the Envelope conforms to
+	SpecVersion = "1.0"
+
+	// Source identifies this service as the producer of events
+	Source = "/payment-gateways"
+
+	contentTypeJSON = "application/json"
+)
+
+// schemaVersions holds the current schema version of the data carried by each event Type.
+// Bump the version whenever a backward-incompatible change is made to the data of an event Type.
+var schemaVersions = map[Type]int{
+	TypeDepositInitiated:         1,
+	TypeWithdrawalInitiated:      1,
+	TypeWithdrawalRegistered:     1,
+	TypeTransactionStatusChanged: 1,
+}
+
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// Envelope is a CloudEvents (structured content mode) compatible event envelope.
+// SchemaVersion and CorrelationID are CloudEvents extension attributes.
+type Envelope struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Type            Type            `json:"type"`
+	Source          string          `json:"source"`
+	Subject         string          `json:"subject"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema"`
+	SchemaVersion   int             `json:"schemaversion"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// TransactionData is the data carried by every transaction event Type.
+// Fields that identify the user or their accounts are kept out of plain view in Sensitive.
+type TransactionData struct {
+	TransactionID  int     `json:"transaction_id"`
+	Type           string  `json:"type"`
+	Status         string  `json:"status"`
+	PreviousStatus string  `json:"previous_status,omitempty"`
+	Amount         float64 `json:"amount"`
+	Currency       string  `json:"currency"`
+	GatewayName    string  `json:"gateway_name"`
+	CountryName    string  `json:"country_name"`
+
+	// Sensitive is SensitiveData encrypted with services.MaskData
+	Sensitive []byte `json:"sensitive"`
+}
+
+// SensitiveData holds the fields of a transaction event that are encrypted before publishing
+type SensitiveData struct {
+	UserID int `json:"user_id"`
+
+	// ReceivingAccount is the vault token standing for the account a withdrawal is paid to
+	ReceivingAccount string `json:"receiving_account,omitempty"`
+}
+
+// NewTransactionEvent builds the Envelope of eventType for trx.
+// previousStatus is only meaningful for TypeTransactionStatusChanged and may otherwise be empty.
+func NewTransactionEvent(eventType Type, trx db.Transaction, previousStatus string, sensitive SensitiveData, correlationID string) (Envelope, error) {
+	schemaVersion, ok := schemaVersions[eventType]
+	if !ok {
+		return Envelope{}, fmt.Errorf("%w: %s", ErrUnknownEventType, eventType)
+	}
+
+	maskedSensitive, err := services.MaskData(sensitive)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("error encrypting sensitive event data: %w", err)
+	}
+
+	data, err := json.Marshal(TransactionData{
+		TransactionID:  trx.ID,
+		Type:           trx.Type,
+		Status:         trx.Status,
+		PreviousStatus: previousStatus,
+		Amount:         trx.Amount,
+		Currency:       trx.Currency,
+		GatewayName:    trx.GatewayName,
+		CountryName:    trx.CountryName,
+		Sensitive:      maskedSensitive,
+	})
+	if err != nil {
+		return Envelope{}, fmt.Errorf("error serializing event data: %w", err)
+	}
+
+	id, err := newEventID()
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		SpecVersion:     SpecVersion,
+		ID:              id,
+		Type:            eventType,
+		Source:          Source,
+		Subject:         fmt.Sprintf("transactions/%d", trx.ID),
+		Time:            time.Now().UTC(),
+		DataContentType: contentTypeJSON,
+		DataSchema:      DataSchema(eventType, schemaVersion),
+		SchemaVersion:   schemaVersion,
+		CorrelationID:   correlationID,
+		Data:            data,
+	}, nil
+}
+
+// DataSchema returns the URI identifying the schema of the data of eventType at version
+func DataSchema(eventType Type, version int) string {
+	return fmt.Sprintf("urn:payment-gateways:events:%s:v%d", eventType, version)
+}
+
+// Marshal serializes the envelope
+func (e Envelope) Marshal() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Unmarshal deserializes an envelope produced by Envelope.Marshal
+func Unmarshal(raw []byte) (Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return e, fmt.Errorf("error deserializing event envelope: %w", err)
+	}
+	if e.SpecVersion != SpecVersion {
+		return e, fmt.Errorf("unsupported event spec version: %q", e.SpecVersion)
+	}
+	return e, nil
+}
+
+// TransactionData decodes the data of a transaction event
+func (e Envelope) TransactionData() (TransactionData, error) {
+	var data TransactionData
+	if err := json.Unmarshal(e.Data, &data); err != nil {
+		return data, fmt.Errorf("error deserializing transaction event data: %w", err)
+	}
+	return data, nil
+}
+
+// UnmaskSensitive decrypts the sensitive fields of the transaction data
+func (d TransactionData) UnmaskSensitive() (SensitiveData, error) {
+	var sensitive SensitiveData
+	err := services.UnmaskData(string(d.Sensitive), &sensitive)
+	return sensitive, err
+}
+
+// ReencryptSensitive encrypts the sensitive fields of a serialized transaction event again with the primary key.
+// It reports false, returning payload unchanged, when they already were.
+func ReencryptSensitive(payload []byte) ([]byte, bool, error) {
+	event, err := Unmarshal(payload)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := event.TransactionData()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data.Sensitive) == 0 {
+		return payload, false, nil
+	}
+
+	sensitive, changed, err := services.Reencrypt(data.Sensitive)
+	if err != nil || !changed {
+		return payload, false, err
+	}
+	data.Sensitive = sensitive
+
+	if event.Data, err = json.Marshal(data); err != nil {
+		return nil, false, fmt.Errorf("error serializing transaction event data: %w", err)
+	}
+	reencrypted, err := event.Marshal()
+	if err != nil {
+		return nil, false, err
+	}
+	return reencrypted, true, nil
+}
+
+// newEventID generates a random (version 4) UUID
+func newEventID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("error generating event id: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
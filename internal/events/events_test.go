@@ -0,0 +1,138 @@
+package events
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewTransactionEvent_RoundTrip(t *testing.T) {
+	services.InitEncryptionKey("0123456789abcdef")
+
+	trx := db.Transaction{ID: 42, Amount: 100, Type: "withdrawal", Status: "success", Currency: "USD", UserID: 7}
+	sensitive := SensitiveData{UserID: 7, ReceivingAccount: "john.doe@example.com"}
+
+	event, err := NewTransactionEvent(TypeTransactionStatusChanged, trx, "pending", sensitive, "req-1")
+	require.NoError(t, err)
+
+	raw, err := event.Marshal()
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), sensitive.ReceivingAccount)
+
+	decoded, err := Unmarshal(raw)
+	require.NoError(t, err)
+	assert.Equal(t, TypeTransactionStatusChanged, decoded.Type)
+	assert.Equal(t, "transactions/42", decoded.Subject)
+	assert.Equal(t, 1, decoded.SchemaVersion)
+	assert.Equal(t, "req-1", decoded.CorrelationID)
+
+	data, err := decoded.TransactionData()
+	require.NoError(t, err)
+	assert.Equal(t, "pending", data.PreviousStatus)
+	assert.Equal(t, "success", data.Status)
+
+	unmasked, err := data.UnmaskSensitive()
+	require.NoError(t, err)
+	assert.Equal(t, sensitive, unmasked)
+}
+
+func TestNewTransactionEvent_UnknownType(t *testing.T) {
+	_, err := NewTransactionEvent("deposit.unknown", db.Transaction{}, "", SensitiveData{}, "")
+	assert.ErrorIs(t, err, ErrUnknownEventType)
+}
+
+func TestReencryptSensitive(t *testing.T) {
+	oldKey := services.Key{ID: services.LegacyKeyID, Material: []byte("QTLyhXOqRQNmgca4")}
+	newKey := services.Key{ID: "2024-06", Material: []byte("fedcba9876543210")}
+	sensitive := SensitiveData{UserID: 7, ReceivingAccount: "tok_123"}
+
+	payload := func(t *testing.T, key services.Key) []byte {
+		t.Helper()
+		keyring, err := services.NewKeyring(key.ID, key)
+		require.NoError(t, err)
+		services.SetKeyProvider(keyring)
+		event, err := NewTransactionEvent(TypeDepositInitiated, db.Transaction{ID: 42, Type: "deposit"}, "", sensitive, "")
+		require.NoError(t, err)
+		raw, err := event.Marshal()
+		require.NoError(t, err)
+		return raw
+	}
+	// events recorded before encryption keys were configurable, encrypted with the builtin key without header
+	builtin := payload(t, oldKey)
+	event, err := Unmarshal(builtin)
+	require.NoError(t, err)
+	data, err := event.TransactionData()
+	require.NoError(t, err)
+	data.Sensitive = sealLegacy(t, "W-Dm='U]Pu@xk]GM", sensitive)
+	event.Data, err = json.Marshal(data)
+	require.NoError(t, err)
+	builtin, err = event.Marshal()
+	require.NoError(t, err)
+
+	old := payload(t, oldKey)
+	current := payload(t, newKey)
+
+	keyring, err := services.NewKeyring(newKey.ID, newKey, oldKey)
+	require.NoError(t, err)
+	keyring, err = services.WithBuiltinKey(keyring)
+	require.NoError(t, err)
+	services.SetKeyProvider(keyring)
+
+	for name, c := range map[string]struct {
+		payload []byte
+		changed bool
+	}{
+		"builtin key": {builtin, true},
+		"old key":     {old, true},
+		"new key":     {current, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reencrypted, changed, err := ReencryptSensitive(c.payload)
+			require.NoError(t, err)
+			assert.Equal(t, c.changed, changed)
+
+			// only the sensitive part changes, and it is readable with the new key alone
+			before, err := Unmarshal(c.payload)
+			require.NoError(t, err)
+			after, err := Unmarshal(reencrypted)
+			require.NoError(t, err)
+			assert.Equal(t, before.ID, after.ID)
+
+			rotated, err := services.NewKeyring(newKey.ID, newKey)
+			require.NoError(t, err)
+			services.SetKeyProvider(rotated)
+			defer services.SetKeyProvider(keyring)
+
+			data, err := after.TransactionData()
+			require.NoError(t, err)
+			unmasked, err := data.UnmaskSensitive()
+			require.NoError(t, err)
+			assert.Equal(t, sensitive, unmasked)
+
+			_, changed, err = ReencryptSensitive(reencrypted)
+			require.NoError(t, err)
+			assert.False(t, changed)
+		})
+	}
+}
+
+// sealLegacy encrypts v with key as done before key IDs were introduced
+func sealLegacy(t *testing.T, key string, v any) []byte {
+	t.Helper()
+	block, err := aes.NewCipher([]byte(key))
+	require.NoError(t, err)
+	aesGCM, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	nonce := make([]byte, aesGCM.NonceSize())
+	_, err = rand.Read(nonce)
+	require.NoError(t, err)
+	raw, err := json.Marshal(v)
+	require.NoError(t, err)
+	return aesGCM.Seal(nonce, nonce, raw, nil)
+}
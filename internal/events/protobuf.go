@@ -0,0 +1,238 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufSchema describes the messages written by protobufCodec.
+// Field numbers must never be reused for a different type; see checkProtobufCompatibility.
+const protobufSchema = `syntax = "proto3";
+
+package payment_gateways.events.v1;
+
+message TransactionData {
+  int64 transaction_id = 1;
+  string type = 2;
+  string status = 3;
+  string previous_status = 4;
+  double amount = 5;
+  string currency = 6;
+  string gateway_name = 7;
+  string country_name = 8;
+  bytes sensitive = 9;
+}
+
+message Envelope {
+  string specversion = 1;
+  string id = 2;
+  string type = 3;
+  string source = 4;
+  string subject = 5;
+  int64 time_unix_nano = 6;
+  string datacontenttype = 7;
+  string dataschema = 8;
+  int32 schemaversion = 9;
+  string correlationid = 10;
+  TransactionData data = 11;
+}
+`
+
+// protobufCodec serializes envelopes as the Envelope message of protobufSchema.
+// Messages are encoded directly on the protobuf wire format, so no generated code is needed.
+type protobufCodec struct {
+	schemaID int
+	registry SchemaRegistry
+}
+
+func (c *protobufCodec) Name() string        { return CodecProtobuf }
+func (c *protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (c *protobufCodec) Encode(e Envelope) ([]byte, error) {
+	data, err := e.TransactionData()
+	if err != nil {
+		return nil, err
+	}
+
+	var d []byte
+	d = appendVarintField(d, 1, uint64(data.TransactionID))
+	d = appendStringField(d, 2, data.Type)
+	d = appendStringField(d, 3, data.Status)
+	d = appendStringField(d, 4, data.PreviousStatus)
+	if data.Amount != 0 {
+		d = protowire.AppendTag(d, 5, protowire.Fixed64Type)
+		d = protowire.AppendFixed64(d, math.Float64bits(data.Amount))
+	}
+	d = appendStringField(d, 6, data.Currency)
+	d = appendStringField(d, 7, data.GatewayName)
+	d = appendStringField(d, 8, data.CountryName)
+	d = appendBytesField(d, 9, data.Sensitive)
+
+	var b []byte
+	b = appendStringField(b, 1, e.SpecVersion)
+	b = appendStringField(b, 2, e.ID)
+	b = appendStringField(b, 3, string(e.Type))
+	b = appendStringField(b, 4, e.Source)
+	b = appendStringField(b, 5, e.Subject)
+	b = appendVarintField(b, 6, uint64(e.Time.UnixNano()))
+	b = appendStringField(b, 7, e.DataContentType)
+	b = appendStringField(b, 8, e.DataSchema)
+	b = appendVarintField(b, 9, uint64(e.SchemaVersion))
+	b = appendStringField(b, 10, e.CorrelationID)
+	b = protowire.AppendTag(b, 11, protowire.BytesType)
+	b = protowire.AppendBytes(b, d)
+
+	return frame(c.schemaID, b), nil
+}
+
+func (c *protobufCodec) Decode(raw []byte) (Envelope, error) {
+	var e Envelope
+
+	schemaID, payload, err := unframe(raw)
+	if err != nil {
+		return e, err
+	}
+	if schema, err := c.registry.SchemaByID(schemaID); err != nil || schema.Type != SchemaTypeProtobuf {
+		return e, fmt.Errorf("message written with unknown protobuf schema %d", schemaID)
+	}
+
+	var data TransactionData
+	err = consumeFields(payload, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 6 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.Time = time.Unix(0, int64(v)).UTC()
+			return n, nil
+		case num == 9 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.SchemaVersion = int(v)
+			return n, nil
+		case num == 11 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			return n, decodeProtobufTransactionData(v, &data)
+		case typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			switch num {
+			case 1:
+				e.SpecVersion = v
+			case 2:
+				e.ID = v
+			case 3:
+				e.Type = Type(v)
+			case 4:
+				e.Source = v
+			case 5:
+				e.Subject = v
+			case 7:
+				e.DataContentType = v
+			case 8:
+				e.DataSchema = v
+			case 10:
+				e.CorrelationID = v
+			}
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+	if err != nil {
+		return e, fmt.Errorf("error decoding protobuf envelope: %w", err)
+	}
+
+	if e.Data, err = json.Marshal(data); err != nil {
+		return e, fmt.Errorf("error serializing event data: %w", err)
+	}
+	return e, nil
+}
+
+func decodeProtobufTransactionData(payload []byte, data *TransactionData) error {
+	return consumeFields(payload, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			data.TransactionID = int(int64(v))
+			return n, nil
+		case num == 5 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			data.Amount = math.Float64frombits(v)
+			return n, nil
+		case num == 9 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			data.Sensitive = append([]byte(nil), v...)
+			return n, nil
+		case typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			switch num {
+			case 2:
+				data.Type = v
+			case 3:
+				data.Status = v
+			case 4:
+				data.PreviousStatus = v
+			case 6:
+				data.Currency = v
+			case 7:
+				data.GatewayName = v
+			case 8:
+				data.CountryName = v
+			}
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+}
+
+// consumeFields calls consume with the value of every field in b.
+// consume returns the number of bytes of the value it consumed, or a negative protowire error code.
+func consumeFields(b []byte, consume func(num protowire.Number, typ protowire.Type, value []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n, err := consume(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// appendStringField appends a string field, omitting it when empty as proto3 does for default values
+func appendStringField(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendBytesField(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
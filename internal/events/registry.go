@@ -0,0 +1,214 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hamba/avro/v2"
+)
+
+// SchemaType is the serialization format a Schema describes
+type SchemaType string
+
+const (
+	SchemaTypeAvro     SchemaType = "AVRO"
+	SchemaTypeProtobuf SchemaType = "PROTOBUF"
+)
+
+var (
+	ErrSchemaNotFound     = errors.New("schema not found")
+	ErrIncompatibleSchema = errors.New("schema is incompatible with the latest registered version")
+)
+
+// Schema is a version of a schema registered under a subject
+type Schema struct {
+	ID         int        `json:"id"`
+	Subject    string     `json:"subject"`
+	Version    int        `json:"version"`
+	Type       SchemaType `json:"schema_type"`
+	Definition string     `json:"schema"`
+}
+
+// SchemaRegistry is a client of a schema registry
+type SchemaRegistry interface {
+
+	// Register registers definition as the latest version of subject and returns it.
+	// If definition is already the latest version, the existing Schema is returned.
+	// ErrIncompatibleSchema is returned if definition is not backward compatible with the latest version,
+	// i.e. it cannot be used to read data written with the latest version.
+	Register(subject string, schemaType SchemaType, definition string) (Schema, error)
+
+	// SchemaByID returns the schema registered with id
+	SchemaByID(id int) (Schema, error)
+
+	// LatestSchema returns the latest version registered under subject
+	LatestSchema(subject string) (Schema, error)
+}
+
+// FileRegistry is a SchemaRegistry persisted to a local JSON file.
+// It is intended for local development and tests in place of a remote schema registry.
+type FileRegistry struct {
+	mu      sync.RWMutex
+	path    string
+	schemas []Schema
+}
+
+// NewFileRegistry loads the registry stored at path, creating an empty registry if the file does not exist
+func NewFileRegistry(path string) (*FileRegistry, error) {
+	registry := &FileRegistry{path: path}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return registry, nil
+		}
+		return nil, fmt.Errorf("could not read schema registry file: %w", err)
+	}
+	if err = json.Unmarshal(raw, &registry.schemas); err != nil {
+		return nil, fmt.Errorf("could not parse schema registry file: %w", err)
+	}
+	return registry, nil
+}
+
+func (f *FileRegistry) Register(subject string, schemaType SchemaType, definition string) (Schema, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	version := 1
+	if latest, ok := f.latest(subject); ok {
+		if latest.Type == schemaType && latest.Definition == definition {
+			return latest, nil
+		}
+		if latest.Type != schemaType {
+			return Schema{}, fmt.Errorf("%w: subject %s holds %s schemas", ErrIncompatibleSchema, subject, latest.Type)
+		}
+		if err := checkCompatibility(schemaType, definition, latest.Definition); err != nil {
+			return Schema{}, fmt.Errorf("%w: %s", ErrIncompatibleSchema, err.Error())
+		}
+		version = latest.Version + 1
+	}
+
+	schema := Schema{
+		ID:         len(f.schemas) + 1,
+		Subject:    subject,
+		Version:    version,
+		Type:       schemaType,
+		Definition: definition,
+	}
+	f.schemas = append(f.schemas, schema)
+	if err := f.save(); err != nil {
+		f.schemas = f.schemas[:len(f.schemas)-1]
+		return Schema{}, err
+	}
+	return schema, nil
+}
+
+func (f *FileRegistry) SchemaByID(id int) (Schema, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, schema := range f.schemas {
+		if schema.ID == id {
+			return schema, nil
+		}
+	}
+	return Schema{}, fmt.Errorf("%w: id %d", ErrSchemaNotFound, id)
+}
+
+func (f *FileRegistry) LatestSchema(subject string) (Schema, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if schema, ok := f.latest(subject); ok {
+		return schema, nil
+	}
+	return Schema{}, fmt.Errorf("%w: subject %s", ErrSchemaNotFound, subject)
+}
+
+func (f *FileRegistry) latest(subject string) (Schema, bool) {
+	var latest Schema
+	found := false
+	for _, schema := range f.schemas {
+		if schema.Subject == subject && schema.Version > latest.Version {
+			latest = schema
+			found = true
+		}
+	}
+	return latest, found
+}
+
+func (f *FileRegistry) save() error {
+	raw, err := json.MarshalIndent(f.schemas, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing schema registry: %w", err)
+	}
+	if err = os.WriteFile(f.path, raw, 0644); err != nil {
+		return fmt.Errorf("could not write schema registry file: %w", err)
+	}
+	return nil
+}
+
+// checkCompatibility checks that data written with writer can be read with reader
+func checkCompatibility(schemaType SchemaType, reader, writer string) error {
+	switch schemaType {
+	case SchemaTypeAvro:
+		readerSchema, err := avro.Parse(reader)
+		if err != nil {
+			return fmt.Errorf("invalid avro schema: %w", err)
+		}
+		writerSchema, err := avro.Parse(writer)
+		if err != nil {
+			return fmt.Errorf("invalid avro schema: %w", err)
+		}
+		return avro.NewSchemaCompatibility().Compatible(readerSchema, writerSchema)
+	case SchemaTypeProtobuf:
+		return checkProtobufCompatibility(reader, writer)
+	default:
+		return fmt.Errorf("unsupported schema type: %s", schemaType)
+	}
+}
+
+var (
+	protoMessagePattern = regexp.MustCompile(`^message\s+(\w+)\s*\{`)
+	protoFieldPattern   = regexp.MustCompile(`^(?:repeated\s+|optional\s+)?([\w.]+)\s+(\w+)\s*=\s*(\d+)\s*;`)
+)
+
+// checkProtobufCompatibility rejects reader if it changes the type of a field number declared in writer.
+// Adding and removing fields is always compatible on the protobuf wire format.
+func checkProtobufCompatibility(reader, writer string) error {
+	readerFields := protoFieldTypes(reader)
+	for key, writerType := range protoFieldTypes(writer) {
+		if readerType, ok := readerFields[key]; ok && readerType != writerType {
+			return fmt.Errorf("field %s changed type from %s to %s", key, writerType, readerType)
+		}
+	}
+	return nil
+}
+
+// protoFieldTypes maps "<message>.<field number>" to the declared type of every field in a (non-nested) proto definition
+func protoFieldTypes(definition string) map[string]string {
+	fields := make(map[string]string)
+	message := ""
+	for _, line := range strings.Split(definition, "\n") {
+		line = strings.TrimSpace(line)
+		if match := protoMessagePattern.FindStringSubmatch(line); match != nil {
+			message = match[1]
+			continue
+		}
+		if line == "}" {
+			message = ""
+			continue
+		}
+		if match := protoFieldPattern.FindStringSubmatch(line); match != nil && message != "" {
+			number, _ := strconv.Atoi(match[3])
+			fields[fmt.Sprintf("%s.%d", message, number)] = match[1]
+		}
+	}
+	return fields
+}
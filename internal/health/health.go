@@ -0,0 +1,196 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Dependency is a service the API needs to serve requests
+type Dependency struct {
+	Name string
+
+	// Check returns an error when the dependency is unavailable
+	Check func(ctx context.Context) error
+
+	// Timeout bounds Check, one second when zero
+	Timeout time.Duration
+}
+
+// Status describes the last check of a dependency
+type Status struct {
+	Name      string    `json:"name"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMS float64   `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+
+	// LastError is the most recent failure, kept after the dependency has recovered
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// resultsTTL is how long the handlers reuse the results of a check, so that frequent probes do not each reach
+// every dependency
+const resultsTTL = 5 * time.Second
+
+// Checker checks the dependencies of the API
+type Checker struct {
+	dependencies []Dependency
+	started      time.Time
+
+	// mu guards lastErrors
+	mu         sync.Mutex
+	lastErrors map[string]lastError
+
+	// resultsMu guards results and checkedAt, and is held during a check so that concurrent probes wait for it
+	resultsMu  sync.Mutex
+	resultsTTL time.Duration
+	results    []Status
+	checkedAt  time.Time
+}
+
+type lastError struct {
+	message string
+	at      time.Time
+}
+
+func NewChecker(dependencies ...Dependency) *Checker {
+	for i := range dependencies {
+		if dependencies[i].Timeout <= 0 {
+			dependencies[i].Timeout = time.Second
+		}
+	}
+	return &Checker{
+		dependencies: dependencies,
+		started:      time.Now(),
+		lastErrors:   make(map[string]lastError),
+		resultsTTL:   resultsTTL,
+	}
+}
+
+// cachedCheck returns the results of the last check when they are recent enough, and checks the dependencies otherwise
+func (c *Checker) cachedCheck(ctx context.Context) []Status {
+	c.resultsMu.Lock()
+	defer c.resultsMu.Unlock()
+	if c.results != nil && time.Since(c.checkedAt) < c.resultsTTL {
+		return c.results
+	}
+
+	// the results are shared with other probes, so they must not fail because this one went away
+	c.results = c.Check(context.WithoutCancel(ctx))
+	c.checkedAt = time.Now()
+	return c.results
+}
+
+// Check checks every dependency concurrently, each within its timeout
+func (c *Checker) Check(ctx context.Context) []Status {
+	statuses := make([]Status, len(c.dependencies))
+	var wg sync.WaitGroup
+	for i, dependency := range c.dependencies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = c.check(ctx, dependency)
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+func (c *Checker) check(ctx context.Context, dependency Dependency) Status {
+	ctx, cancel := context.WithTimeout(ctx, dependency.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	result := make(chan error, 1)
+	go func() {
+		result <- dependency.Check(ctx)
+	}()
+
+	// checks ignoring ctx still time out
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = errors.New("timed out after " + dependency.Timeout.String())
+	}
+
+	status := Status{
+		Name:      dependency.Name,
+		Healthy:   err == nil,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: start,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		status.Error = err.Error()
+		c.lastErrors[dependency.Name] = lastError{message: err.Error(), at: start}
+	}
+	if last, ok := c.lastErrors[dependency.Name]; ok {
+		status.LastError = last.message
+		status.LastErrorAt = &last.at
+	}
+	return status
+}
+
+// LivenessHandler responds 200 as long as the process is able to serve requests, without checking dependencies,
+// so that an orchestrator does not restart instances because a dependency is down
+func LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// ReadinessHandler responds 200 when every dependency is available, and 503 otherwise.
+// The results of a check are reused for a few seconds.
+func (c *Checker) ReadinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		failing := make(map[string]string)
+		for _, status := range c.cachedCheck(r.Context()) {
+			if !status.Healthy {
+				failing[status.Name] = status.Error
+			}
+		}
+		if len(failing) > 0 {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable", "failing": failing})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// StatusHandler responds with the latency and errors of each dependency, with a 503 status when one is unavailable.
+// The errors are those of the drivers, so it is meant to be served apart from the public API.
+// The results of a check are reused for a few seconds.
+func (c *Checker) StatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses := c.cachedCheck(r.Context())
+		code, overall := http.StatusOK, "ok"
+		for _, status := range statuses {
+			if !status.Healthy {
+				code, overall = http.StatusServiceUnavailable, "unavailable"
+			}
+		}
+		writeJSON(w, code, map[string]any{
+			"status":         overall,
+			"uptime_seconds": int64(time.Since(c.started).Seconds()),
+			"dependencies":   statuses,
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,96 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker(t *testing.T) {
+	var redisDown atomic.Bool
+	redisDown.Store(true)
+
+	checker := NewChecker(
+		Dependency{Name: "postgres", Check: func(ctx context.Context) error { return nil }},
+		Dependency{Name: "redis", Check: func(ctx context.Context) error {
+			if redisDown.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		}},
+
+		// a check ignoring its context still times out
+		Dependency{Name: "kafka", Timeout: 20 * time.Millisecond, Check: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+	)
+
+	statuses := checker.Check(context.Background())
+	require.Len(t, statuses, 3)
+	assert.True(t, statuses[0].Healthy)
+	assert.Empty(t, statuses[0].LastError)
+	assert.False(t, statuses[1].Healthy)
+	assert.Equal(t, "connection refused", statuses[1].Error)
+	assert.False(t, statuses[2].Healthy)
+	assert.Equal(t, "timed out after 20ms", statuses[2].Error)
+	assert.Less(t, statuses[2].LatencyMS, 500.0)
+
+	// the last error is kept once the dependency recovers
+	redisDown.Store(false)
+	statuses = checker.Check(context.Background())
+	assert.True(t, statuses[1].Healthy)
+	assert.Empty(t, statuses[1].Error)
+	assert.Equal(t, "connection refused", statuses[1].LastError)
+	assert.NotNil(t, statuses[1].LastErrorAt)
+}
+
+func TestHandlers(t *testing.T) {
+	var down atomic.Bool
+	var checks atomic.Int32
+	checker := NewChecker(Dependency{Name: "postgres", Check: func(ctx context.Context) error {
+		checks.Add(1)
+		if down.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}})
+
+	serve := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, serve(checker.ReadinessHandler()).Code)
+
+	// the results are reused for a few seconds
+	down.Store(true)
+	assert.Equal(t, http.StatusOK, serve(checker.ReadinessHandler()).Code)
+	assert.Equal(t, http.StatusOK, serve(checker.StatusHandler()).Code)
+	assert.Equal(t, int32(1), checks.Load())
+
+	checker.resultsTTL = 0
+	assert.Equal(t, http.StatusOK, serve(LivenessHandler()).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(checker.ReadinessHandler()).Code)
+
+	rr := serve(checker.StatusHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	var body struct {
+		Status       string   `json:"status"`
+		Dependencies []Status `json:"dependencies"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+	assert.Equal(t, "unavailable", body.Status)
+	require.Len(t, body.Dependencies, 1)
+	assert.Equal(t, "postgres", body.Dependencies[0].Name)
+	assert.Equal(t, "connection refused", body.Dependencies[0].Error)
+}
@@ -0,0 +1,291 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ercross/payment_gateways/internal/events"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Headers set on messages forwarded to retry and dead-letter topics
+const (
+	headerOriginalTopic = "x-original-topic"
+	headerAttempt       = "x-attempt"
+	headerNotBefore     = "x-not-before"
+	headerError         = "x-error"
+)
+
+// Handler handles a transaction event. Returning an error sends the event to the next retry topic,
+// or to the dead-letter topic once retries are exhausted.
+type Handler func(ctx context.Context, event events.Envelope) error
+
+// ConsumerConfig configures a Consumer
+type ConsumerConfig struct {
+	Brokers []string
+
+	// GroupID is the consumer group; retry and dead-letter topics are scoped to it
+	// so that several groups can consume the same topic independently
+	GroupID string
+
+	// Topic is the topic consumed and the codec its events are serialized with
+	Topic TopicConfig
+
+	// RetryDelays configures one retry topic per entry. A failed event is redelivered from
+	// the n-th retry topic no earlier than RetryDelays[n] after it failed.
+	RetryDelays []time.Duration
+}
+
+// messageReader is the subset of *kafka.Reader a Consumer reads a topic with
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// messageWriter is the subset of *kafka.Writer a Consumer forwards messages with
+type messageWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// Consumer consumes transaction events with at-least-once semantics: offsets are committed explicitly,
+// only once an event has been handled or forwarded to a retry or dead-letter topic.
+type Consumer struct {
+	config    ConsumerConfig
+	codec     events.Codec
+	log       *logger.Logger
+	writer    messageWriter
+	newReader func(topic string) messageReader
+	handlers  map[events.Type]Handler
+}
+
+func NewConsumer(config ConsumerConfig, registry events.SchemaRegistry, log *logger.Logger) (*Consumer, error) {
+	codec, err := events.NewCodec(config.Topic.Codec, registry, config.Topic.Name+"-value")
+	if err != nil {
+		return nil, fmt.Errorf("error configuring topic %s: %w", config.Topic.Name, err)
+	}
+
+	return &Consumer{
+		config: config,
+		codec:  codec,
+		log:    log,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(config.Brokers...),
+			Balancer:               &kafka.Hash{},
+			AllowAutoTopicCreation: true,
+			BatchTimeout:           10 * time.Millisecond,
+		},
+		newReader: func(topic string) messageReader {
+			return kafka.NewReader(kafka.ReaderConfig{
+				Brokers: config.Brokers,
+				GroupID: config.GroupID,
+				Topic:   topic,
+			})
+		},
+		handlers: make(map[events.Type]Handler),
+	}, nil
+}
+
+// Handle registers handler for events of eventType. Events without a registered handler are skipped.
+// Handle must not be called once Run has been called.
+func (c *Consumer) Handle(eventType events.Type, handler Handler) {
+	c.handlers[eventType] = handler
+}
+
+// RetryTopic returns the name of the n-th (zero based) retry topic
+func (c *Consumer) RetryTopic(n int) string {
+	return fmt.Sprintf("%s.%s.retry.%d", c.config.Topic.Name, c.config.GroupID, n+1)
+}
+
+// DeadLetterTopic returns the name of the topic events are sent to once retries are exhausted
+func (c *Consumer) DeadLetterTopic() string {
+	return fmt.Sprintf("%s.%s.dlt", c.config.Topic.Name, c.config.GroupID)
+}
+
+// Run consumes the topic and its retry topics until ctx is cancelled.
+// On cancellation, events being handled are allowed to complete and be committed before Run returns.
+func (c *Consumer) Run(ctx context.Context) error {
+	topics := []string{c.config.Topic.Name}
+	for i := range c.config.RetryDelays {
+		topics = append(topics, c.RetryTopic(i))
+	}
+
+	// a failing topic stops the whole consumer, so that its uncommitted messages are redelivered on restart
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(topics))
+	for stage, topic := range topics {
+		reader := c.newReader(topic)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer reader.Close()
+			if err := c.consume(ctx, reader, stage); err != nil {
+				errs <- fmt.Errorf("error consuming %s: %w", topic, err)
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	var err error
+	for consumeErr := range errs {
+		err = errors.Join(err, consumeErr)
+	}
+	return errors.Join(err, c.writer.Close())
+}
+
+// consume reads messages of the topic at retry stage (0 being the original topic) until ctx is cancelled
+func (c *Consumer) consume(ctx context.Context, reader messageReader, stage int) error {
+	for {
+		message, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if stage > 0 {
+			if err = waitUntil(ctx, notBefore(message)); err != nil {
+				// shutting down; the uncommitted message is redelivered on restart
+				return nil
+			}
+		}
+
+		// let the message be handled and committed even if shutdown starts meanwhile
+		handleCtx := context.WithoutCancel(ctx)
+		if err = c.process(handleCtx, message, stage); err != nil {
+			return err
+		}
+		if err = reader.CommitMessages(handleCtx, message); err != nil {
+			return fmt.Errorf("error committing offset %d: %w", message.Offset, err)
+		}
+	}
+}
+
+// process handles message, forwarding it to the next retry topic or the dead-letter topic on failure.
+// Handlers run within a span continuing the trace the message was published in.
+func (c *Consumer) process(ctx context.Context, message kafka.Message, stage int) (err error) {
+	ctx = tracing.Extract(ctx, headerCarrier{&message.Headers})
+	ctx, span := tracing.Start(ctx, "kafka.Process", trace.SpanKindConsumer, semconv.MessagingSystemKafka,
+		semconv.MessagingOperationTypeDeliver, semconv.MessagingDestinationName(message.Topic))
+	defer func() { tracing.End(span, err) }()
+
+	event, err := c.codec.Decode(message.Value)
+	if err != nil {
+		// retrying would not make an undecodable message decodable
+		return c.forward(ctx, message, c.DeadLetterTopic(), stage, 0, err)
+	}
+
+	handler, ok := c.handlers[event.Type]
+	if !ok {
+		c.log.Debug("skipping event without handler", logger.ComponentKafka,
+			logger.NewField("Event-Type", event.Type), logger.NewField("Event-ID", event.ID))
+		return nil
+	}
+
+	handleErr := handler(ctx, event)
+	if handleErr == nil {
+		return nil
+	}
+
+	c.log.Warn("error handling event", logger.ComponentKafka, logger.NewField("Error", handleErr.Error()),
+		logger.NewField("Event-Type", event.Type), logger.NewField("Event-ID", event.ID), logger.NewField("Attempt", stage+1))
+
+	if stage < len(c.config.RetryDelays) {
+		return c.forward(ctx, message, c.RetryTopic(stage), stage+1, c.config.RetryDelays[stage], handleErr)
+	}
+	return c.forward(ctx, message, c.DeadLetterTopic(), stage+1, 0, handleErr)
+}
+
+// forward writes message to topic, recording why it failed and when it may be retried.
+// The writer retries failed writes itself; an error means the message must not be committed.
+func (c *Consumer) forward(ctx context.Context, message kafka.Message, topic string, attempt int, delay time.Duration, cause error) error {
+	originalTopic := message.Topic
+	if value := header(message, headerOriginalTopic); value != "" {
+		originalTopic = value
+	}
+
+	forwarded := kafka.Message{
+		Topic: topic,
+		Key:   message.Key,
+		Value: message.Value,
+		Headers: append(withoutHeaders(message.Headers, headerOriginalTopic, headerAttempt, headerNotBefore, headerError),
+			kafka.Header{Key: headerOriginalTopic, Value: []byte(originalTopic)},
+			kafka.Header{Key: headerAttempt, Value: []byte(strconv.Itoa(attempt))},
+			kafka.Header{Key: headerNotBefore, Value: []byte(time.Now().Add(delay).UTC().Format(time.RFC3339Nano))},
+			kafka.Header{Key: headerError, Value: []byte(cause.Error())},
+		),
+	}
+
+	if err := c.writer.WriteMessages(ctx, forwarded); err != nil {
+		return fmt.Errorf("error forwarding message to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// notBefore returns the earliest time a message forwarded to a retry topic may be handled
+func notBefore(message kafka.Message) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, header(message, headerNotBefore))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func waitUntil(ctx context.Context, t time.Time) error {
+	delay := time.Until(t)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func header(message kafka.Message, key string) string {
+	for _, h := range message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func withoutHeaders(headers []kafka.Header, keys ...string) []kafka.Header {
+	filtered := make([]kafka.Header, 0, len(headers)+len(keys))
+	for _, h := range headers {
+		excluded := false
+		for _, key := range keys {
+			if h.Key == key {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered
+}
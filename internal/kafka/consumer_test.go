@@ -0,0 +1,262 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/events"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReader delivers messages, then cancels the consumer once they have all been fetched
+type fakeReader struct {
+	messages  []kafka.Message
+	cancel    context.CancelFunc
+	committed []kafka.Message
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	if len(r.messages) == 0 {
+		r.cancel()
+		<-ctx.Done()
+		return kafka.Message{}, ctx.Err()
+	}
+	message := r.messages[0]
+	r.messages = r.messages[1:]
+	return message, nil
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, messages ...kafka.Message) error {
+	r.committed = append(r.committed, messages...)
+	return nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+// fakeWriter records the messages it writes, failing while err is set
+type fakeWriter struct {
+	written []kafka.Message
+	err     error
+}
+
+func (w *fakeWriter) WriteMessages(_ context.Context, messages ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.written = append(w.written, messages...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error { return nil }
+
+const consumedTopic = "transactions.json"
+
+func newTestConsumer(t *testing.T, retryDelays ...time.Duration) (*Consumer, *fakeWriter) {
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+
+	consumer, err := NewConsumer(ConsumerConfig{
+		GroupID:     "ledger",
+		Topic:       TopicConfig{Name: consumedTopic, Codec: events.CodecJSON},
+		RetryDelays: retryDelays,
+	}, nil, log)
+	require.NoError(t, err)
+
+	writer := &fakeWriter{}
+	consumer.writer = writer
+	return consumer, writer
+}
+
+// eventMessage returns a deposit.initiated event as published on topic
+func eventMessage(t *testing.T, consumer *Consumer, topic string, headers ...kafka.Header) kafka.Message {
+	t.Helper()
+	services.InitEncryptionKey("0123456789abcdef")
+
+	event, err := events.NewTransactionEvent(events.TypeDepositInitiated, db.Transaction{ID: 7, Type: "deposit"},
+		"", events.SensitiveData{UserID: 1}, "")
+	require.NoError(t, err)
+	value, err := consumer.codec.Encode(event)
+	require.NoError(t, err)
+	return kafka.Message{Topic: topic, Key: []byte("7"), Value: value, Headers: headers}
+}
+
+// consume runs the consumer at stage over messages until they have all been fetched
+func consume(t *testing.T, consumer *Consumer, stage int, messages ...kafka.Message) (*fakeReader, error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader := &fakeReader{messages: messages, cancel: cancel}
+	return reader, consumer.consume(ctx, reader, stage)
+}
+
+func headerValues(message kafka.Message, key string) []string {
+	var values []string
+	for _, h := range message.Headers {
+		if h.Key == key {
+			values = append(values, string(h.Value))
+		}
+	}
+	return values
+}
+
+func TestConsumer_Handled(t *testing.T) {
+	consumer, writer := newTestConsumer(t, time.Minute)
+	handled := 0
+	consumer.Handle(events.TypeDepositInitiated, func(_ context.Context, event events.Envelope) error {
+		handled++
+		assert.Equal(t, "transactions/7", event.Subject)
+		return nil
+	})
+
+	reader, err := consume(t, consumer, 0, eventMessage(t, consumer, consumedTopic))
+	require.NoError(t, err)
+	assert.Equal(t, 1, handled)
+	assert.Len(t, reader.committed, 1)
+	assert.Empty(t, writer.written)
+}
+
+func TestConsumer_RetryThenDeadLetter(t *testing.T) {
+	delays := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}
+	consumer, writer := newTestConsumer(t, delays...)
+	attempts := 0
+	consumer.Handle(events.TypeDepositInitiated, func(context.Context, events.Envelope) error {
+		attempts++
+		return errors.New("ledger unavailable " + strconv.Itoa(attempts))
+	})
+
+	// each failure forwards the message to the next stage, then to the dead-letter topic
+	message := eventMessage(t, consumer, consumedTopic, kafka.Header{Key: "ce_type", Value: []byte(events.TypeDepositInitiated)})
+	wantTopics := []string{consumer.RetryTopic(0), consumer.RetryTopic(1), consumer.DeadLetterTopic()}
+	for stage, wantTopic := range wantTopics {
+		failedAt := time.Now()
+		reader, err := consume(t, consumer, stage, message)
+		require.NoError(t, err)
+		require.Len(t, writer.written, stage+1)
+		require.Len(t, reader.committed, 1, "the message is committed once forwarded")
+
+		forwarded := writer.written[stage]
+		assert.Equal(t, wantTopic, forwarded.Topic)
+		assert.Equal(t, message.Key, forwarded.Key)
+		assert.Equal(t, message.Value, forwarded.Value)
+		assert.Equal(t, []string{consumedTopic}, headerValues(forwarded, headerOriginalTopic))
+		assert.Equal(t, []string{strconv.Itoa(stage + 1)}, headerValues(forwarded, headerAttempt))
+		assert.Equal(t, []string{"ledger unavailable " + strconv.Itoa(stage+1)}, headerValues(forwarded, headerError))
+
+		if stage < len(delays) {
+			notBefore := notBefore(forwarded)
+			assert.WithinDuration(t, failedAt.Add(delays[stage]), notBefore, time.Second)
+		}
+
+		// the retry topic delivers the forwarded message
+		message = forwarded
+	}
+	assert.Equal(t, 3, attempts)
+}
+
+func TestConsumer_ForwardedHeaders(t *testing.T) {
+	consumer, writer := newTestConsumer(t, time.Millisecond, time.Millisecond)
+	consumer.Handle(events.TypeDepositInitiated, func(context.Context, events.Envelope) error {
+		return errors.New("ledger unavailable")
+	})
+
+	// a message from a retry topic carries the headers of its previous failure
+	message := eventMessage(t, consumer, consumer.RetryTopic(0),
+		kafka.Header{Key: "ce_id", Value: []byte("event-1")},
+		kafka.Header{Key: "traceparent", Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+		kafka.Header{Key: headerOriginalTopic, Value: []byte(consumedTopic)},
+		kafka.Header{Key: headerAttempt, Value: []byte("1")},
+		kafka.Header{Key: headerNotBefore, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+		kafka.Header{Key: headerError, Value: []byte("previous failure")},
+	)
+	_, err := consume(t, consumer, 1, message)
+	require.NoError(t, err)
+	require.Len(t, writer.written, 1)
+
+	// headers of other parties are forwarded, retry headers are replaced rather than repeated
+	forwarded := writer.written[0]
+	assert.Equal(t, []string{"event-1"}, headerValues(forwarded, "ce_id"))
+	assert.Len(t, headerValues(forwarded, "traceparent"), 1)
+	assert.Equal(t, []string{consumedTopic}, headerValues(forwarded, headerOriginalTopic))
+	assert.Equal(t, []string{"2"}, headerValues(forwarded, headerAttempt))
+	assert.Equal(t, []string{"ledger unavailable"}, headerValues(forwarded, headerError))
+	assert.Len(t, headerValues(forwarded, headerNotBefore), 1)
+}
+
+func TestConsumer_UndecodableGoesToDeadLetter(t *testing.T) {
+	consumer, writer := newTestConsumer(t, time.Minute)
+	consumer.Handle(events.TypeDepositInitiated, func(context.Context, events.Envelope) error {
+		t.Error("undecodable messages must not be handled")
+		return nil
+	})
+
+	reader, err := consume(t, consumer, 0, kafka.Message{Topic: consumedTopic, Value: []byte("not an event")})
+	require.NoError(t, err)
+	require.Len(t, writer.written, 1)
+	assert.Equal(t, consumer.DeadLetterTopic(), writer.written[0].Topic, "retrying would not make it decodable")
+	assert.Len(t, reader.committed, 1)
+}
+
+func TestConsumer_NotBefore(t *testing.T) {
+	consumer, _ := newTestConsumer(t, time.Minute)
+	var handledAt time.Time
+	consumer.Handle(events.TypeDepositInitiated, func(context.Context, events.Envelope) error {
+		handledAt = time.Now()
+		return nil
+	})
+
+	retryAt := time.Now().Add(50 * time.Millisecond)
+	message := eventMessage(t, consumer, consumer.RetryTopic(0),
+		kafka.Header{Key: headerNotBefore, Value: []byte(retryAt.UTC().Format(time.RFC3339Nano))})
+	reader, err := consume(t, consumer, 1, message)
+	require.NoError(t, err)
+	assert.False(t, handledAt.Before(retryAt), "the message is handled no earlier than its retry time")
+	assert.Len(t, reader.committed, 1)
+}
+
+func TestConsumer_NotBeforeShutdown(t *testing.T) {
+	consumer, _ := newTestConsumer(t, time.Hour)
+	consumer.Handle(events.TypeDepositInitiated, func(context.Context, events.Envelope) error {
+		t.Error("the message must not be handled before its retry time")
+		return nil
+	})
+
+	message := eventMessage(t, consumer, consumer.RetryTopic(0),
+		kafka.Header{Key: headerNotBefore, Value: []byte(time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano))})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	reader := &fakeReader{messages: []kafka.Message{message}, cancel: cancel}
+
+	// shutting down while waiting leaves the message uncommitted, to be redelivered on restart
+	require.NoError(t, consumer.consume(ctx, reader, 1))
+	assert.Empty(t, reader.committed)
+}
+
+func TestConsumer_CommitsOnlyAfterForward(t *testing.T) {
+	consumer, writer := newTestConsumer(t, time.Minute)
+	writer.err = errors.New("broker unavailable")
+	consumer.Handle(events.TypeDepositInitiated, func(context.Context, events.Envelope) error {
+		return errors.New("ledger unavailable")
+	})
+
+	reader, err := consume(t, consumer, 0, eventMessage(t, consumer, consumedTopic))
+	assert.ErrorContains(t, err, "error forwarding message to "+consumer.RetryTopic(0))
+	assert.Empty(t, reader.committed, "a message that could not be forwarded is redelivered")
+}
+
+func TestConsumer_SkipsEventsWithoutHandler(t *testing.T) {
+	consumer, writer := newTestConsumer(t, time.Minute)
+
+	reader, err := consume(t, consumer, 0, eventMessage(t, consumer, consumedTopic))
+	require.NoError(t, err)
+	assert.Len(t, reader.committed, 1)
+	assert.Empty(t, writer.written)
+}
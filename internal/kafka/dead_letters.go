@@ -0,0 +1,71 @@
+package kafka
+
+import (
+	"context"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"time"
+)
+
+// DeadLetterStore is the subset of db.Repository needed to replay dead letter events
+type DeadLetterStore interface {
+	ProcessDeadLetterBatch(ctx context.Context, filter db.DeadLetterFilter, limit int, policy db.OutboxRetryPolicy, publish func(db.DeadLetterEvent) error) (int, error)
+}
+
+// DeadLetterRepublisher periodically attempts to publish events that the OutboxRelay gave up on.
+// Replayed events may reach Kafka after later events of the same transaction,
+// so consumers should order events of a transaction by their time rather than by offset.
+type DeadLetterRepublisher struct {
+	store     DeadLetterStore
+	publisher EventPublisher
+	log       *logger.Logger
+
+	// interval between replay attempts
+	interval  time.Duration
+	batchSize int
+
+	// policy spaces the replays of an event and caps them
+	policy db.OutboxRetryPolicy
+}
+
+func NewDeadLetterRepublisher(store DeadLetterStore, publisher EventPublisher, log *logger.Logger, interval time.Duration, batchSize int, policy db.OutboxRetryPolicy) *DeadLetterRepublisher {
+	return &DeadLetterRepublisher{
+		store:     store,
+		publisher: publisher,
+		log:       log,
+		interval:  interval,
+		batchSize: batchSize,
+		policy:    policy,
+	}
+}
+
+// Run replays dead letter events until ctx is cancelled
+func (r *DeadLetterRepublisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		replayed, err := ReplayDeadLetters(ctx, r.store, r.publisher, db.DeadLetterFilter{Due: true}, r.batchSize, r.policy)
+		if err != nil {
+			r.log.Error("failed to replay dead letter events", logger.ComponentKafka, logger.NewField("Error", err.Error()))
+			continue
+		}
+		if replayed > 0 {
+			r.log.Info("dead letter events replayed to Kafka", logger.ComponentKafka, logger.NewField("Count", replayed))
+		}
+	}
+}
+
+// ReplayDeadLetters publishes up to limit dead letter events matching filter, returning how many were published.
+// Events that fail are retried as policy allows.
+func ReplayDeadLetters(ctx context.Context, store DeadLetterStore, publisher EventPublisher, filter db.DeadLetterFilter, limit int, policy db.OutboxRetryPolicy) (int, error) {
+	return store.ProcessDeadLetterBatch(ctx, filter, limit, policy, func(event db.DeadLetterEvent) error {
+		return publishStored(ctx, publisher, event.TransactionID, event.Payload)
+	})
+}
@@ -0,0 +1,127 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deadLetterStore keeps dead letter events in memory, replaying them as db.DB.ProcessDeadLetterBatch does
+type deadLetterStore struct {
+	events []db.DeadLetterEvent
+	filter db.DeadLetterFilter
+}
+
+func (s *deadLetterStore) ProcessDeadLetterBatch(_ context.Context, filter db.DeadLetterFilter, limit int, policy db.OutboxRetryPolicy, publish func(db.DeadLetterEvent) error) (int, error) {
+	s.filter = filter
+	replayed := 0
+	for i := range s.events {
+		event := &s.events[i]
+		if event.ReplayedAt != nil || (filter.Due && event.AbandonedAt != nil) || replayed == limit {
+			continue
+		}
+		event.Attempts++
+		if err := publish(*event); err != nil {
+			event.LastError = err.Error()
+			if errors.Is(err, db.ErrUnpublishable) || (policy.MaxAttempts > 0 && event.Attempts >= policy.MaxAttempts) {
+				now := time.Now()
+				event.AbandonedAt = &now
+			}
+			continue
+		}
+		now := time.Now()
+		event.ReplayedAt = &now
+		replayed++
+	}
+	return replayed, nil
+}
+
+func deadLetter(t *testing.T, id int64, transactionID int) db.DeadLetterEvent {
+	t.Helper()
+	event := outboxEvent(t, transactionID, "")
+	return db.DeadLetterEvent{ID: id, OutboxEventID: event.ID, TransactionID: transactionID, Payload: event.Payload}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	store := &deadLetterStore{events: []db.DeadLetterEvent{deadLetter(t, 1, 7), deadLetter(t, 2, 8)}}
+	publisher := &recordingPublisher{}
+	filter := db.DeadLetterFilter{TransactionID: 7}
+
+	replayed, err := ReplayDeadLetters(context.Background(), store, publisher, filter, 10, db.OutboxRetryPolicy{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+	assert.Equal(t, filter, store.filter)
+	assert.Equal(t, []int{7, 8}, publisher.transactionIDs)
+	for _, event := range store.events {
+		assert.NotNil(t, event.ReplayedAt)
+	}
+
+	// replaying again publishes nothing twice
+	replayed, err = ReplayDeadLetters(context.Background(), store, publisher, filter, 10, db.OutboxRetryPolicy{})
+	require.NoError(t, err)
+	assert.Zero(t, replayed)
+	assert.Equal(t, []int{7, 8}, publisher.transactionIDs)
+}
+
+func TestReplayDeadLetters_PublishFailure(t *testing.T) {
+	store := &deadLetterStore{events: []db.DeadLetterEvent{deadLetter(t, 1, 7)}}
+	publisher := &recordingPublisher{err: errors.New("broker unavailable")}
+
+	replayed, err := ReplayDeadLetters(context.Background(), store, publisher, db.DeadLetterFilter{}, 10, db.OutboxRetryPolicy{})
+	require.NoError(t, err)
+	assert.Zero(t, replayed)
+	assert.Nil(t, store.events[0].ReplayedAt, "the event is replayed on a later attempt")
+	assert.Contains(t, store.events[0].LastError, "broker unavailable")
+}
+
+func TestReplayDeadLetters_UndecodablePayload(t *testing.T) {
+	store := &deadLetterStore{events: []db.DeadLetterEvent{{ID: 1, TransactionID: 7, Payload: []byte("not an event")}}}
+	publisher := &recordingPublisher{}
+
+	replayed, err := ReplayDeadLetters(context.Background(), store, publisher, db.DeadLetterFilter{}, 10, db.OutboxRetryPolicy{})
+	require.NoError(t, err)
+	assert.Zero(t, replayed)
+	assert.Empty(t, publisher.transactionIDs)
+	assert.Nil(t, store.events[0].ReplayedAt)
+	assert.NotNil(t, store.events[0].AbandonedAt, "the event can never be published")
+	assert.ErrorIs(t, publishStored(context.Background(), publisher, 7, []byte("not an event")), db.ErrUnpublishable)
+}
+
+func TestDeadLetterRepublisher_SkipsAbandoned(t *testing.T) {
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+
+	// an abandoned event does not hold back the newer ones
+	abandoned := deadLetter(t, 1, 7)
+	abandoned.AbandonedAt = &abandoned.FailedAt
+	store := &deadLetterStore{events: []db.DeadLetterEvent{abandoned, deadLetter(t, 2, 8)}}
+	publisher := &recordingPublisher{}
+
+	// Run returns once the first batch has been processed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	oneBatch := cancellingStore{deadLetterStore: store, cancel: cancel}
+	NewDeadLetterRepublisher(oneBatch, publisher, log, time.Millisecond, 1, db.OutboxRetryPolicy{MaxAttempts: 50}).Run(ctx)
+
+	assert.True(t, store.filter.Due)
+	assert.Nil(t, store.events[0].ReplayedAt)
+	assert.NotNil(t, store.events[1].ReplayedAt)
+	assert.Equal(t, []int{8}, publisher.transactionIDs)
+}
+
+// cancellingStore cancels a context after processing a batch
+type cancellingStore struct {
+	*deadLetterStore
+	cancel context.CancelFunc
+}
+
+func (s cancellingStore) ProcessDeadLetterBatch(ctx context.Context, filter db.DeadLetterFilter, limit int, policy db.OutboxRetryPolicy, publish func(db.DeadLetterEvent) error) (int, error) {
+	defer s.cancel()
+	return s.deadLetterStore.ProcessDeadLetterBatch(ctx, filter, limit, policy, publish)
+}
@@ -0,0 +1,97 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/events"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	"time"
+)
+
+// OutboxStore is the subset of db.Repository needed to relay outbox events
+type OutboxStore interface {
+	ProcessOutboxBatch(ctx context.Context, limit int, policy db.OutboxRetryPolicy, publish func(db.OutboxEvent) error) (int, error)
+}
+
+// OutboxRelay periodically publishes pending outbox events through an EventPublisher.
+// Events are claimed with FOR UPDATE SKIP LOCKED, so any number of app instances may run a relay.
+type OutboxRelay struct {
+	store     OutboxStore
+	publisher EventPublisher
+	log       *logger.Logger
+
+	// interval between polls of the outbox table when it has been drained
+	interval time.Duration
+
+	// maximum number of events claimed per database transaction
+	batchSize int
+
+	// how failed publishes are retried before an event is moved to the dead letter store
+	retryPolicy db.OutboxRetryPolicy
+}
+
+func NewOutboxRelay(store OutboxStore, publisher EventPublisher, log *logger.Logger, interval time.Duration, batchSize int, retryPolicy db.OutboxRetryPolicy) *OutboxRelay {
+	return &OutboxRelay{
+		store:       store,
+		publisher:   publisher,
+		log:         log,
+		interval:    interval,
+		batchSize:   batchSize,
+		retryPolicy: retryPolicy,
+	}
+}
+
+// Run relays outbox events until ctx is cancelled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.relay(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain publishes the pending outbox events until the outbox is empty or ctx is done, such as before shutting down
+func (r *OutboxRelay) Drain(ctx context.Context) {
+	r.relay(ctx)
+}
+
+// relay publishes batches of pending events until a batch comes back short, indicating the outbox is drained
+func (r *OutboxRelay) relay(ctx context.Context) {
+	for ctx.Err() == nil {
+		sent, err := r.store.ProcessOutboxBatch(ctx, r.batchSize, r.retryPolicy, func(event db.OutboxEvent) error {
+
+			// the publish continues the trace of the request that recorded the event
+			eventCtx := tracing.ContextWithTraceParent(ctx, event.TraceContext)
+			return publishStored(eventCtx, r.publisher, event.TransactionID, event.Payload)
+		})
+		if err != nil {
+			r.log.Error("failed to relay outbox events", logger.ComponentKafka, logger.NewField("Error", err.Error()))
+			return
+		}
+		if sent > 0 {
+			r.log.Debug("outbox events published to Kafka", logger.ComponentKafka, logger.NewField("Count", sent))
+		}
+		if sent < r.batchSize {
+			return
+		}
+	}
+}
+
+// publishStored publishes an event envelope stored by the outbox. Envelopes that cannot be decoded fail with
+// db.ErrUnpublishable.
+func publishStored(ctx context.Context, publisher EventPublisher, transactionID int, payload []byte) error {
+	event, err := events.Unmarshal(payload)
+	if err != nil {
+		return fmt.Errorf("%w: %w", db.ErrUnpublishable, err)
+	}
+	return publisher.PublishTransaction(ctx, transactionID, event)
+}
@@ -0,0 +1,146 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/events"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// outboxStore keeps outbox events in memory, claiming and updating them as db.DB.ProcessOutboxBatch does
+type outboxStore struct {
+	pending     []db.OutboxEvent
+	deadLetters []db.OutboxEvent
+	batches     int
+	policy      db.OutboxRetryPolicy
+	err         error
+}
+
+func (s *outboxStore) ProcessOutboxBatch(_ context.Context, limit int, policy db.OutboxRetryPolicy, publish func(db.OutboxEvent) error) (int, error) {
+	s.batches++
+	s.policy = policy
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	claimed := s.pending[:min(limit, len(s.pending))]
+	var remaining []db.OutboxEvent
+	sent := 0
+	for _, event := range claimed {
+		if err := publish(event); err != nil {
+			event.Attempts++
+			event.LastError = err.Error()
+			if event.Attempts >= policy.MaxAttempts {
+				s.deadLetters = append(s.deadLetters, event)
+			} else {
+				remaining = append(remaining, event)
+			}
+			continue
+		}
+		sent++
+	}
+	s.pending = append(remaining, s.pending[len(claimed):]...)
+	return sent, nil
+}
+
+// recordingPublisher records the events it publishes, failing while err is set
+type recordingPublisher struct {
+	Mock
+	transactionIDs []int
+	traceIDs       []string
+	err            error
+}
+
+func (p *recordingPublisher) PublishTransaction(ctx context.Context, transactionID int, _ events.Envelope) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.transactionIDs = append(p.transactionIDs, transactionID)
+	p.traceIDs = append(p.traceIDs, trace.SpanContextFromContext(ctx).TraceID().String())
+	return nil
+}
+
+func outboxEvent(t *testing.T, transactionID int, traceContext string) db.OutboxEvent {
+	t.Helper()
+	services.InitEncryptionKey("0123456789abcdef")
+
+	event, err := events.NewTransactionEvent(events.TypeDepositInitiated, db.Transaction{ID: transactionID, Type: "deposit"},
+		"", events.SensitiveData{UserID: 1}, "")
+	require.NoError(t, err)
+	payload, err := event.Marshal()
+	require.NoError(t, err)
+	return db.OutboxEvent{ID: int64(transactionID), TransactionID: transactionID, Payload: payload, TraceContext: traceContext}
+}
+
+func newTestRelay(t *testing.T, store OutboxStore, publisher EventPublisher, batchSize, maxAttempts int) *OutboxRelay {
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+	return NewOutboxRelay(store, publisher, log, 0, batchSize, db.OutboxRetryPolicy{MaxAttempts: maxAttempts})
+}
+
+func TestOutboxRelay_Publishes(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	store := &outboxStore{pending: []db.OutboxEvent{
+		outboxEvent(t, 1, "00-"+traceID+"-00f067aa0ba902b7-01"),
+		outboxEvent(t, 2, ""),
+		outboxEvent(t, 3, ""),
+	}}
+	publisher := &recordingPublisher{}
+
+	newTestRelay(t, store, publisher, 2, 5).Drain(context.Background())
+
+	// full batches are followed by another until the outbox is drained
+	assert.Equal(t, 2, store.batches)
+	assert.Empty(t, store.pending)
+	assert.Equal(t, []int{1, 2, 3}, publisher.transactionIDs)
+
+	// the publish continues the trace the event was recorded in
+	assert.Equal(t, traceID, publisher.traceIDs[0])
+}
+
+func TestOutboxRelay_PublishFailure(t *testing.T) {
+	store := &outboxStore{pending: []db.OutboxEvent{outboxEvent(t, 1, ""), outboxEvent(t, 2, "")}}
+	publisher := &recordingPublisher{err: errors.New("broker unavailable")}
+	relay := newTestRelay(t, store, publisher, 10, 3)
+
+	relay.Drain(context.Background())
+	assert.Equal(t, 1, store.batches, "a batch without any event sent ends the relay")
+	require.Len(t, store.pending, 2, "failed events are left pending")
+	assert.Equal(t, 1, store.pending[0].Attempts)
+	assert.Equal(t, "broker unavailable", store.pending[0].LastError)
+	assert.Empty(t, store.deadLetters)
+
+	// once the broker is back, pending events are published
+	publisher.err = nil
+	relay.Drain(context.Background())
+	assert.Empty(t, store.pending)
+	assert.Equal(t, []int{1, 2}, publisher.transactionIDs)
+}
+
+func TestOutboxRelay_MaxAttempts(t *testing.T) {
+	store := &outboxStore{pending: []db.OutboxEvent{outboxEvent(t, 1, "")}}
+	publisher := &recordingPublisher{err: errors.New("broker unavailable")}
+	relay := newTestRelay(t, store, publisher, 10, 3)
+
+	for range 3 {
+		relay.Drain(context.Background())
+	}
+	assert.Equal(t, 3, store.policy.MaxAttempts)
+	assert.Empty(t, store.pending)
+	require.Len(t, store.deadLetters, 1)
+	assert.Equal(t, 3, store.deadLetters[0].Attempts)
+}
+
+func TestOutboxRelay_StoreFailure(t *testing.T) {
+	store := &outboxStore{err: errors.New("connection refused")}
+
+	newTestRelay(t, store, &recordingPublisher{}, 10, 3).Drain(context.Background())
+	assert.Equal(t, 1, store.batches, "the relay gives up until the next poll")
+}
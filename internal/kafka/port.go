@@ -2,17 +2,17 @@ package kafka
 
 import (
 	"context"
-	"github.com/ercross/payment_gateways/internal/api/v1/dto"
+	"github.com/ercross/payment_gateways/internal/events"
 )
 
 type EventPublisher interface {
-	PublishTransaction(ctx context.Context, transactionID int, message []byte, dataFormat dto.DataFormat) error
+	PublishTransaction(ctx context.Context, transactionID int, event events.Envelope) error
 	Close() error
 }
 
 type Mock struct{}
 
-func (m *Mock) PublishTransaction(ctx context.Context, transactionID int, message []byte, dataFormat dto.DataFormat) error {
+func (m *Mock) PublishTransaction(ctx context.Context, transactionID int, event events.Envelope) error {
 	return nil
 }
 func (m *Mock) Close() error { return nil }
@@ -3,18 +3,68 @@ package kafka
 import (
 	"context"
 	"fmt"
-	"github.com/ercross/payment_gateways/internal/api/v1/dto"
+	"github.com/ercross/payment_gateways/internal/events"
+	"github.com/ercross/payment_gateways/internal/metrics"
 	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
+// TopicConfig configures a topic transaction events are published to, and the events.Codec
+// events are serialized with on that topic
+type TopicConfig struct {
+	Name  string
+	Codec string
+}
+
+// ParseTopicConfigs parses a comma separated list of "<topic>:<codec>" pairs, e.g. "transactions.json:json,transactions.avro:avro"
+func ParseTopicConfigs(value string) ([]TopicConfig, error) {
+	var configs []TopicConfig
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, codec, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || codec == "" {
+			return nil, fmt.Errorf("invalid topic config %q: expected <topic>:<codec>", pair)
+		}
+		configs = append(configs, TopicConfig{Name: name, Codec: codec})
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no topic configured")
+	}
+	return configs, nil
+}
+
+type topic struct {
+	name  string
+	codec events.Codec
+}
+
 type Kafka struct {
 	writer *kafka.Writer
+	topics []topic
 }
 
-func NewProducer(brokerUrl string) *Kafka {
+// NewProducer creates a producer publishing every transaction event to each of topicConfigs.
+// Schemas of schema backed codecs are registered in registry under the "<topic>-value" subject.
+func NewProducer(brokerUrl string, topicConfigs []TopicConfig, registry events.SchemaRegistry) (*Kafka, error) {
+	topics := make([]topic, 0, len(topicConfigs))
+	for _, config := range topicConfigs {
+		codec, err := events.NewCodec(config.Codec, registry, config.Name+"-value")
+		if err != nil {
+			return nil, fmt.Errorf("error configuring topic %s: %w", config.Name, err)
+		}
+		topics = append(topics, topic{name: config.Name, codec: codec})
+	}
+
 	writer := &kafka.Writer{
 		Addr:                   kafka.TCP(brokerUrl),
 		Balancer:               &kafka.LeastBytes{},
@@ -23,38 +73,49 @@ func NewProducer(brokerUrl string) *Kafka {
 	}
 	return &Kafka{
 		writer: writer,
-	}
-}
-
-// GetTopic returns the appropriate Kafka topic based on the data format.
-func GetTopic(dataFormat dto.DataFormat) (string, error) {
-	switch dataFormat {
-	case dto.DataFormatJSON:
-		return "transactions.json", nil
-	case dto.DataFormatXML:
-		return "transactions.soap", nil
-	default:
-		return "", fmt.Errorf("unsupported data format: %s", dataFormat)
-	}
+		topics: topics,
+	}, nil
 }
 
-// PublishTransaction publishes to transaction topic
-func (p *Kafka) PublishTransaction(ctx context.Context, transactionID int, message []byte, dataFormat dto.DataFormat) error {
+// PublishTransaction publishes event to every configured transaction topic,
+// carrying the trace context of ctx in the traceparent header of each message
+func (p *Kafka) PublishTransaction(ctx context.Context, transactionID int, event events.Envelope) (err error) {
+	ctx, span := tracing.Start(ctx, "kafka.PublishTransaction", trace.SpanKindProducer,
+		semconv.MessagingSystemKafka, semconv.MessagingOperationTypePublish, semconv.MessagingMessageID(event.ID),
+		attribute.String("event.type", string(event.Type)), attribute.Int("transaction.id", transactionID))
+	defer func() { tracing.End(span, err) }()
 
-	topic, err := GetTopic(dataFormat)
-	if err != nil {
-		return err
-	}
-
-	kafkaMessage := kafka.Message{
-		Key:   []byte(fmt.Sprint(transactionID)),
-		Value: message,
-		Topic: topic,
+	kafkaMessages := make([]kafka.Message, 0, len(p.topics))
+	for _, t := range p.topics {
+		value, err := t.codec.Encode(event)
+		if err != nil {
+			return fmt.Errorf("error encoding transaction %d event for topic %s: %w", transactionID, t.name, err)
+		}
+		headers := []kafka.Header{
+			{Key: "content-type", Value: []byte(t.codec.ContentType())},
+			{Key: "ce_id", Value: []byte(event.ID)},
+			{Key: "ce_type", Value: []byte(event.Type)},
+		}
+		tracing.Inject(ctx, headerCarrier{&headers})
+		kafkaMessages = append(kafkaMessages, kafka.Message{
+			Key:     []byte(fmt.Sprint(transactionID)),
+			Value:   value,
+			Topic:   t.name,
+			Headers: headers,
+		})
 	}
 
 	err = services.PublishWithCircuitBreaker(func() error {
-		return p.writer.WriteMessages(ctx, kafkaMessage)
+		return p.writer.WriteMessages(ctx, kafkaMessages...)
 	})
+	switch {
+	case err == nil:
+		metrics.ObserveKafkaPublish(string(event.Type), metrics.OutcomeSuccess)
+	case services.IsCircuitOpen(err):
+		metrics.ObserveKafkaPublish(string(event.Type), metrics.OutcomeRejected)
+	default:
+		metrics.ObserveKafkaPublish(string(event.Type), metrics.OutcomeError)
+	}
 	if err != nil {
 		return fmt.Errorf("error publishing transaction %d: %w", transactionID, err)
 	}
@@ -62,6 +123,19 @@ func (p *Kafka) PublishTransaction(ctx context.Context, transactionID int, messa
 	return nil
 }
 
+// Ping checks that the broker is reachable
+func (p *Kafka) Ping(ctx context.Context) error {
+	conn, err := kafka.DialContext(ctx, p.writer.Addr.Network(), p.writer.Addr.String())
+	if err != nil {
+		return fmt.Errorf("error connecting to kafka broker: %w", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Brokers(); err != nil {
+		return fmt.Errorf("error listing kafka brokers: %w", err)
+	}
+	return nil
+}
+
 // Close the writer when the system shut down
 func (p *Kafka) Close() error {
 	return p.writer.Close()
@@ -1,91 +1,237 @@
 package logger
 
 import (
-	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-// FileDestination writes logs to a file
+// FileConfig configures a FileDestination
+type FileConfig struct {
+	Path string
+
+	// The file is rotated once it reaches MaxSize bytes, or RotateEvery after it was opened; never when both are zero
+	MaxSize     int64
+	RotateEvery time.Duration
+
+	// Rotated files are deleted beyond MaxBackups files or MaxAge old; kept when zero
+	MaxBackups int
+	MaxAge     time.Duration
+
+	// Compress gzips rotated files
+	Compress bool
+}
+
+// FileDestination writes logs to a file, optionally rotating it.
+// Rotated files are renamed with the time of rotation, such as app-20240601T120000.000.log for app.log.
 type FileDestination struct {
-	file *os.File
+	config FileConfig
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	// housekeeping tracks the compression and deletion of rotated files, which housekeepingMu runs one rotation at a time
+	housekeeping   sync.WaitGroup
+	housekeepingMu sync.Mutex
+	now            func() time.Time
 }
 
+const backupTimeFormat = "20060102T150405.000"
+
 // NewFileDestination initializes a new file destination for logs
-func NewFileDestination(filePath string) (*FileDestination, error) {
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+func NewFileDestination(config FileConfig) (*FileDestination, error) {
+	f := &FileDestination{config: config, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileDestination) open() error {
+	file, err := os.OpenFile(f.config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
-		return nil, fmt.Errorf("could not open log file: %w", err)
+		return fmt.Errorf("could not open log file: %w", err)
 	}
-	return &FileDestination{file: file}, nil
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	f.file, f.size, f.openedAt = file, info.Size(), f.now()
+	return nil
 }
 
-// Write writes log entry to a file
+// Write writes log entry to a file, rotating it first when due
 func (f *FileDestination) Write(p []byte) (n int, err error) {
-	return f.file.Write(p)
-}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-// Close closes the file
-func (f *FileDestination) Close() error {
-	return f.file.Close()
+	if f.rotationDue(len(p)) {
+		if err = f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err = f.file.Write(p)
+	f.size += int64(n)
+	return n, err
 }
 
-// ConsoleDestination writes logs to the console
-type ConsoleDestination struct{}
+func (f *FileDestination) rotationDue(next int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.config.MaxSize > 0 && f.size+int64(next) > f.config.MaxSize {
+		return true
+	}
+	return f.config.RotateEvery > 0 && f.now().Sub(f.openedAt) >= f.config.RotateEvery
+}
 
-// Write writes log entry to console (stdout)
-func (c *ConsoleDestination) Write(p []byte) (n int, err error) {
-	return fmt.Fprintf(os.Stdout, "%s", p)
+// Rotate renames the file and starts writing to a new one
+func (f *FileDestination) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate()
 }
 
-// Close closes the console destination (no-op for console)
-func (c *ConsoleDestination) Close() error {
+func (f *FileDestination) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("could not close log file: %w", err)
+	}
+
+	ext := filepath.Ext(f.config.Path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.config.Path, ext), f.now().UTC().Format(backupTimeFormat), ext)
+	if err := os.Rename(f.config.Path, backup); err != nil {
+		// keep logging to the current file rather than losing entries
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("could not rotate log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	f.housekeeping.Add(1)
+	go func() {
+		defer f.housekeeping.Done()
+		f.housekeepingMu.Lock()
+		defer f.housekeepingMu.Unlock()
+		if err := f.housekeep(); err != nil {
+			fmt.Fprintf(os.Stderr, "could not clean up rotated log files: %s\n", err)
+		}
+	}()
 	return nil
 }
 
-// RemoteDestination simulates sending logs to a remote aggregator (e.g., Prometheus or Grafana)
-type RemoteDestination struct {
-	url string
+// Backups returns the paths of the rotated files, oldest first
+func (f *FileDestination) Backups() ([]string, error) {
+	ext := filepath.Ext(f.config.Path)
+	prefix := strings.TrimSuffix(filepath.Base(f.config.Path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.config.Path))
+	if err != nil {
+		return nil, err
+	}
 
-	// client with Transport that reuses connections
-	client *http.Client
-}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(strings.TrimSuffix(stamp, ".gz"), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); !ok || err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(f.config.Path), name))
+	}
 
-func NewRemoteDestination(url string) *RemoteDestination {
+	// the timestamps sort chronologically
+	sort.Strings(backups)
+	return backups, nil
+}
 
-	// Create a custom HTTP client with a Transport that reuses connections
-	client := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:        10,               // Maximum idle connections across all hosts
-			MaxIdleConnsPerHost: 5,                // Max idle connections to a single host
-			IdleConnTimeout:     90 * time.Second, // Keep-alive timeout
-		},
+// housekeep deletes the rotated files beyond the retention limits, then compresses the remaining ones if configured
+func (f *FileDestination) housekeep() error {
+	backups, err := f.Backups()
+	if err != nil {
+		return err
 	}
 
-	return &RemoteDestination{url: url, client: client}
+	var errs []error
+	for i, backup := range backups {
+		expired := f.config.MaxBackups > 0 && i < len(backups)-f.config.MaxBackups
+		if !expired && f.config.MaxAge > 0 {
+			info, err := os.Stat(backup)
+			expired = err == nil && f.now().Sub(info.ModTime()) > f.config.MaxAge
+		}
+		switch {
+		case expired:
+			if err := os.Remove(backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		case f.config.Compress && !strings.HasSuffix(backup, ".gz"):
+			if err := compressFile(backup); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
 }
 
-// Write sends log entry to remote destination
-func (r *RemoteDestination) Write(p []byte) (n int, err error) {
-	req, err := http.NewRequest("POST", r.url, bytes.NewBuffer(p))
+// compressFile replaces path with its gzipped copy path.gz
+func compressFile(path string) error {
+	src, err := os.Open(path)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	defer src.Close()
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	defer resp.Body.Close()
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
 
-	return len(p), nil
+// Close closes the file, waiting for rotated files to be compressed
+func (f *FileDestination) Close() error {
+	f.mu.Lock()
+	err := f.file.Close()
+	f.mu.Unlock()
+	f.housekeeping.Wait()
+	return err
 }
 
-// Close closes the remote destination (no-op for this example)
-func (r *RemoteDestination) Close() error {
+// ConsoleDestination writes logs to the console
+type ConsoleDestination struct{}
+
+// Write writes log entry to console (stdout)
+func (c *ConsoleDestination) Write(p []byte) (n int, err error) {
+	return fmt.Fprintf(os.Stdout, "%s", p)
+}
+
+// Close closes the console destination (no-op for console)
+func (c *ConsoleDestination) Close() error {
 	return nil
 }
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileDestination_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	f, err := NewFileDestination(FileConfig{Path: path, MaxSize: 10, RotateEvery: time.Hour, MaxBackups: 2, Compress: true})
+	require.NoError(t, err)
+	f.now = func() time.Time { return now }
+
+	write := func(line string) {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+		now = now.Add(time.Second)
+	}
+	write("first\n")
+	write("second\n") // exceeds MaxSize
+	now = now.Add(time.Hour)
+	write("third\n") // RotateEvery elapsed
+	write("fourth\n")
+	require.NoError(t, f.Close())
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "fourth\n", string(current))
+
+	// the oldest backup is beyond MaxBackups
+	backups, err := f.Backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	assert.Equal(t, filepath.Join(filepath.Dir(path), "app-20240601T130003.000.log.gz"), backups[1])
+
+	gz, err := os.Open(backups[0])
+	require.NoError(t, err)
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	require.NoError(t, err)
+	raw, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(raw))
+}
+
+type memoryDestination struct {
+	buffer
+}
+
+func TestTeeDestination(t *testing.T) {
+	console, file := new(memoryDestination), new(memoryDestination)
+	tee, err := NewTeeDestination(
+		TeeSink{Name: "console", Destination: console, Level: INFO},
+		TeeSink{Name: "file", Destination: file, Level: DEBUG},
+	)
+	require.NoError(t, err)
+	log, err := NewLogger(&Config{Level: DEBUG, Destination: tee, Redactor: NewRedactor()})
+	require.NoError(t, err)
+
+	log.Debug("debug")
+	log.Info("info")
+	assert.NotContains(t, console.String(), `"debug"`)
+	assert.Contains(t, console.String(), `"info"`)
+	assert.Contains(t, file.String(), `"debug"`)
+
+	// levels and sinks change while logging
+	require.NoError(t, tee.SetLevel("console", ERROR))
+	remote := new(memoryDestination)
+	require.NoError(t, tee.AddSink(TeeSink{Name: "remote", Destination: remote, Level: WARN}))
+	assert.Error(t, tee.AddSink(TeeSink{Name: "remote", Destination: remote}))
+	log.Warn("warn")
+	assert.NotContains(t, console.String(), `"warn"`)
+	assert.Contains(t, remote.String(), `"warn"`)
+
+	require.NoError(t, tee.RemoveSink("file"))
+	log.Error("error")
+	assert.NotContains(t, file.String(), `"error"`)
+	assert.Equal(t, map[string]Level{"console": ERROR, "remote": WARN}, tee.Levels())
+}
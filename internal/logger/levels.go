@@ -0,0 +1,210 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RootComponent names the level of entries without a component, which components inherit unless set
+const RootComponent = "root"
+
+var ErrUnknownComponent = errors.New("unknown log component")
+
+// inherited marks a component level following the root level
+const inherited int32 = 1 << 16
+
+func (l Level) String() string {
+	return zapcore.Level(l).String()
+}
+
+// ParseLevel parses a level name such as "debug" or "ERROR"
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return DEBUG, nil
+	case "info":
+		return INFO, nil
+	case "warn":
+		return WARN, nil
+	case "error":
+		return ERROR, nil
+	case "fatal":
+		return FATAL, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
+// Levels holds the level of the root logger and of each component, which can be changed while logging
+type Levels struct {
+	root       *componentLevel
+	components sync.Map // string -> *componentLevel
+
+	// mu serializes changes and guards the revert timers
+	mu sync.Mutex
+}
+
+type componentLevel struct {
+	level atomic.Int32
+
+	// revert restores base at revertAt when the level was set temporarily
+	revert   *time.Timer
+	revertAt time.Time
+	base     int32
+}
+
+// LevelStatus describes the level of a component
+type LevelStatus struct {
+	Component string     `json:"component" xml:"component"`
+	Level     string     `json:"level" xml:"level"`
+	Inherited bool       `json:"inherited" xml:"inherited"`
+	RevertAt  *time.Time `json:"revert_at,omitempty" xml:"revert_at,omitempty"`
+}
+
+func newLevels(root Level) *Levels {
+	ls := &Levels{root: new(componentLevel)}
+	ls.root.level.Store(int32(root))
+	for _, component := range []Field{ComponentKafka, ComponentRedis, ComponentDatabase, ComponentAudit} {
+		ls.component(component.Value.(string))
+	}
+	return ls
+}
+
+// component returns the level of the component named name, registering it if needed
+func (ls *Levels) component(name string) *componentLevel {
+	if name == RootComponent {
+		return ls.root
+	}
+	if c, ok := ls.components.Load(name); ok {
+		return c.(*componentLevel)
+	}
+	c := new(componentLevel)
+	c.level.Store(inherited)
+	actual, _ := ls.components.LoadOrStore(name, c)
+	return actual.(*componentLevel)
+}
+
+func (ls *Levels) lookup(name string) (*componentLevel, bool) {
+	if name == RootComponent {
+		return ls.root, true
+	}
+	c, ok := ls.components.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return c.(*componentLevel), true
+}
+
+// enabled reports whether entries of level are logged for c, or for the root when c is nil
+func (ls *Levels) enabled(c *componentLevel, level Level) bool {
+	min := inherited
+	if c != nil {
+		min = c.level.Load()
+	}
+	if min == inherited {
+		min = ls.root.level.Load()
+	}
+	return int32(level) >= min
+}
+
+// Set sets the level of component. When ttl is positive, the previous level is restored after ttl.
+func (ls *Levels) Set(component string, level Level, ttl time.Duration) error {
+	c, ok := ls.lookup(component)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownComponent, component)
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ttl <= 0 {
+		ls.cancelRevert(c)
+		c.level.Store(int32(level))
+		return nil
+	}
+
+	// successive temporary levels all revert to the level set before the first
+	if c.revert == nil {
+		c.base = c.level.Load()
+	} else {
+		c.revert.Stop()
+	}
+	c.level.Store(int32(level))
+	c.revertAt = time.Now().Add(ttl)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(ttl, func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		if c.revert != timer {
+			return
+		}
+		c.level.Store(c.base)
+		c.revert = nil
+	})
+	c.revert = timer
+	return nil
+}
+
+// Reset makes component inherit the root level again. The root level itself cannot be reset.
+func (ls *Levels) Reset(component string) error {
+	if component == RootComponent {
+		return errors.New("the root level cannot be reset")
+	}
+	c, ok := ls.lookup(component)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownComponent, component)
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.cancelRevert(c)
+	c.level.Store(inherited)
+	return nil
+}
+
+func (ls *Levels) cancelRevert(c *componentLevel) {
+	if c.revert != nil {
+		c.revert.Stop()
+		c.revert = nil
+	}
+}
+
+// Status returns the level of the root and of every component, the root first
+func (ls *Levels) Status() []LevelStatus {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var names []string
+	ls.components.Range(func(name, _ any) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+
+	statuses := []LevelStatus{ls.status(RootComponent, ls.root)}
+	for _, name := range names {
+		c, _ := ls.lookup(name)
+		statuses = append(statuses, ls.status(name, c))
+	}
+	return statuses
+}
+
+func (ls *Levels) status(name string, c *componentLevel) LevelStatus {
+	status := LevelStatus{Component: name}
+	level := c.level.Load()
+	if level == inherited {
+		status.Inherited = true
+		level = ls.root.level.Load()
+	}
+	status.Level = Level(level).String()
+	if c.revert != nil {
+		revertAt := c.revertAt
+		status.RevertAt = &revertAt
+	}
+	return status
+}
@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_ComponentLevels(t *testing.T) {
+	out := new(buffer)
+	log, err := NewLogger(&Config{Level: INFO, Destination: out})
+	require.NoError(t, err)
+	kafka := log.Named("Kafka")
+
+	log.Debug("root debug")
+	kafka.Debug("kafka debug")
+	assert.Empty(t, out.String())
+
+	require.NoError(t, log.Levels().Set("Kafka", DEBUG, 0))
+	kafka.Debug("kafka debug")
+	log.Debug("component field debug", ComponentKafka)
+	log.Debug("root debug")
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+	assert.Contains(t, out.String(), `"msg":"kafka debug"`)
+	assert.Contains(t, out.String(), `"msg":"component field debug"`)
+	assert.NotContains(t, out.String(), "root debug")
+
+	require.NoError(t, log.Levels().Reset("Kafka"))
+	kafka.Debug("inherited")
+	assert.NotContains(t, out.String(), "inherited")
+
+	assert.ErrorIs(t, log.Levels().Set("Unknown", DEBUG, 0), ErrUnknownComponent)
+	assert.Error(t, log.Levels().Reset(RootComponent))
+}
+
+func TestLevels_TTL(t *testing.T) {
+	levels := newLevels(INFO)
+	kafka := levels.component("Kafka")
+
+	require.NoError(t, levels.Set("Kafka", WARN, 0))
+	require.NoError(t, levels.Set("Kafka", DEBUG, 20*time.Millisecond))
+	require.NoError(t, levels.Set("Kafka", ERROR, 20*time.Millisecond))
+	assert.False(t, levels.enabled(kafka, WARN))
+
+	status := levels.Status()
+	require.Equal(t, RootComponent, status[0].Component)
+	for _, s := range status {
+		if s.Component == "Kafka" {
+			assert.Equal(t, "error", s.Level)
+			assert.NotNil(t, s.RevertAt)
+		}
+	}
+
+	// temporary levels revert to the level set before the first of them
+	require.Eventually(t, func() bool {
+		return levels.enabled(kafka, WARN) && !levels.enabled(kafka, INFO)
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestLogger_Ctx(t *testing.T) {
+	out := new(buffer)
+	log, err := NewLogger(&Config{Level: INFO, Destination: out})
+	require.NoError(t, err)
+
+	ctx := ContextWithFields(context.Background(), NewField("Request-ID", "req-1"))
+	log.Named("Redis").Ctx(ctx).Info("bound")
+	log.Ctx(context.Background()).Info("unbound")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"Request-ID":"req-1"`)
+	assert.Contains(t, lines[0], `"Component":"Redis"`)
+	assert.NotContains(t, lines[1], "Request-ID")
+}
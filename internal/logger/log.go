@@ -1,6 +1,8 @@
 package logger
 
 import (
+	"context"
+	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"io"
@@ -8,6 +10,9 @@ import (
 	"time"
 )
 
+// componentKey is the key of the field naming the component an entry comes from
+const componentKey = "Component"
+
 var (
 	ComponentKafka = Field{
 		Key:   "Component",
@@ -23,6 +28,12 @@ var (
 		Key:   "Component",
 		Value: "Database",
 	}
+
+	// ComponentAudit marks security relevant events, such as denied access attempts
+	ComponentAudit = Field{
+		Key:   "Component",
+		Value: "Audit",
+	}
 )
 
 // Level represents the severity of the log message.
@@ -37,13 +48,24 @@ const (
 )
 
 type Config struct {
-	Level       Level
+	Level Level
+
+	// Destination receives every entry of Level or above; a TeeDestination further filters entries by level per sink
 	Destination io.WriteCloser
+
+	// Redactor masks sensitive values before they are logged, DefaultRedactor when nil
+	Redactor *Redactor
 }
 
 type Logger struct {
-	zap    *zap.Logger
-	config *Config
+	zap      *zap.Logger
+	config   *Config
+	redactor *Redactor
+
+	// levels is shared by the loggers derived from the same root; component is the level of a Named logger,
+	// nil for loggers that take the component of each entry from its Component field
+	levels    *Levels
+	component *componentLevel
 }
 
 // Field represents a key-value pair for structured logging
@@ -58,19 +80,32 @@ func NewField(key string, value interface{}) Field {
 
 func NewLogger(config *Config) (*Logger, error) {
 
-	w := zapcore.AddSync(config.Destination)
 	encoderConfig := zap.NewProductionEncoderConfig()
 	encoderConfig.TimeKey = "timestamp"
 	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 
-	core := zapcore.NewCore(
-		zapcore.NewJSONEncoder(encoderConfig),
-		w,
-		zapcore.Level(config.Level),
-	)
+	var core zapcore.Core
+	if tee, ok := config.Destination.(*TeeDestination); ok {
+		core = &teeCore{
+			LevelEnabler: zapcore.DebugLevel,
+			encoder:      zapcore.NewJSONEncoder(encoderConfig),
+			tee:          tee,
+		}
+	} else {
+		core = zapcore.NewCore(
+			zapcore.NewJSONEncoder(encoderConfig),
+			zapcore.AddSync(config.Destination),
+			// levels are enforced by Logger, so that they can be changed per component
+			zapcore.DebugLevel,
+		)
+	}
 
 	zapLogger := zap.New(core)
-	return &Logger{zap: zapLogger, config: config}, nil
+	redactor := config.Redactor
+	if redactor == nil {
+		redactor = DefaultRedactor()
+	}
+	return &Logger{zap: zapLogger, config: config, redactor: redactor, levels: newLevels(config.Level)}, nil
 }
 
 // NewSilentLogger creates a logger that silences all log output
@@ -81,43 +116,107 @@ func NewSilentLogger() (*Logger, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Logger{zap: zapLogger, config: &Config{}}, nil
+	return &Logger{zap: zapLogger, config: &Config{}, redactor: NewRedactor(), levels: newLevels(FATAL + 1)}, nil
 }
 
 func (l *Logger) Info(msg string, fields ...Field) {
-	l.zap.Info(msg, toZapFields(fields)...)
+	if l.enabled(INFO, fields) {
+		l.zap.Info(msg, l.toZapFields(fields)...)
+	}
 }
 
 func (l *Logger) Debug(msg string, fields ...Field) {
-	l.zap.Debug(msg, toZapFields(fields)...)
+	if l.enabled(DEBUG, fields) {
+		l.zap.Debug(msg, l.toZapFields(fields)...)
+	}
 }
 
 func (l *Logger) Warn(msg string, fields ...Field) {
-	l.zap.Warn(msg, toZapFields(fields)...)
+	if l.enabled(WARN, fields) {
+		l.zap.Warn(msg, l.toZapFields(fields)...)
+	}
 }
 
 func (l *Logger) Error(msg string, fields ...Field) {
-	l.zap.Error(msg, toZapFields(fields)...)
+	if l.enabled(ERROR, fields) {
+		l.zap.Error(msg, l.toZapFields(fields)...)
+	}
 }
 
+// Fatal logs msg regardless of levels, then exits
 func (l *Logger) Fatal(msg string, fields ...Field) {
-	l.zap.Fatal(msg, toZapFields(fields)...)
+	l.zap.Fatal(msg, l.toZapFields(fields)...)
+}
+
+// enabled reports whether an entry of level with fields is logged, according to the level of its component
+func (l *Logger) enabled(level Level, fields []Field) bool {
+	component := l.component
+	if component == nil {
+		for _, field := range fields {
+			if name, ok := field.Value.(string); ok && field.Key == componentKey {
+				component, _ = l.levels.lookup(name)
+				break
+			}
+		}
+	}
+	return l.levels.enabled(component, level)
+}
+
+// Named returns a logger for component, whose entries carry a Component field and whose level is set independently
+func (l *Logger) Named(component string) *Logger {
+	named := l.With(NewField(componentKey, component))
+	named.component = l.levels.component(component)
+	return named
+}
+
+// With returns a logger adding fields to every entry
+func (l *Logger) With(fields ...Field) *Logger {
+	child := *l
+	child.zap = l.zap.With(l.toZapFields(fields)...)
+	return &child
+}
+
+// Ctx returns a logger adding the fields bound to ctx, such as the ID of the request being served, to every entry
+func (l *Logger) Ctx(ctx context.Context) *Logger {
+	fields, _ := ctx.Value(contextFieldsKey{}).([]Field)
+	if len(fields) == 0 {
+		return l
+	}
+	return l.With(fields...)
+}
+
+// Levels returns the levels of the root logger and of its components
+func (l *Logger) Levels() *Levels {
+	return l.levels
+}
+
+type contextFieldsKey struct{}
+
+// ContextWithFields binds fields to ctx, to be added to the entries of loggers returned by Logger.Ctx
+func ContextWithFields(ctx context.Context, fields ...Field) context.Context {
+	existing, _ := ctx.Value(contextFieldsKey{}).([]Field)
+	bound := append(existing[:len(existing):len(existing)], fields...)
+	return context.WithValue(ctx, contextFieldsKey{}, bound)
 }
 
 func (l *Logger) Flush() error {
 	return l.zap.Sync()
 }
 
-// RequestLogger is a middleware that logs HTTP requests
+// RequestLogger is a middleware that logs HTTP requests.
+// It binds the request ID set by middleware.RequestID to the request context, see Logger.Ctx.
 func RequestLogger(logger *Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
+			if id := middleware.GetReqID(r.Context()); id != "" {
+				r = r.WithContext(ContextWithFields(r.Context(), NewField("Request-ID", id)))
+			}
 			wrappedWriter := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 			next.ServeHTTP(wrappedWriter, r)
-			logger.Info("Request",
+			logger.Ctx(r.Context()).Info("Request",
 				NewField("method", r.Method),
-				NewField("url", r.URL.String()),
+				NewField("url", logger.redactor.URL(r.URL)),
 				NewField("status", wrappedWriter.statusCode),
 				NewField("client_ip", r.RemoteAddr),
 				NewField("response_time", time.Since(start)),
@@ -126,8 +225,9 @@ func RequestLogger(logger *Logger) func(next http.Handler) http.Handler {
 	}
 }
 
-// toZapFields converts our custom Field type to zap.Field, keeping zap.Field encapsulated.
-func toZapFields(fields []Field) []zap.Field {
+// toZapFields redacts and converts our custom Field type to zap.Field, keeping zap.Field encapsulated.
+func (l *Logger) toZapFields(fields []Field) []zap.Field {
+	fields = l.redactor.Fields(fields)
 	zapFields := make([]zap.Field, len(fields))
 	for i, field := range fields {
 		zapFields[i] = zap.Any(field.Key, field.Value)
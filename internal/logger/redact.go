@@ -0,0 +1,403 @@
+package logger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Redacted replaces sensitive values that are dropped but whose position must remain visible, such as within a message
+const Redacted = "[REDACTED]"
+
+// MaskStrategy is how a sensitive value is masked
+type MaskStrategy int8
+
+const (
+
+	// MaskHash replaces the value with a short hash, so that occurrences of a value can be correlated without disclosing it
+	MaskHash MaskStrategy = iota
+
+	// MaskPartial only keeps the last 4 characters of the value
+	MaskPartial
+
+	// MaskDrop removes the field, or replaces the value with Redacted
+	MaskDrop
+)
+
+// Redactor masks sensitive values before they are logged. Values are masked when:
+//   - the key of their field, struct field, map entry or URL query parameter is registered as sensitive;
+//   - they are struct fields tagged `log:"redact"`, optionally followed by the strategy: `log:"redact,partial"`;
+//   - they are strings matching a registered pattern, such as an email address or a card number.
+//
+// Structs, slices and maps are walked at any depth. A Redactor must be fully configured before it is used.
+type Redactor struct {
+	keys     map[string]MaskStrategy
+	patterns []redactPattern
+	hashKey  []byte
+
+	// walked caches whether values of a type may hold values to mask: reflect.Type -> bool
+	walked sync.Map
+}
+
+type redactPattern struct {
+	name     string
+	re       *regexp.Regexp
+	strategy MaskStrategy
+
+	// valid filters out matches that are not actually sensitive, such as digit sequences that are not card numbers
+	valid func(string) bool
+}
+
+// NewRedactor returns a Redactor masking nothing
+func NewRedactor() *Redactor {
+	return &Redactor{keys: make(map[string]MaskStrategy)}
+}
+
+// DefaultRedactor returns a Redactor masking credentials, emails, IBANs and card numbers
+func DefaultRedactor() *Redactor {
+	r := NewRedactor()
+	for _, key := range []string{"authorization", "cookie", "set_cookie", "password", "secret", "x_api_key", "api_key",
+		"authentication_code", "recovery_code", "recovery_codes"} {
+		r.AddKey(key, MaskDrop)
+	}
+	for _, key := range []string{"token", "access_token", "refresh_token", "id_token"} {
+		r.AddKey(key, MaskHash)
+	}
+	for _, key := range []string{"email", "iban", "card_number", "pan", "receiving_account", "receiving_account_id"} {
+		r.AddKey(key, MaskPartial)
+	}
+
+	r.AddPattern("bearer", regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`), MaskDrop)
+	r.AddPattern("jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), MaskDrop)
+	r.AddPattern("api-key", regexp.MustCompile(`\bpgk_[0-9a-f]+_[A-Za-z0-9_-]+`), MaskDrop)
+	r.AddPattern("email", regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), MaskPartial)
+	r.AddPattern("iban", regexp.MustCompile(`\b[A-Z]{2}\d{2}[A-Z0-9]{11,30}\b`), MaskPartial)
+	r.addPattern(redactPattern{
+		name:     "card",
+		re:       regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		strategy: MaskPartial,
+		valid:    luhn,
+	})
+	return r
+}
+
+// AddKey masks the values of fields and URL query parameters named key, case and separator insensitively
+func (r *Redactor) AddKey(key string, strategy MaskStrategy) {
+	r.keys[normalizeKey(key)] = strategy
+}
+
+// AddPattern masks the parts of strings matching re
+func (r *Redactor) AddPattern(name string, re *regexp.Regexp, strategy MaskStrategy) {
+	r.addPattern(redactPattern{name: name, re: re, strategy: strategy})
+}
+
+func (r *Redactor) addPattern(p redactPattern) {
+	r.patterns = append(r.patterns, p)
+}
+
+// SetHashKey makes MaskHash use HMAC-SHA256 keyed with key, so that hashes of low-entropy values cannot be reversed
+// by hashing every possible value
+func (r *Redactor) SetHashKey(key []byte) {
+	r.hashKey = key
+}
+
+// Fields masks fields, omitting those dropped
+func (r *Redactor) Fields(fields []Field) []Field {
+	redacted := make([]Field, 0, len(fields))
+	for _, field := range fields {
+		if strategy, ok := r.keys[normalizeKey(field.Key)]; ok {
+			if strategy == MaskDrop {
+				continue
+			}
+			redacted = append(redacted, Field{Key: field.Key, Value: r.mask(stringify(field.Value), strategy)})
+			continue
+		}
+		redacted = append(redacted, Field{Key: field.Key, Value: r.Value(field.Value)})
+	}
+	return redacted
+}
+
+// Value masks value, walking the structs, slices and maps that may hold values to mask.
+// Those are returned as maps and slices of masked values; other values are returned as is.
+func (r *Redactor) Value(value any) any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return r.String(v)
+	case error:
+		return r.String(v.Error())
+	}
+
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return value
+		}
+		rv = rv.Elem()
+	}
+	if !r.walks(rv.Type()) {
+		return value
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		return r.structValue(rv)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return value
+		}
+		out := make([]any, rv.Len())
+		for i := range out {
+			out[i] = r.Value(rv.Index(i).Interface())
+		}
+		return out
+	case reflect.Map:
+		if rv.IsNil() {
+			return value
+		}
+		return r.mapValue(rv)
+	default:
+		return value
+	}
+}
+
+func (r *Redactor) structValue(rv reflect.Value) map[string]any {
+	t := rv.Type()
+	out := make(map[string]any, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := fieldName(field)
+		if name == "" {
+			continue
+		}
+
+		strategy, sensitive := r.keys[normalizeKey(name)]
+		if tag, ok := field.Tag.Lookup("log"); ok {
+			strategy, sensitive = parseLogTag(tag), true
+		}
+		switch {
+		case !sensitive:
+			out[name] = r.Value(rv.Field(i).Interface())
+		case strategy != MaskDrop:
+			out[name] = r.mask(stringify(rv.Field(i).Interface()), strategy)
+		}
+	}
+	return out
+}
+
+// mapValue masks the entries of map rv whose key is sensitive, and walks the others
+func (r *Redactor) mapValue(rv reflect.Value) map[string]any {
+	out := make(map[string]any, rv.Len())
+	for entries := rv.MapRange(); entries.Next(); {
+		key := stringify(entries.Key().Interface())
+		strategy, sensitive := r.keys[normalizeKey(key)]
+		switch {
+		case !sensitive:
+			out[key] = r.Value(entries.Value().Interface())
+		case strategy != MaskDrop:
+			out[key] = r.mask(stringify(entries.Value().Interface()), strategy)
+		}
+	}
+	return out
+}
+
+// String masks the parts of s matching a registered pattern
+func (r *Redactor) String(s string) string {
+	for _, p := range r.patterns {
+		s = p.re.ReplaceAllStringFunc(s, func(match string) string {
+			if p.valid != nil && !p.valid(match) {
+				return match
+			}
+			if p.strategy == MaskDrop {
+				return Redacted
+			}
+			return r.mask(match, p.strategy)
+		})
+	}
+	return s
+}
+
+// URL returns u with the values of sensitive query parameters masked
+func (r *Redactor) URL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return r.String(u.String())
+	}
+	query := u.Query()
+	for key, values := range query {
+		strategy, ok := r.keys[normalizeKey(key)]
+		if !ok {
+			continue
+		}
+		if strategy == MaskDrop {
+			query.Del(key)
+			continue
+		}
+		for i, value := range values {
+			values[i] = r.mask(value, strategy)
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return r.String(redacted.String())
+}
+
+func (r *Redactor) mask(value string, strategy MaskStrategy) string {
+	switch strategy {
+	case MaskHash:
+		return "sha256:" + r.hash(value)
+	case MaskPartial:
+		n := utf8.RuneCountInString(value)
+		if n <= 4 {
+			return strings.Repeat("*", n)
+		}
+		runes := []rune(value)
+		return strings.Repeat("*", n-4) + string(runes[n-4:])
+	default:
+		return Redacted
+	}
+}
+
+func (r *Redactor) hash(value string) string {
+	var sum []byte
+	if len(r.hashKey) > 0 {
+		mac := hmac.New(sha256.New, r.hashKey)
+		mac.Write([]byte(value))
+		sum = mac.Sum(nil)
+	} else {
+		digest := sha256.Sum256([]byte(value))
+		sum = digest[:]
+	}
+	return hex.EncodeToString(sum[:8])
+}
+
+// parseLogTag returns the strategy of a `log:"redact[,hash|partial|drop]"` or `log:"-"` tag.
+// Fields with an unknown tag are dropped rather than risking a leak.
+func parseLogTag(tag string) MaskStrategy {
+	if tag == "-" {
+		return MaskDrop
+	}
+	name, option, _ := strings.Cut(tag, ",")
+	if name != "redact" {
+		return MaskDrop
+	}
+	switch option {
+	case "", "hash":
+		return MaskHash
+	case "partial":
+		return MaskPartial
+	default:
+		return MaskDrop
+	}
+}
+
+// walks reports whether values of type t may hold values to mask, so that Value walks them: structs with fields
+// tagged `log:"..."` or named after a sensitive key, maps with string keys, slices of strings, and values holding them.
+// Structs holding none are logged as is.
+func (r *Redactor) walks(t reflect.Type) bool {
+	if cached, ok := r.walked.Load(t); ok {
+		return cached.(bool)
+	}
+	walked := r.findSensitive(t, make(map[reflect.Type]bool))
+	if !walked && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		switch t.Elem().Kind() {
+		case reflect.String, reflect.Interface:
+			walked = true
+		}
+	}
+	r.walked.Store(t, walked)
+	return walked
+}
+
+func (r *Redactor) findSensitive(t reflect.Type, visited map[reflect.Type]bool) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return r.findSensitive(t.Elem(), visited)
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String || r.findSensitive(t.Elem(), visited)
+	case reflect.Struct:
+	default:
+		return false
+	}
+
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if _, ok := field.Tag.Lookup("log"); ok {
+			return true
+		}
+		if name := fieldName(field); name != "" {
+			if _, ok := r.keys[normalizeKey(name)]; ok {
+				return true
+			}
+		}
+		if r.findSensitive(field.Type, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldName returns the JSON name of field, or an empty string when it is not serialized
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	default:
+		return name
+	}
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+}
+
+func stringify(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// luhn reports whether the digits of s pass the Luhn check card numbers are built with
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
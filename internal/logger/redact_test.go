@@ -0,0 +1,138 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type buffer struct {
+	bytes.Buffer
+}
+
+func (b *buffer) Close() error { return nil }
+
+type payout struct {
+	Amount  float64 `json:"amount"`
+	Account string  `json:"account" log:"redact,partial"`
+	Code    string  `json:"code" log:"-"`
+	Secret  string  `log:"redact"`
+	Note    string  `json:"note"`
+}
+
+func TestRedactor_Fields(t *testing.T) {
+	r := DefaultRedactor()
+
+	fields := r.Fields([]Field{
+		NewField("Authorization", "Bearer abc.def"),
+		NewField("email", "jane.doe@example.com"),
+		NewField("token", "abc"),
+		NewField("Error", "card 4111 1111 1111 1111 declined for jane.doe@example.com"),
+		NewField("Order", "1234567890123"),
+		NewField("Payout", payout{Amount: 10, Account: "GB33BUKB20201555555555", Code: "123456", Secret: "s3cret", Note: "ok"}),
+	})
+	require.Len(t, fields, 5)
+
+	assert.Equal(t, "****************.com", fields[0].Value)
+	assert.Regexp(t, regexp.MustCompile(`^sha256:[0-9a-f]{16}$`), fields[1].Value)
+	assert.Equal(t, "card ***************1111 declined for ****************.com", fields[2].Value)
+	assert.Equal(t, "1234567890123", fields[3].Value, "digits failing the Luhn check are not card numbers")
+
+	payoutFields := fields[4].Value.(map[string]any)
+	assert.Equal(t, 10.0, payoutFields["amount"])
+	assert.Equal(t, "******************5555", payoutFields["account"])
+	assert.NotContains(t, payoutFields, "code")
+	assert.Regexp(t, regexp.MustCompile(`^sha256:`), payoutFields["Secret"])
+	assert.Equal(t, "ok", payoutFields["note"])
+}
+
+func TestRedactor_Nested(t *testing.T) {
+	r := DefaultRedactor()
+
+	type customer struct {
+		Name    string `json:"name"`
+		Email   string `json:"email"`
+		Country struct {
+			Code string `json:"code"`
+		} `json:"country"`
+	}
+	c := customer{Name: "Jane", Email: "jane.doe@example.com"}
+	c.Country.Code = "GB"
+
+	fields := r.Fields([]Field{
+		NewField("Payouts", []payout{{Amount: 1, Account: "GB33BUKB20201555555555", Code: "123456"}}),
+		NewField("ByID", map[int]*payout{7: {Amount: 2, Secret: "s3cret"}}),
+		NewField("Customer", &c),
+		NewField("Headers", map[string][]string{"Authorization": {"Bearer abc"}, "Accept": {"application/json"}}),
+		NewField("Recipients", []string{"jane.doe@example.com"}),
+		NewField("Codes", map[string]string{"recovery_code": "abcd-efgh", "currency_code": "GBP"}),
+	})
+	require.Len(t, fields, 6)
+
+	payouts := fields[0].Value.([]any)
+	require.Len(t, payouts, 1)
+	assert.Equal(t, "******************5555", payouts[0].(map[string]any)["account"])
+	assert.NotContains(t, payouts[0], "code")
+
+	byID := fields[1].Value.(map[string]any)
+	assert.Regexp(t, regexp.MustCompile(`^sha256:`), byID["7"].(map[string]any)["Secret"])
+
+	// fields of untagged structs are matched by their key, and only the exact sensitive keys are dropped
+	customerFields := fields[2].Value.(map[string]any)
+	assert.Equal(t, "Jane", customerFields["name"])
+	assert.Equal(t, "****************.com", customerFields["email"])
+	assert.Equal(t, c.Country, customerFields["country"])
+
+	headers := fields[3].Value.(map[string]any)
+	assert.NotContains(t, headers, "Authorization")
+	assert.Equal(t, []any{"application/json"}, headers["Accept"])
+
+	assert.Equal(t, []any{"****************.com"}, fields[4].Value)
+	assert.Equal(t, map[string]any{"currency_code": "GBP"}, fields[5].Value)
+
+	// structs holding nothing to mask are logged as is
+	type amount struct{ Value float64 }
+	assert.Equal(t, amount{Value: 1}, r.Value(amount{Value: 1}))
+}
+
+func TestRedactor_HashKey(t *testing.T) {
+	r := NewRedactor()
+	plain := r.mask("123456", MaskHash)
+	r.SetHashKey([]byte("key"))
+	assert.NotEqual(t, plain, r.mask("123456", MaskHash))
+	assert.Equal(t, r.mask("123456", MaskHash), r.mask("123456", MaskHash))
+}
+
+func TestRedactor_URL(t *testing.T) {
+	u, err := url.Parse("/api/v1/transactions?token=abc&api_key=pgk_0123_secret&page=2&email=jane.doe@example.com")
+	require.NoError(t, err)
+
+	redacted, err := url.Parse(DefaultRedactor().URL(u))
+	require.NoError(t, err)
+	query := redacted.Query()
+	assert.Equal(t, "/api/v1/transactions", redacted.Path)
+	assert.Equal(t, "2", query.Get("page"))
+	assert.NotContains(t, query, "api_key")
+	assert.Regexp(t, regexp.MustCompile(`^sha256:`), query.Get("token"))
+	assert.Equal(t, "****************.com", query.Get("email"))
+}
+
+func TestLogger_Redacts(t *testing.T) {
+	out := new(buffer)
+	log, err := NewLogger(&Config{Level: INFO, Destination: out})
+	require.NoError(t, err)
+
+	log.Info("payout", NewField("Payout", &payout{Account: "GB33BUKB20201555555555", Code: "123456"}),
+		NewField("X-API-Key", "pgk_0123456789ab_secret"))
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.NotContains(t, out.String(), "GB33BUKB20201555555555")
+	assert.NotContains(t, out.String(), "123456")
+	assert.NotContains(t, entry, "X-API-Key")
+}
@@ -0,0 +1,379 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RemoteFormat is how RemoteDestination encodes batches of log entries
+type RemoteFormat int8
+
+const (
+
+	// RemoteFormatNDJSON posts entries as newline delimited JSON
+	RemoteFormatNDJSON RemoteFormat = iota
+
+	// RemoteFormatLoki posts entries to the Grafana Loki push API (/loki/api/v1/push) as a single stream
+	RemoteFormatLoki
+)
+
+// DropPolicy is which entries RemoteDestination drops when its buffer is full
+type DropPolicy int8
+
+const (
+	DropNewest DropPolicy = iota
+	DropOldest
+)
+
+var ErrDestinationClosed = errors.New("log destination closed")
+
+type RemoteConfig struct {
+	URL    string
+	Format RemoteFormat
+
+	// Labels identify the Loki stream entries are pushed to, {"service": "payment_gateways"} when empty
+	Labels map[string]string
+
+	// Headers are added to each request, such as an Authorization or X-Scope-OrgID header
+	Headers map[string]string
+
+	// A batch is sent once it holds BatchSize entries or BatchBytes bytes, or FlushInterval after its first entry
+	BatchSize     int
+	BatchBytes    int
+	FlushInterval time.Duration
+
+	// BufferSize is the number of entries held while batches are being sent; entries are dropped beyond it
+	BufferSize int
+	DropPolicy DropPolicy
+
+	// Batches failing with a network error, a 429 or a 5xx status are retried up to MaxRetries times (5 when zero,
+	// none when negative), waiting from MinBackoff up to MaxBackoff
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// CloseTimeout bounds how long Close waits for the entries written so far to be sent, 5 seconds when zero.
+	// Past it, the batch being sent is abandoned and the entries not sent yet are counted as dropped.
+	CloseTimeout time.Duration
+
+	DisableCompression bool
+
+	// OnError is called with errors of batches that could not be sent; they are printed to stderr when nil.
+	// It must not log to the destination it is called by.
+	OnError func(error)
+
+	Client *http.Client
+}
+
+// RemoteStats counts log entries by outcome. Dropped counts the entries dropped because the buffer was full or
+// because Close timed out.
+type RemoteStats struct {
+	Sent    uint64
+	Dropped uint64
+	Failed  uint64
+	Batches uint64
+}
+
+// RemoteDestination sends logs to a remote aggregator, such as Grafana Loki, in batches sent in the background.
+// Write never blocks on the network: when the aggregator cannot keep up, entries are dropped according to
+// RemoteConfig.DropPolicy and counted in Stats.
+type RemoteDestination struct {
+	config RemoteConfig
+
+	// mu guards entries against writes after Close
+	mu      sync.RWMutex
+	closed  bool
+	entries chan remoteEntry
+	done    chan struct{}
+
+	// ctx is cancelled once Close times out, aborting the batch being sent and those left
+	ctx   context.Context
+	abort context.CancelFunc
+
+	sent, dropped, failed, batches atomic.Uint64
+}
+
+type remoteEntry struct {
+	at   time.Time
+	line []byte
+}
+
+func NewRemoteDestination(config RemoteConfig) *RemoteDestination {
+	if len(config.Labels) == 0 {
+		config.Labels = map[string]string{"service": "payment_gateways"}
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = 1 << 20
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 10000
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 5
+	} else if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 10 * time.Second
+	}
+	if config.CloseTimeout <= 0 {
+		config.CloseTimeout = 5 * time.Second
+	}
+	if config.OnError == nil {
+		config.OnError = func(err error) {
+			fmt.Fprintf(os.Stderr, "remote log destination: %s\n", err)
+		}
+	}
+	if config.Client == nil {
+
+		// Create a custom HTTP client with a Transport that reuses connections
+		config.Client = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConns:        10,               // Maximum idle connections across all hosts
+				MaxIdleConnsPerHost: 5,                // Max idle connections to a single host
+				IdleConnTimeout:     90 * time.Second, // Keep-alive timeout
+			},
+		}
+	}
+
+	r := &RemoteDestination{
+		config:  config,
+		entries: make(chan remoteEntry, config.BufferSize),
+		done:    make(chan struct{}),
+	}
+	r.ctx, r.abort = context.WithCancel(context.Background())
+	go r.run()
+	return r
+}
+
+// Write queues log entry p to be sent with the next batch
+func (r *RemoteDestination) Write(p []byte) (n int, err error) {
+	// the caller may reuse p once Write returns
+	entry := remoteEntry{at: time.Now(), line: bytes.TrimRight(bytes.Clone(p), "\n")}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return 0, ErrDestinationClosed
+	}
+
+	select {
+	case r.entries <- entry:
+		return len(p), nil
+	default:
+	}
+
+	if r.config.DropPolicy == DropOldest {
+		select {
+		case <-r.entries:
+			r.dropped.Add(1)
+		default:
+		}
+		select {
+		case r.entries <- entry:
+			return len(p), nil
+		default:
+		}
+	}
+	r.dropped.Add(1)
+	return len(p), nil
+}
+
+// Close sends the entries written so far and stops the destination.
+// Entries that could not be sent within RemoteConfig.CloseTimeout are dropped.
+func (r *RemoteDestination) Close() error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.entries)
+	}
+	r.mu.Unlock()
+
+	timer := time.NewTimer(r.config.CloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-r.done:
+	case <-timer.C:
+		r.abort()
+		<-r.done
+	}
+	return nil
+}
+
+// Stats returns the number of entries sent, dropped without being sent, and failed to be sent
+func (r *RemoteDestination) Stats() RemoteStats {
+	return RemoteStats{
+		Sent:    r.sent.Load(),
+		Dropped: r.dropped.Load(),
+		Failed:  r.failed.Load(),
+		Batches: r.batches.Load(),
+	}
+}
+
+func (r *RemoteDestination) run() {
+	defer close(r.done)
+
+	var batch []remoteEntry
+	size := 0
+	timer := time.NewTimer(r.config.FlushInterval)
+	timer.Stop()
+
+	flush := func() {
+		if len(batch) > 0 {
+			r.send(batch)
+		}
+		batch, size = nil, 0
+		timer.Stop()
+	}
+
+	for {
+		select {
+		case entry, ok := <-r.entries:
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(r.config.FlushInterval)
+			}
+			batch = append(batch, entry)
+			size += len(entry.line)
+			if len(batch) >= r.config.BatchSize || size >= r.config.BatchBytes {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// send posts batch, retrying with exponential backoff until Close times out
+func (r *RemoteDestination) send(batch []remoteEntry) {
+	if r.ctx.Err() != nil {
+		r.dropped.Add(uint64(len(batch)))
+		return
+	}
+
+	body, contentType, err := r.encode(batch)
+	if err != nil {
+		r.failed.Add(uint64(len(batch)))
+		r.config.OnError(fmt.Errorf("error encoding %d entries: %w", len(batch), err))
+		return
+	}
+
+	backoff := r.config.MinBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := r.post(body, contentType)
+		if err == nil {
+			r.sent.Add(uint64(len(batch)))
+			r.batches.Add(1)
+			return
+		}
+		if r.ctx.Err() != nil {
+			r.dropped.Add(uint64(len(batch)))
+			return
+		}
+		if !retryable || attempt >= r.config.MaxRetries {
+			r.failed.Add(uint64(len(batch)))
+			r.config.OnError(fmt.Errorf("dropping %d entries after %d attempts: %w", len(batch), attempt+1, err))
+			return
+		}
+
+		// jitter spreads the retries of instances recovering from the same outage
+		wait := time.NewTimer(time.Duration(rand.Int63n(int64(backoff))) + backoff/2)
+		select {
+		case <-wait.C:
+		case <-r.ctx.Done():
+			wait.Stop()
+		}
+		backoff = min(backoff*2, r.config.MaxBackoff)
+	}
+}
+
+// post sends body, reporting whether a failure may succeed when retried
+func (r *RemoteDestination) post(body []byte, contentType string) (bool, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodPost, r.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if !r.config.DisableCompression {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for key, value := range r.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := r.config.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("remote log destination responded with status %d", resp.StatusCode)
+}
+
+func (r *RemoteDestination) encode(batch []remoteEntry) ([]byte, string, error) {
+	var raw bytes.Buffer
+	contentType := "application/x-ndjson"
+
+	switch r.config.Format {
+	case RemoteFormatLoki:
+		contentType = "application/json"
+		values := make([][2]string, len(batch))
+		for i, entry := range batch {
+			values[i] = [2]string{strconv.FormatInt(entry.at.UnixNano(), 10), string(entry.line)}
+		}
+		push := map[string]any{
+			"streams": []map[string]any{{"stream": r.config.Labels, "values": values}},
+		}
+		if err := json.NewEncoder(&raw).Encode(push); err != nil {
+			return nil, "", err
+		}
+	default:
+		for _, entry := range batch {
+			raw.Write(entry.line)
+			raw.WriteByte('\n')
+		}
+	}
+
+	if r.config.DisableCompression {
+		return raw.Bytes(), contentType, nil
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(raw.Bytes()); err != nil {
+		return nil, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", err
+	}
+	return compressed.Bytes(), contentType, nil
+}
@@ -0,0 +1,163 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collector is a log aggregator recording the entries of each batch it accepts
+type collector struct {
+	mu       sync.Mutex
+	batches  [][]string
+	failures atomic.Int32
+}
+
+func (c *collector) handler(t *testing.T, loki bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.failures.Add(-1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		require.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		body, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+
+		var lines []string
+		if loki {
+			var push struct {
+				Streams []struct {
+					Stream map[string]string `json:"stream"`
+					Values [][2]string       `json:"values"`
+				} `json:"streams"`
+			}
+			require.NoError(t, json.NewDecoder(body).Decode(&push))
+			require.Len(t, push.Streams, 1)
+			assert.Equal(t, "payment_gateways", push.Streams[0].Stream["service"])
+			for _, value := range push.Streams[0].Values {
+				lines = append(lines, value[1])
+			}
+		} else {
+			scanner := bufio.NewScanner(body)
+			for scanner.Scan() {
+				lines = append(lines, scanner.Text())
+			}
+		}
+
+		c.mu.Lock()
+		c.batches = append(c.batches, lines)
+		c.mu.Unlock()
+	}
+}
+
+func TestRemoteDestination_Batches(t *testing.T) {
+	c := new(collector)
+	server := httptest.NewServer(c.handler(t, false))
+	defer server.Close()
+
+	r := NewRemoteDestination(RemoteConfig{URL: server.URL, BatchSize: 2, FlushInterval: time.Hour})
+	for _, line := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		_, err := io.WriteString(r, line+"\n")
+		require.NoError(t, err)
+	}
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, [][]string{{`{"n":1}`, `{"n":2}`}, {`{"n":3}`}}, c.batches)
+	assert.Equal(t, RemoteStats{Sent: 3, Batches: 2}, r.Stats())
+
+	_, err := r.Write([]byte("late"))
+	assert.ErrorIs(t, err, ErrDestinationClosed)
+}
+
+func TestRemoteDestination_LokiRetries(t *testing.T) {
+	c := new(collector)
+	c.failures.Store(2)
+	server := httptest.NewServer(c.handler(t, true))
+	defer server.Close()
+
+	r := NewRemoteDestination(RemoteConfig{
+		URL: server.URL, Format: RemoteFormatLoki, FlushInterval: 10 * time.Millisecond, MinBackoff: time.Millisecond,
+	})
+	_, err := r.Write([]byte(`{"msg":"retried"}`))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, [][]string{{`{"msg":"retried"}`}}, c.batches)
+	assert.Equal(t, uint64(1), r.Stats().Sent)
+}
+
+func TestRemoteDestination_Failures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	var errs []error
+	r := NewRemoteDestination(RemoteConfig{URL: server.URL, OnError: func(err error) { errs = append(errs, err) }})
+	_, err := r.Write([]byte(`{"msg":"rejected"}`))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	// client errors are not retried
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "after 1 attempts")
+	assert.Equal(t, RemoteStats{Failed: 1}, r.Stats())
+}
+
+func TestRemoteDestination_DropPolicy(t *testing.T) {
+	release := make(chan struct{})
+	c := new(collector)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		c.handler(t, false)(w, r)
+	}))
+	defer server.Close()
+
+	r := NewRemoteDestination(RemoteConfig{URL: server.URL, BatchSize: 1, BufferSize: 2, DropPolicy: DropOldest})
+	_, _ = r.Write([]byte("1"))
+
+	// wait for the first entry to be in flight, blocking the sender
+	require.Eventually(t, func() bool { return len(r.entries) == 0 }, time.Second, time.Millisecond)
+	for _, line := range []string{"2", "3", "4"} {
+		_, _ = r.Write([]byte(line))
+	}
+	close(release)
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, [][]string{{"1"}, {"3"}, {"4"}}, c.batches)
+	assert.Equal(t, RemoteStats{Sent: 3, Dropped: 1, Batches: 3}, r.Stats())
+}
+
+func TestRemoteDestination_CloseTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	var errs []error
+	r := NewRemoteDestination(RemoteConfig{
+		URL: server.URL, BatchSize: 2, MinBackoff: time.Minute, CloseTimeout: 50 * time.Millisecond,
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+	for _, line := range []string{"1", "2", "3"} {
+		_, err := r.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	// the retries of the first batch are abandoned, and neither batch is sent
+	start := time.Now()
+	require.NoError(t, r.Close())
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Empty(t, errs)
+	assert.Equal(t, RemoteStats{Dropped: 3}, r.Stats())
+}
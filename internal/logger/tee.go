@@ -0,0 +1,187 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// TeeSink is a destination of a TeeDestination, receiving entries of Level or above
+type TeeSink struct {
+	Name        string
+	Destination io.WriteCloser
+	Level       Level
+}
+
+// TeeDestination writes logs to several destinations, each filtering entries by level,
+// for example the console at INFO, a file at DEBUG and a remote aggregator at ERROR.
+// Sinks and their levels can be changed while logging.
+type TeeDestination struct {
+
+	// mu serializes changes; writers read sinks without locking
+	mu    sync.Mutex
+	sinks atomic.Pointer[[]*teeSink]
+}
+
+type teeSink struct {
+	name  string
+	w     io.WriteCloser
+	level atomic.Int32
+}
+
+func NewTeeDestination(sinks ...TeeSink) (*TeeDestination, error) {
+	t := &TeeDestination{}
+	t.sinks.Store(new([]*teeSink))
+	for _, sink := range sinks {
+		if err := t.AddSink(sink); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// AddSink starts writing entries to sink
+func (t *TeeDestination) AddSink(sink TeeSink) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := *t.sinks.Load()
+	for _, s := range current {
+		if s.name == sink.Name {
+			return fmt.Errorf("log sink %q already exists", sink.Name)
+		}
+	}
+	added := &teeSink{name: sink.Name, w: sink.Destination}
+	added.level.Store(int32(sink.Level))
+
+	sinks := append(current[:len(current):len(current)], added)
+	t.sinks.Store(&sinks)
+	return nil
+}
+
+// RemoveSink stops writing entries to the sink named name and closes it
+func (t *TeeDestination) RemoveSink(name string) error {
+	t.mu.Lock()
+	current := *t.sinks.Load()
+	sinks := make([]*teeSink, 0, len(current))
+	var removed *teeSink
+	for _, s := range current {
+		if s.name == name {
+			removed = s
+			continue
+		}
+		sinks = append(sinks, s)
+	}
+	t.sinks.Store(&sinks)
+	t.mu.Unlock()
+
+	if removed == nil {
+		return fmt.Errorf("unknown log sink %q", name)
+	}
+	return removed.w.Close()
+}
+
+// SetLevel makes the sink named name only receive entries of level or above
+func (t *TeeDestination) SetLevel(name string, level Level) error {
+	for _, s := range *t.sinks.Load() {
+		if s.name == name {
+			s.level.Store(int32(level))
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log sink %q", name)
+}
+
+// Levels returns the level of each sink by name
+func (t *TeeDestination) Levels() map[string]Level {
+	levels := make(map[string]Level)
+	for _, s := range *t.sinks.Load() {
+		levels[s.name] = Level(s.level.Load())
+	}
+	return levels
+}
+
+// Write writes p to every sink, regardless of their level since p's is unknown
+func (t *TeeDestination) Write(p []byte) (n int, err error) {
+	return t.write(p, nil)
+}
+
+// write writes p to the sinks accepting level, or to every sink when level is nil
+func (t *TeeDestination) write(p []byte, level *Level) (int, error) {
+	var errs []error
+	for _, s := range *t.sinks.Load() {
+		if level != nil && *level < Level(s.level.Load()) {
+			continue
+		}
+		if _, err := s.w.Write(p); err != nil {
+			errs = append(errs, fmt.Errorf("log sink %s: %w", s.name, err))
+		}
+	}
+	return len(p), errors.Join(errs...)
+}
+
+// enabled reports whether a sink accepts entries of level
+func (t *TeeDestination) enabled(level Level) bool {
+	for _, s := range *t.sinks.Load() {
+		if level >= Level(s.level.Load()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes every sink
+func (t *TeeDestination) Close() error {
+	var errs []error
+	for _, s := range *t.sinks.Load() {
+		if err := s.w.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("log sink %s: %w", s.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// teeCore is a zapcore.Core encoding each entry once, then writing it to the sinks of a TeeDestination accepting its level
+type teeCore struct {
+	zapcore.LevelEnabler
+	encoder zapcore.Encoder
+	tee     *TeeDestination
+}
+
+func (c *teeCore) Enabled(level zapcore.Level) bool {
+	return c.LevelEnabler.Enabled(level) && c.tee.enabled(Level(level))
+}
+
+func (c *teeCore) With(fields []zapcore.Field) zapcore.Core {
+	encoder := c.encoder.Clone()
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+	return &teeCore{LevelEnabler: c.LevelEnabler, encoder: encoder, tee: c.tee}
+}
+
+func (c *teeCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *teeCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	level := Level(entry.Level)
+	_, err = c.tee.write(buf.Bytes(), &level)
+	return err
+}
+
+func (c *teeCore) Sync() error {
+	return nil
+}
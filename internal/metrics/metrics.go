@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "payment_gateways"
+
+// Outcomes of calls to dependencies
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+
+	// OutcomeRejected is a call not attempted because a circuit breaker is open
+	OutcomeRejected = "rejected"
+)
+
+// Outcomes of lock acquisitions
+const (
+	LockAcquired = "acquired"
+
+	// LockContended is a lock held by another request for longer than the acquisition retries lasted
+	LockContended = "contended"
+	LockError     = "error"
+)
+
+// Results of cache lookups
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheError = "error"
+)
+
+// Registry holds the metrics of this service, along with Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "http", Name: "requests_total",
+		Help: "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "http", Name: "request_duration_seconds",
+		Help:    "Latency of HTTP requests by method and route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	gatewayCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "gateway", Name: "calls_total",
+		Help: "Calls to payment gateways by gateway, operation and outcome.",
+	}, []string{"gateway", "operation", "outcome"})
+
+	gatewayCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "gateway", Name: "call_duration_seconds",
+		Help:    "Latency of calls to payment gateways by gateway and operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"gateway", "operation"})
+
+	transactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "transactions_total",
+		Help: "Deposits and withdrawals reaching a status, by type, status, currency and country.",
+	}, []string{"type", "status", "currency", "country"})
+
+	transactionAmounts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "transaction_amount_total",
+		Help: "Sum of the amounts of deposits and withdrawals reaching a status, by type, status, currency and country.",
+	}, []string{"type", "status", "currency", "country"})
+
+	rateLimitRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "rate_limit_rejections_total",
+		Help: "Requests rejected by the rate limiter.",
+	})
+
+	lockAcquisitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "lock", Name: "acquisitions_total",
+		Help: "Attempts to acquire distributed locks by outcome.",
+	}, []string{"outcome"})
+
+	lockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "lock", Name: "wait_seconds",
+		Help:    "Time spent acquiring distributed locks, or failing to.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache", Name: "lookups_total",
+		Help: "Cache lookups by result; the hit ratio is the rate of hits over the rate of hits and misses.",
+	}, []string{"result"})
+
+	kafkaPublishes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "kafka", Name: "publishes_total",
+		Help: "Transaction events published to Kafka by event type and outcome.",
+	}, []string{"event_type", "outcome"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpRequestDuration,
+		gatewayCalls, gatewayCallDuration,
+		transactions, transactionAmounts,
+		rateLimitRejections,
+		lockAcquisitions, lockWait,
+		cacheLookups,
+		kafkaPublishes,
+	)
+}
+
+// Handler serves the metrics of Registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Middleware records the rate, errors and duration of requests by route pattern
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		// labelling by pattern rather than path keeps the number of series bounded
+		route := "unmatched"
+		if routeCtx := chi.RouteContext(r.Context()); routeCtx != nil && routeCtx.RoutePattern() != "" {
+			route = routeCtx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveGatewayCall records a call to operation of gateway that lasted duration and failed when err is not nil
+func ObserveGatewayCall(gateway, operation string, duration time.Duration, err error) {
+	gatewayCalls.WithLabelValues(gateway, operation, outcome(err)).Inc()
+	gatewayCallDuration.WithLabelValues(gateway, operation).Observe(duration.Seconds())
+}
+
+// ObserveTransaction records a deposit or withdrawal of amount reaching status
+func ObserveTransaction(trxType, status, currency, country string, amount float64) {
+	transactions.WithLabelValues(trxType, status, currency, country).Inc()
+	transactionAmounts.WithLabelValues(trxType, status, currency, country).Add(amount)
+}
+
+// RateLimitRejected records a request rejected by the rate limiter
+func RateLimitRejected() {
+	rateLimitRejections.Inc()
+}
+
+// ObserveLockAcquisition records an attempt to acquire a lock with outcome, one of LockAcquired, LockContended or LockError
+func ObserveLockAcquisition(outcome string, wait time.Duration) {
+	lockAcquisitions.WithLabelValues(outcome).Inc()
+	lockWait.Observe(wait.Seconds())
+}
+
+// ObserveCacheLookup records a cache lookup with result, one of CacheHit, CacheMiss or CacheError
+func ObserveCacheLookup(result string) {
+	cacheLookups.WithLabelValues(result).Inc()
+}
+
+// ObserveKafkaPublish records the publish of an event of eventType with outcome,
+// one of OutcomeSuccess, OutcomeError or OutcomeRejected
+func ObserveKafkaPublish(eventType, outcome string) {
+	kafkaPublishes.WithLabelValues(eventType, outcome).Inc()
+}
+
+// RegisterCircuitBreaker exposes the state of the circuit breaker named name, as returned by state:
+// 0 when closed, 1 when half-open and 2 when open
+func RegisterCircuitBreaker(name string, state func() float64) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "circuit_breaker", Name: "state",
+		Help:        "State of circuit breakers: 0 when closed, 1 when half-open and 2 when open.",
+		ConstLabels: prometheus.Labels{"name": name},
+	}, state))
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
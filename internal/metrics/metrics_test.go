@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(Middleware)
+	mux.Get("/transactions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "id") == "0" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	for _, path := range []string{"/transactions/1", "/transactions/2", "/transactions/0", "/unknown"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// requests are counted by pattern rather than path
+	assert.Equal(t, 2.0, testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/transactions/{id}", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/transactions/{id}", "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "unmatched", "404")))
+}
+
+func TestHandler(t *testing.T) {
+	ObserveGatewayCall("stripe", "RegisterWithdrawal", 20*time.Millisecond, nil)
+	ObserveGatewayCall("stripe", "RegisterWithdrawal", time.Second, errors.New("timeout"))
+	ObserveTransaction("deposit", "success", "EUR", "Germany", 100)
+	ObserveTransaction("deposit", "success", "EUR", "Germany", 50.5)
+	ObserveKafkaPublish("deposit.initiated", OutcomeRejected)
+	RegisterCircuitBreaker("test", func() float64 { return 2 })
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	body := rr.Body.String()
+	for _, line := range []string{
+		`payment_gateways_gateway_calls_total{gateway="stripe",operation="RegisterWithdrawal",outcome="error"} 1`,
+		`payment_gateways_gateway_calls_total{gateway="stripe",operation="RegisterWithdrawal",outcome="success"} 1`,
+		`payment_gateways_gateway_call_duration_seconds_count{gateway="stripe",operation="RegisterWithdrawal"} 2`,
+		`payment_gateways_transactions_total{country="Germany",currency="EUR",status="success",type="deposit"} 2`,
+		`payment_gateways_transaction_amount_total{country="Germany",currency="EUR",status="success",type="deposit"} 150.5`,
+		`payment_gateways_kafka_publishes_total{event_type="deposit.initiated",outcome="rejected"} 1`,
+		`payment_gateways_circuit_breaker_state{name="test"} 2`,
+		`go_goroutines`,
+	} {
+		assert.True(t, strings.Contains(body, line), "missing %s", line)
+	}
+}
@@ -0,0 +1,234 @@
+// Package mfa implements the second factor users authenticate sensitive operations, such as withdrawals, with:
+// time-based one-time passwords (RFC 6238) generated by an authenticator app, and single-use recovery codes.
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/services"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotEnrolled      = errors.New("two-factor authentication is not set up")
+	ErrAlreadyConfirmed = errors.New("two-factor authentication is already set up")
+	ErrInvalidCode      = errors.New("incorrect authentication code")
+	ErrLockedOut        = errors.New("too many incorrect authentication codes")
+)
+
+// Store persists TOTP authenticators and recovery codes
+type Store interface {
+	SaveTOTPEnrollment(userID int, encryptedSecret []byte) error
+	GetUserTOTP(userID int) (db.UserTOTP, error)
+	ConfirmTOTP(userID int, step int64, recoveryCodeHashes []string) error
+	RecordTOTPSuccess(userID int, step int64) (bool, error)
+	RecordTOTPFailure(userID int, maxFailures int, lockout time.Duration) error
+	UseTOTPRecoveryCode(userID int, codeHash string) (bool, error)
+}
+
+type Config struct {
+
+	// Issuer names the service in authenticator apps
+	Issuer string
+
+	// Drift is the number of time steps a code may be early or late by
+	Drift int
+
+	// MaxFailures is the number of consecutive incorrect codes after which verification is locked for Lockout
+	MaxFailures int
+	Lockout     time.Duration
+
+	// RecoveryCodes is the number of recovery codes issued on confirmation
+	RecoveryCodes int
+}
+
+// DefaultConfig tolerates one time step of drift and locks verification for 15 minutes after 5 failures
+var DefaultConfig = Config{
+	Issuer:        "Payment Gateways",
+	Drift:         1,
+	MaxFailures:   5,
+	Lockout:       15 * time.Minute,
+	RecoveryCodes: 10,
+}
+
+// Enrollment is the secret a user adds to their authenticator app
+type Enrollment struct {
+	Secret string `json:"secret" xml:"secret" log:"-"`
+	URI    string `json:"otpauth_uri" xml:"otpauth_uri" log:"-"`
+}
+
+// TOTP enrolls users in and verifies time-based one-time passwords
+type TOTP struct {
+	store  Store
+	config Config
+	now    func() time.Time
+}
+
+func NewTOTP(store Store, config Config) *TOTP {
+	return &TOTP{store: store, config: config, now: time.Now}
+}
+
+// Enroll generates a new secret for userID, labelled account in authenticator apps.
+// The secret is only used once confirmed. Enrolling again before confirming replaces the secret.
+func (t *TOTP) Enroll(userID int, account string) (Enrollment, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return Enrollment{}, fmt.Errorf("error generating TOTP secret: %w", err)
+	}
+	encrypted, err := services.MaskData(secret)
+	if err != nil {
+		return Enrollment{}, fmt.Errorf("error encrypting TOTP secret: %w", err)
+	}
+
+	if err = t.store.SaveTOTPEnrollment(userID, encrypted); err != nil {
+		if errors.Is(err, db.ErrDataConflict) {
+			return Enrollment{}, ErrAlreadyConfirmed
+		}
+		return Enrollment{}, err
+	}
+
+	return Enrollment{
+		Secret: secretEncoding.EncodeToString(secret),
+		URI:    otpauthURI(t.config.Issuer, account, secret),
+	}, nil
+}
+
+// Confirm activates the secret enrolled by userID once code proves the authenticator app is set up,
+// and returns the recovery codes usable in place of a code when the app is lost
+func (t *TOTP) Confirm(userID int, code string) ([]string, error) {
+	totp, err := t.store.GetUserTOTP(userID)
+	if err != nil {
+		if errors.Is(err, db.ErrDataNotFound) {
+			return nil, ErrNotEnrolled
+		}
+		return nil, err
+	}
+	if totp.ConfirmedAt != nil {
+		return nil, ErrAlreadyConfirmed
+	}
+
+	step, err := t.check(totp, normalize(code))
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes(t.config.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.store.ConfirmTOTP(userID, step, hashes); err != nil {
+		if errors.Is(err, db.ErrDataConflict) {
+			return nil, ErrInvalidCode
+		}
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks code, either a TOTP code or an unused recovery code, against the confirmed authenticator of userID.
+// Each TOTP code is accepted once, and verification is locked after Config.MaxFailures consecutive failures.
+func (t *TOTP) Verify(userID int, code string) error {
+	totp, err := t.store.GetUserTOTP(userID)
+	if err != nil {
+		if errors.Is(err, db.ErrDataNotFound) {
+			return ErrNotEnrolled
+		}
+		return err
+	}
+	if totp.ConfirmedAt == nil {
+		return ErrNotEnrolled
+	}
+
+	code = normalize(code)
+	if isRecoveryCode(code) {
+		if totp.Locked {
+			return ErrLockedOut
+		}
+		used, err := t.store.UseTOTPRecoveryCode(userID, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+		if !used {
+			return t.fail(userID)
+		}
+		return nil
+	}
+
+	step, err := t.check(totp, code)
+	if err != nil {
+		return err
+	}
+	accepted, err := t.store.RecordTOTPSuccess(userID, step)
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		// replay of a code accepted concurrently
+		return t.fail(userID)
+	}
+	return nil
+}
+
+// check returns the time step of code if it is a valid, unused code of totp
+func (t *TOTP) check(totp db.UserTOTP, code string) (int64, error) {
+	if totp.Locked {
+		return 0, ErrLockedOut
+	}
+
+	var secret []byte
+	if err := services.UnmaskData(string(totp.Secret), &secret); err != nil {
+		return 0, fmt.Errorf("error decrypting TOTP secret: %w", err)
+	}
+
+	step, ok := matchTOTP(secret, code, t.now(), t.config.Drift)
+	if !ok || step <= totp.LastUsedStep {
+		return 0, t.fail(totp.UserID)
+	}
+	return step, nil
+}
+
+// fail records a failed verification and returns the error to report it with
+func (t *TOTP) fail(userID int) error {
+	if err := t.store.RecordTOTPFailure(userID, t.config.MaxFailures, t.config.Lockout); err != nil {
+		return err
+	}
+	return ErrInvalidCode
+}
+
+// recoveryCodeEncoding uses the Crockford alphabet, which avoids characters easily confused when copied by hand
+var recoveryCodeEncoding = base32.NewEncoding("0123456789abcdefghjkmnpqrstvwxyz").WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns n recovery codes of the form xxxxx-xxxxx and their hashes
+func generateRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("error generating recovery code: %w", err)
+		}
+		encoded := recoveryCodeEncoding.EncodeToString(raw)[:10]
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func isRecoveryCode(code string) bool {
+	return len(code) == 11 && code[5] == '-'
+}
+
+func normalize(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
@@ -0,0 +1,157 @@
+package mfa
+
+import (
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	services.InitEncryptionKey("0123456789abcdef")
+	os.Exit(m.Run())
+}
+
+// memoryStore is an in-memory Store for a single user
+type memoryStore struct {
+	totp          *db.UserTOTP
+	recoveryCodes map[string]bool
+
+	// now stands for the database clock, time.Now when nil
+	now func() time.Time
+}
+
+func (s *memoryStore) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+func (s *memoryStore) SaveTOTPEnrollment(userID int, encryptedSecret []byte) error {
+	if s.totp != nil && s.totp.ConfirmedAt != nil {
+		return db.ErrDataConflict
+	}
+	s.totp = &db.UserTOTP{UserID: userID, Secret: encryptedSecret}
+	return nil
+}
+
+func (s *memoryStore) GetUserTOTP(int) (db.UserTOTP, error) {
+	if s.totp == nil {
+		return db.UserTOTP{}, db.ErrDataNotFound
+	}
+	totp := *s.totp
+	totp.Locked = totp.LockedUntil != nil && s.clock().Before(*totp.LockedUntil)
+	return totp, nil
+}
+
+func (s *memoryStore) ConfirmTOTP(_ int, step int64, hashes []string) error {
+	now := time.Now()
+	s.totp.ConfirmedAt = &now
+	s.totp.LastUsedStep = step
+	s.recoveryCodes = make(map[string]bool)
+	for _, hash := range hashes {
+		s.recoveryCodes[hash] = false
+	}
+	return nil
+}
+
+func (s *memoryStore) RecordTOTPSuccess(_ int, step int64) (bool, error) {
+	if step <= s.totp.LastUsedStep {
+		return false, nil
+	}
+	s.totp.LastUsedStep = step
+	s.totp.FailedAttempts = 0
+	return true, nil
+}
+
+func (s *memoryStore) RecordTOTPFailure(_ int, maxFailures int, lockout time.Duration) error {
+	s.totp.FailedAttempts++
+	if s.totp.FailedAttempts >= maxFailures {
+		lockedUntil := s.clock().Add(lockout)
+		s.totp.LockedUntil = &lockedUntil
+		s.totp.FailedAttempts = 0
+	}
+	return nil
+}
+
+func (s *memoryStore) UseTOTPRecoveryCode(_ int, hash string) (bool, error) {
+	used, ok := s.recoveryCodes[hash]
+	if !ok || used {
+		return false, nil
+	}
+	s.recoveryCodes[hash] = true
+	return true, nil
+}
+
+func TestHOTP_RFC6238Vectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	// RFC 6238 appendix B SHA-1 vectors, truncated to 6 digits
+	assert.Equal(t, "287082", totpCode(secret, time.Unix(59, 0)))
+	assert.Equal(t, "081804", totpCode(secret, time.Unix(1111111109, 0)))
+	assert.Equal(t, "005924", totpCode(secret, time.Unix(1234567890, 0)))
+}
+
+func TestTOTP_EnrollConfirmVerify(t *testing.T) {
+	store := &memoryStore{}
+	now := time.Now()
+	totp := NewTOTP(store, DefaultConfig)
+	totp.now = func() time.Time { return now }
+
+	enrollment, err := totp.Enroll(1, "alice")
+	require.NoError(t, err)
+	uri, err := url.Parse(enrollment.URI)
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+
+	secret, err := secretEncoding.DecodeString(enrollment.Secret)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, totp.Verify(1, totpCode(secret, now)), ErrNotEnrolled)
+
+	recoveryCodes, err := totp.Confirm(1, totpCode(secret, now.Add(-totpPeriod)))
+	require.NoError(t, err)
+	assert.Len(t, recoveryCodes, DefaultConfig.RecoveryCodes)
+
+	_, err = totp.Enroll(1, "alice")
+	assert.ErrorIs(t, err, ErrAlreadyConfirmed)
+
+	// a code within the drift window is accepted once
+	code := totpCode(secret, now.Add(totpPeriod))
+	require.NoError(t, totp.Verify(1, code))
+	assert.ErrorIs(t, totp.Verify(1, code), ErrInvalidCode)
+
+	// recovery codes are single use
+	require.NoError(t, totp.Verify(1, recoveryCodes[0]))
+	assert.ErrorIs(t, totp.Verify(1, recoveryCodes[0]), ErrInvalidCode)
+}
+
+func TestTOTP_Lockout(t *testing.T) {
+	// the lockout is judged by the database clock, which lags behind this one
+	now := time.Now()
+	store := &memoryStore{now: func() time.Time { return now.Add(-time.Hour) }}
+	totp := NewTOTP(store, DefaultConfig)
+	totp.now = func() time.Time { return now }
+
+	enrollment, err := totp.Enroll(1, "alice")
+	require.NoError(t, err)
+	secret, err := secretEncoding.DecodeString(enrollment.Secret)
+	require.NoError(t, err)
+	_, err = totp.Confirm(1, totpCode(secret, now.Add(-totpPeriod)))
+	require.NoError(t, err)
+
+	for i := 0; i < DefaultConfig.MaxFailures; i++ {
+		assert.ErrorIs(t, totp.Verify(1, "000000"), ErrInvalidCode)
+	}
+	assert.ErrorIs(t, totp.Verify(1, totpCode(secret, now)), ErrLockedOut)
+
+	now = now.Add(DefaultConfig.Lockout + time.Minute)
+	assert.NoError(t, totp.Verify(1, totpCode(secret, now)))
+}
@@ -0,0 +1,97 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// TOTP parameters (RFC 6238), using the defaults authenticator apps support universally
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+
+	// secretSize is the secret length recommended by RFC 4226
+	secretSize = 20
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func generateSecret() ([]byte, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// timeStep returns the TOTP time step t falls in
+func timeStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// hotp computes the HOTP value (RFC 4226) of secret for counter
+func hotp(secret []byte, counter int64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+// totpCode returns the code of secret at t
+func totpCode(secret []byte, t time.Time) string {
+	return hotp(secret, timeStep(t))
+}
+
+// matchTOTP returns the time step code is valid for, accepting codes of up to drift steps before or after t
+// to tolerate clock skew between the server and the authenticator app
+func matchTOTP(secret []byte, code string, t time.Time, drift int) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := timeStep(t)
+	for offset := -int64(drift); offset <= int64(drift); offset++ {
+		step := current + offset
+		if subtle.ConstantTimeCompare([]byte(hotp(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// otpauthURI returns the key URI authenticator apps import secret from, usually rendered as a QR code
+func otpauthURI(issuer, account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", secretEncoding.EncodeToString(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return uri.String()
+}
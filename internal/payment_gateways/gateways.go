@@ -1,8 +1,11 @@
 package gateways
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/vault"
 )
 
 var (
@@ -18,7 +21,9 @@ type PaymentGateway interface {
 	// Name must be a unique name corresponding with the PaymentGateway name as saved in DB
 	Name() string
 	GenerateDepositCheckoutSessionData(trx db.Transaction, callbackUrl string) (sessionData any, err error)
-	RegisterWithdrawal(trx db.Transaction, callbackUrl, receivingAccount string) error
+
+	// RegisterWithdrawal registers trx, to be paid to the account receivingAccountToken stands for in the vault
+	RegisterWithdrawal(trx db.Transaction, callbackUrl, receivingAccountToken string) error
 
 	// CheckAvailability sends a liveness probe to this PaymentGateway
 	CheckAvailability() error
@@ -28,13 +33,59 @@ func GlobalDefault() PaymentGateway {
 	return new(Stripe)
 }
 
-func PaymentGatewayFromName(name string) (PaymentGateway, error) {
+// PaymentGatewayFromName returns the gateway named name, reading the receiving accounts of withdrawals from
+// detokenizer. It may be nil for gateways that do not register withdrawals.
+func PaymentGatewayFromName(name string, detokenizer vault.Detokenizer) (PaymentGateway, error) {
 	switch name {
 	case "stripe":
-		return new(Stripe), nil
+		return &Stripe{accounts: accounts{detokenizer}}, nil
 	case "paypal":
-		return new(PayPal), nil
+		return &PayPal{accounts: accounts{detokenizer}}, nil
 	default:
 		return nil, ErrUnknownPaymentGateway
 	}
 }
+
+// AvailabilityCheck returns a health check succeeding when at least one of the gateways listed by lister is available
+func AvailabilityCheck(lister interface{ GetGateways() ([]db.Gateway, error) }) func(context.Context) error {
+	return func(ctx context.Context) error {
+		list, err := lister.GetGateways()
+		if err != nil {
+			return err
+		}
+		var errs []error
+		for _, gateway := range list {
+			impl, err := PaymentGatewayFromName(gateway.Name, nil)
+			if err != nil {
+				continue
+			}
+			if err = Instrument(ctx, impl).CheckAvailability(); err == nil {
+				return nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", gateway.Name, err))
+		}
+		if len(errs) == 0 {
+			return errors.New("no payment gateway configured")
+		}
+		return fmt.Errorf("%w: %w", ErrPaymentGatewayNotResponding, errors.Join(errs...))
+	}
+}
+
+// accounts reads the receiving accounts of withdrawals from the vault. Each read is recorded as a disclosure,
+// so adapters only read an account right before sending it to their gateway.
+type accounts struct {
+	detokenizer vault.Detokenizer
+}
+
+// receivingAccount returns the account token stands for, for gateway to pay trx to
+func (a accounts) receivingAccount(gateway string, trx db.Transaction, token string) (string, error) {
+	if a.detokenizer == nil {
+		return "", errors.New("no vault configured to read receiving accounts from")
+	}
+	return a.detokenizer.Detokenize(token, vault.Access{
+		Gateway:       gateway,
+		UserID:        trx.UserID,
+		TransactionID: trx.ID,
+		Purpose:       "withdrawal",
+	})
+}
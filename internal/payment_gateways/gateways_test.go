@@ -0,0 +1,44 @@
+package gateways
+
+import (
+	"testing"
+
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingDetokenizer records the disclosures of a vault holding a single account
+type recordingDetokenizer struct {
+	accesses []vault.Access
+}
+
+func (d *recordingDetokenizer) Detokenize(token string, access vault.Access) (string, error) {
+	d.accesses = append(d.accesses, access)
+	return "GB33BUKB20201555555555", nil
+}
+
+func TestPaymentGatewayFromName_ReceivingAccounts(t *testing.T) {
+	trx := db.Transaction{ID: 3, UserID: 7}
+
+	for _, name := range []string{"stripe", "paypal"} {
+		detokenizer := new(recordingDetokenizer)
+		gateway, err := PaymentGatewayFromName(name, detokenizer)
+		require.NoError(t, err)
+
+		// adapters that do not send the account do not disclose it
+		require.NoError(t, gateway.RegisterWithdrawal(trx, "https://localhost/callback", "tok_abc"))
+		assert.Empty(t, detokenizer.accesses, name)
+
+		account, err := accounts{detokenizer}.receivingAccount(gateway.Name(), trx, "tok_abc")
+		require.NoError(t, err)
+		assert.Equal(t, "GB33BUKB20201555555555", account)
+		assert.Equal(t, []vault.Access{{Gateway: name, UserID: 7, TransactionID: 3, Purpose: "withdrawal"}}, detokenizer.accesses)
+	}
+
+	gateway, err := PaymentGatewayFromName("stripe", nil)
+	require.NoError(t, err)
+	_, err = gateway.(*Stripe).receivingAccount("stripe", trx, "tok_abc")
+	assert.Error(t, err, "no vault configured")
+}
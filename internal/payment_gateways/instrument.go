@@ -0,0 +1,59 @@
+package gateways
+
+import (
+	"context"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/metrics"
+	"github.com/ercross/payment_gateways/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Instrument returns gateway recording a span, child of the span in ctx, and latency and outcome metrics
+// for each call to the gateway. Like tracing.Repository, it is meant to be created for each request.
+func Instrument(ctx context.Context, gateway PaymentGateway) PaymentGateway {
+	return &instrumentedGateway{ctx: ctx, gateway: gateway}
+}
+
+type instrumentedGateway struct {
+	ctx     context.Context
+	gateway PaymentGateway
+}
+
+// call records operation, calling fn within its span
+func (g *instrumentedGateway) call(operation string, trx *db.Transaction, fn func() error) error {
+	attributes := []attribute.KeyValue{attribute.String("payment_gateway.name", g.gateway.Name())}
+	if trx != nil {
+		attributes = append(attributes, attribute.Int("transaction.id", trx.ID))
+	}
+	_, span := tracing.Start(g.ctx, "gateway."+operation, trace.SpanKindClient, attributes...)
+
+	start := time.Now()
+	err := fn()
+	metrics.ObserveGatewayCall(g.gateway.Name(), operation, time.Since(start), err)
+	tracing.End(span, err)
+	return err
+}
+
+func (g *instrumentedGateway) Name() string {
+	return g.gateway.Name()
+}
+
+func (g *instrumentedGateway) GenerateDepositCheckoutSessionData(trx db.Transaction, callbackUrl string) (sessionData any, err error) {
+	err = g.call("GenerateDepositCheckoutSessionData", &trx, func() error {
+		sessionData, err = g.gateway.GenerateDepositCheckoutSessionData(trx, callbackUrl)
+		return err
+	})
+	return sessionData, err
+}
+
+func (g *instrumentedGateway) RegisterWithdrawal(trx db.Transaction, callbackUrl, receivingAccountToken string) error {
+	return g.call("RegisterWithdrawal", &trx, func() error {
+		return g.gateway.RegisterWithdrawal(trx, callbackUrl, receivingAccountToken)
+	})
+}
+
+func (g *instrumentedGateway) CheckAvailability() error {
+	return g.call("CheckAvailability", nil, g.gateway.CheckAvailability)
+}
@@ -5,6 +5,7 @@ import (
 )
 
 type PayPal struct {
+	accounts
 }
 
 func (p *PayPal) Name() string {
@@ -19,6 +20,8 @@ func (p *PayPal) GenerateDepositCheckoutSessionData(trx db.Transaction, callback
 	return nil, nil
 }
 
-func (p *PayPal) RegisterWithdrawal(trx db.Transaction, callbackUrl, receivingAccount string) error {
+// RegisterWithdrawal does not send a request yet, so it does not read the receiving account from the vault either:
+// the request is to carry receivingAccount(p.Name(), trx, receivingAccountToken)
+func (p *PayPal) RegisterWithdrawal(trx db.Transaction, callbackUrl, receivingAccountToken string) error {
 	return nil
 }
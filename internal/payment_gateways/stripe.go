@@ -5,6 +5,7 @@ import (
 )
 
 type Stripe struct {
+	accounts
 }
 
 func (s *Stripe) Name() string {
@@ -22,6 +23,8 @@ func (s *Stripe) GenerateDepositCheckoutSessionData(trx db.Transaction, callback
 	}, nil
 }
 
-func (s *Stripe) RegisterWithdrawal(trx db.Transaction, callbackUrl, receivingAccount string) error {
+// RegisterWithdrawal does not send a request yet, so it does not read the receiving account from the vault either:
+// the request is to carry receivingAccount(s.Name(), trx, receivingAccountToken)
+func (s *Stripe) RegisterWithdrawal(trx db.Transaction, callbackUrl, receivingAccountToken string) error {
 	return nil
 }
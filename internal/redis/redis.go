@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/ercross/payment_gateways/internal/metrics"
 	"github.com/ercross/payment_gateways/internal/services"
 
 	"github.com/go-redsync/redsync/v4"
@@ -17,6 +18,9 @@ type Redis struct {
 	client *redis.Client
 	ctx    context.Context
 	rs     *redsync.Redsync
+
+	// lockTTL is how long locks are held when their holder does not release them
+	lockTTL time.Duration
 }
 
 func ConstructUserIDKey(userID int) string {
@@ -27,7 +31,8 @@ func ConstructTransactionIDKey(trxID int) string {
 	return fmt.Sprintf("transaction:%d", trxID)
 }
 
-func New(address, password string, db int) (*Redis, error) {
+// New connects to Redis. Distributed locks expire after lockTTL unless released
+func New(address, password string, db int, lockTTL time.Duration) (*Redis, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     address,
 		Password: password,
@@ -47,9 +52,10 @@ func New(address, password string, db int) (*Redis, error) {
 
 	pool := goredis.NewPool(client)
 	return &Redis{
-		client: client,
-		ctx:    context.Background(),
-		rs:     redsync.New(pool),
+		client:  client,
+		ctx:     context.Background(),
+		rs:      redsync.New(pool),
+		lockTTL: lockTTL,
 	}, nil
 }
 
@@ -57,6 +63,52 @@ func (r *Redis) Client() *redis.Client {
 	return r.client
 }
 
+// Close closes the connections to redis
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// Ping checks that redis is reachable
+func (r *Redis) Ping(ctx context.Context) error {
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to ping redis: %w", err)
+	}
+	return nil
+}
+
+// Publish sends message to the subscribers of channel
+func (r *Redis) Publish(ctx context.Context, channel string, message []byte) error {
+	return r.client.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe returns the messages published on channel, until ctx is cancelled
+func (r *Redis) Subscribe(ctx context.Context, channel string) <-chan []byte {
+	pubsub := r.client.Subscribe(ctx, channel)
+	messages := make(chan []byte)
+	go func() {
+		defer close(messages)
+		defer pubsub.Close()
+
+		received := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-received:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- []byte(message.Payload):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return messages
+}
+
 func (r *Redis) Save(key string, value interface{}, expiration time.Duration) error {
 	raw, err := json.Marshal(value)
 	if err != nil {
@@ -73,10 +125,13 @@ func (r *Redis) Get(ctx context.Context, key string, out interface{}) error {
 	value, err := r.client.Get(ctx, key).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
+			metrics.ObserveCacheLookup(metrics.CacheMiss)
 			return nil // cache miss
 		}
+		metrics.ObserveCacheLookup(metrics.CacheError)
 		return err
 	}
+	metrics.ObserveCacheLookup(metrics.CacheHit)
 	if err = json.Unmarshal([]byte(value), out); err != nil {
 		return err
 	}
@@ -88,10 +143,18 @@ func (r *Redis) Delete(key string) error {
 }
 
 func (r *Redis) AcquireLock(ctx context.Context, key string) (*Lock, error) {
-	mutex := r.rs.NewMutex(key)
+	mutex := r.rs.NewMutex(key, redsync.WithExpiry(r.lockTTL))
+	start := time.Now()
 	if err := mutex.LockContext(ctx); err != nil {
+		var taken *redsync.ErrTaken
+		if errors.Is(err, redsync.ErrFailed) || errors.As(err, &taken) {
+			metrics.ObserveLockAcquisition(metrics.LockContended, time.Since(start))
+		} else {
+			metrics.ObserveLockAcquisition(metrics.LockError, time.Since(start))
+		}
 		return nil, fmt.Errorf("failed to acquire lock: %w", err)
 	}
+	metrics.ObserveLockAcquisition(metrics.LockAcquired, time.Since(start))
 	return &Lock{mutex: mutex}, nil
 }
 
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// apiKeyTag starts every API key, so that leaked keys are easy to recognize and scan for
+const apiKeyTag = "pgk"
+
+var ErrMalformedAPIKey = errors.New("malformed API key")
+
+// GenerateAPIKey returns a new API key of the form pgk_<id>_<secret>, the prefix identifying it (pgk_<id>),
+// and the hash to store in place of the key
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err = rand.Read(id); err != nil {
+		return "", "", "", err
+	}
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = apiKeyTag + "_" + hex.EncodeToString(id)
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// APIKeyPrefix returns the public prefix identifying key
+func APIKeyPrefix(key string) (string, error) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyTag || parts[1] == "" || parts[2] == "" {
+		return "", ErrMalformedAPIKey
+	}
+	return parts[0] + "_" + parts[1], nil
+}
+
+// HashAPIKey returns the hex encoded SHA-256 of key.
+// API keys carry 256 bits of randomness, so a fast unsalted hash is sufficient.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAPIKey reports in constant time whether key matches hash
+func VerifyAPIKey(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(hash)) == 1
+}
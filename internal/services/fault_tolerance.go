@@ -1,7 +1,9 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"github.com/ercross/payment_gateways/internal/metrics"
 	"time"
 
 	"github.com/sony/gobreaker"
@@ -15,6 +17,17 @@ var cb = gobreaker.NewCircuitBreaker(gobreaker.Settings{
 	Timeout:     3 * time.Second,
 })
 
+func init() {
+	metrics.RegisterCircuitBreaker(cb.Name(), func() float64 {
+		return float64(cb.State())
+	})
+}
+
+// IsCircuitOpen reports whether err is returned by PublishWithCircuitBreaker without attempting the operation
+func IsCircuitOpen(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
+
 func PublishWithCircuitBreaker(operation func() error) error {
 	_, err := cb.Execute(func() (interface{}, error) {
 		return nil, operation()
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// LegacyKeyID identifies the key configured through the single ENCRYPTION_KEY setting
+const LegacyKeyID = "legacy"
+
+// BuiltinKeyID identifies the key data was encrypted with before encryption keys were configurable
+const BuiltinKeyID = "builtin"
+
+// builtinKey is the key hard-coded before encryption keys were configurable
+const builtinKey = "W-Dm='U]Pu@xk]GM"
+
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Key is an AES key identified by ID
+type Key struct {
+	ID string
+
+	// Material is the 16, 24 or 32 bytes AES-128, AES-192 or AES-256 key
+	Material []byte
+}
+
+// KeyProvider provides the keys data is encrypted and decrypted with.
+// Data is always encrypted with the primary key, and decrypted with the key it was encrypted with,
+// so that keys can be rotated without losing access to existing data.
+type KeyProvider interface {
+
+	// PrimaryKey returns the key new data is encrypted with
+	PrimaryKey() (Key, error)
+
+	// Key returns the key identified by id, or ErrUnknownKey
+	Key(id string) (Key, error)
+
+	// Keys returns all keys, the primary first
+	Keys() ([]Key, error)
+}
+
+// Keyring is a KeyProvider holding its keys in memory
+type Keyring struct {
+	primary string
+	keys    map[string]Key
+	order   []string
+}
+
+// NewKeyring returns a Keyring encrypting with the key identified by primary
+func NewKeyring(primary string, keys ...Key) (*Keyring, error) {
+	k := &Keyring{primary: primary, keys: make(map[string]Key, len(keys))}
+	for _, key := range keys {
+		if key.ID == "" || len(key.ID) > 255 {
+			return nil, fmt.Errorf("invalid key ID %q", key.ID)
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		switch len(key.Material) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("key %q must be 16, 24 or 32 bytes long, got %d", key.ID, len(key.Material))
+		}
+		k.keys[key.ID] = key
+		k.order = append(k.order, key.ID)
+	}
+	if _, ok := k.keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
+	}
+	return k, nil
+}
+
+func (k *Keyring) PrimaryKey() (Key, error) {
+	return k.keys[k.primary], nil
+}
+
+func (k *Keyring) Key(id string) (Key, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return Key{}, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+func (k *Keyring) Keys() ([]Key, error) {
+	keys := []Key{k.keys[k.primary]}
+	for _, id := range k.order {
+		if id != k.primary {
+			keys = append(keys, k.keys[id])
+		}
+	}
+	return keys, nil
+}
+
+// ParseKeyring parses keys formatted as id:base64-key pairs separated by commas, the first being the primary key
+func ParseKeyring(spec string) (*Keyring, error) {
+	var keys []Key
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key %q, expected id:base64-key", entry)
+		}
+		material, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		keys = append(keys, Key{ID: id, Material: material})
+	}
+	return NewKeyring(keys[0].ID, keys...)
+}
+
+// LoadKeyringFile reads a keyring from a JSON file formatted as
+//
+//	{"primary": "2024-06", "keys": {"2024-01": "<base64 key>", "2024-06": "<base64 key>"}}
+func LoadKeyringFile(path string) (*Keyring, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keyring: %w", err)
+	}
+	var file struct {
+		Primary string            `json:"primary"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err = json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("error parsing keyring: %w", err)
+	}
+
+	keys := make([]Key, 0, len(file.Keys))
+	for id, encoded := range file.Keys {
+		material, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		keys = append(keys, Key{ID: id, Material: material})
+	}
+	return NewKeyring(file.Primary, keys...)
+}
+
+// LoadKeyring returns the keyring read from file if set, else parsed from spec if set,
+// else made of legacyKey, a raw key identified by LegacyKeyID
+func LoadKeyring(file, spec, legacyKey string) (*Keyring, error) {
+	switch {
+	case file != "":
+		return LoadKeyringFile(file)
+	case spec != "":
+		return ParseKeyring(spec)
+	case legacyKey != "":
+		return NewKeyring(LegacyKeyID, Key{ID: LegacyKeyID, Material: []byte(legacyKey)})
+	default:
+		return nil, errors.New("no encryption key configured")
+	}
+}
+
+// WithBuiltinKey returns keyring along with the key hard-coded before encryption keys were configurable, so that
+// data encrypted with it remains readable until re-encrypted. The builtin key is never used to encrypt.
+func WithBuiltinKey(keyring *Keyring) (*Keyring, error) {
+	keys, _ := keyring.Keys()
+	for _, key := range keys {
+		if string(key.Material) == builtinKey {
+			return keyring, nil
+		}
+	}
+	return NewKeyring(keyring.primary, append(keys, Key{ID: BuiltinKeyID, Material: []byte(builtinKey)})...)
+}
+
+var (
+	keyProviderMu sync.RWMutex
+	keyProvider   KeyProvider
+	kms           KMS
+)
+
+// SetKeyProvider sets the keys MaskData and UnmaskData use
+func SetKeyProvider(provider KeyProvider) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	keyProvider = provider
+}
+
+// SetKMS makes MaskData use envelope encryption, wrapping data keys with kms.
+// Data encrypted with the keys of the KeyProvider remains decryptable. A nil kms disables envelope encryption.
+func SetKMS(k KMS) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	kms = k
+}
+
+// InitEncryptionKey makes key, a raw AES key, the only key MaskData and UnmaskData use
+func InitEncryptionKey(key string) {
+	keyring, err := NewKeyring(LegacyKeyID, Key{ID: LegacyKeyID, Material: []byte(key)})
+	if err != nil {
+		// keep failing on use, as an invalid key always did
+		SetKeyProvider(nil)
+		return
+	}
+	SetKeyProvider(keyring)
+}
+
+func currentKeyProvider() (KeyProvider, error) {
+	keyProviderMu.RLock()
+	defer keyProviderMu.RUnlock()
+	if keyProvider == nil {
+		return nil, errors.New("no encryption key configured")
+	}
+	return keyProvider, nil
+}
+
+func currentKMS() KMS {
+	keyProviderMu.RLock()
+	defer keyProviderMu.RUnlock()
+	return kms
+}
+
+// encryption identifies how MaskData currently encrypts data
+type encryption struct {
+	version byte
+	keyID   string
+}
+
+func currentEncryption() (encryption, error) {
+	if k := currentKMS(); k != nil {
+		return encryption{version: envelopeVersion, keyID: k.KeyID()}, nil
+	}
+	provider, err := currentKeyProvider()
+	if err != nil {
+		return encryption{}, err
+	}
+	key, err := provider.PrimaryKey()
+	if err != nil {
+		return encryption{}, err
+	}
+	return encryption{version: cipherTextVersion, keyID: key.ID}, nil
+}
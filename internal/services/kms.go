@@ -0,0 +1,213 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// KMS wraps and unwraps data encryption keys (DEKs) with key encryption keys (KEKs) it never discloses
+type KMS interface {
+
+	// KeyID returns the ID of the KEK new DEKs are wrapped with
+	KeyID() string
+
+	// WrapKey encrypts dek with the current KEK, returning the ID of that KEK along with the wrapped key
+	WrapKey(ctx context.Context, dek []byte) (kekID string, wrapped []byte, err error)
+
+	// UnwrapKey decrypts a DEK wrapped with the KEK identified by kekID
+	UnwrapKey(ctx context.Context, kekID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKMS is a KMS whose KEKs are the keys of a KeyProvider, such as a Keyring loaded from a file.
+// It is meant for development and tests; in production KEKs should never leave a dedicated KMS.
+type LocalKMS struct {
+	keys KeyProvider
+}
+
+func NewLocalKMS(keys KeyProvider) *LocalKMS {
+	return &LocalKMS{keys: keys}
+}
+
+// LoadLocalKMS returns a LocalKMS whose KEKs are read from a keyring file, see LoadKeyringFile
+func LoadLocalKMS(path string) (*LocalKMS, error) {
+	keyring, err := LoadKeyringFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewLocalKMS(keyring), nil
+}
+
+func (k *LocalKMS) KeyID() string {
+	key, err := k.keys.PrimaryKey()
+	if err != nil {
+		return ""
+	}
+	return key.ID
+}
+
+func (k *LocalKMS) WrapKey(_ context.Context, dek []byte) (string, []byte, error) {
+	kek, err := k.keys.PrimaryKey()
+	if err != nil {
+		return "", nil, err
+	}
+	aesGCM, err := newGCM(kek)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, err
+	}
+	return kek.ID, aesGCM.Seal(nonce, nonce, dek, []byte(kek.ID)), nil
+}
+
+func (k *LocalKMS) UnwrapKey(_ context.Context, kekID string, wrapped []byte) ([]byte, error) {
+	kek, err := k.keys.Key(kekID)
+	if err != nil {
+		return nil, err
+	}
+	aesGCM, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := aesGCM.NonceSize()
+	if len(wrapped) < nonceSize {
+		return nil, ErrInvalidCipherText
+	}
+	return aesGCM.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], []byte(kekID))
+}
+
+// HTTPKMSConfig configures an HTTPKMS
+type HTTPKMSConfig struct {
+
+	// URL is the base URL of the KMS, exposing POST <URL>/encrypt and POST <URL>/decrypt
+	URL string
+
+	// KeyID identifies the KEK new DEKs are wrapped with
+	KeyID string
+
+	// Token is sent as a bearer token when set
+	Token string
+
+	Timeout time.Duration
+}
+
+// HTTPKMS is a client of a KMS exposing the JSON API common to hosted key management services:
+//
+//	POST /encrypt {"key_id": "...", "plaintext": "<base64>"}       -> {"key_id": "...", "ciphertext": "<base64>"}
+//	POST /decrypt {"key_id": "...", "ciphertext": "<base64>"}      -> {"plaintext": "<base64>"}
+type HTTPKMS struct {
+	config HTTPKMSConfig
+	client *http.Client
+}
+
+func NewHTTPKMS(config HTTPKMSConfig) (*HTTPKMS, error) {
+	if config.URL == "" || config.KeyID == "" {
+		return nil, errors.New("HTTP KMS requires a URL and a key ID")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	return &HTTPKMS{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+type kmsRequest struct {
+	KeyID      string `json:"key_id"`
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+type kmsResponse struct {
+	KeyID      string `json:"key_id"`
+	Plaintext  string `json:"plaintext"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+func (k *HTTPKMS) KeyID() string {
+	return k.config.KeyID
+}
+
+func (k *HTTPKMS) WrapKey(ctx context.Context, dek []byte) (string, []byte, error) {
+	response, err := k.call(ctx, "encrypt", kmsRequest{KeyID: k.config.KeyID, Plaintext: base64.StdEncoding.EncodeToString(dek)})
+	if err != nil {
+		return "", nil, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(response.Ciphertext)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid KMS ciphertext: %w", err)
+	}
+	kekID := response.KeyID
+	if kekID == "" {
+		kekID = k.config.KeyID
+	}
+	return kekID, wrapped, nil
+}
+
+func (k *HTTPKMS) UnwrapKey(ctx context.Context, kekID string, wrapped []byte) ([]byte, error) {
+	response, err := k.call(ctx, "decrypt", kmsRequest{KeyID: kekID, Ciphertext: base64.StdEncoding.EncodeToString(wrapped)})
+	if err != nil {
+		return nil, err
+	}
+	dek, err := base64.StdEncoding.DecodeString(response.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KMS plaintext: %w", err)
+	}
+	return dek, nil
+}
+
+func (k *HTTPKMS) call(ctx context.Context, operation string, request kmsRequest) (kmsResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return kmsResponse{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(k.config.URL, "/")+"/"+operation, bytes.NewReader(body))
+	if err != nil {
+		return kmsResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if k.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.config.Token)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return kmsResponse{}, fmt.Errorf("error calling KMS %s: %w", operation, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return kmsResponse{}, fmt.Errorf("KMS %s failed with status %d: %s", operation, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var response kmsResponse
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&response); err != nil {
+		return kmsResponse{}, fmt.Errorf("error decoding KMS %s response: %w", operation, err)
+	}
+	return response, nil
+}
+
+// LoadKMS returns the local KMS whose KEKs are read from localKeysFile if set, else the HTTP KMS at url if set,
+// else nil, leaving data encrypted with the keyring alone
+func LoadKMS(localKeysFile, url, keyID, token string) (KMS, error) {
+	switch {
+	case localKeysFile != "":
+		return LoadLocalKMS(localKeysFile)
+	case url != "":
+		return NewHTTPKMS(HTTPKMSConfig{URL: url, KeyID: keyID, Token: token})
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskData_Envelope(t *testing.T) {
+	dataKey := Key{ID: "data", Material: []byte("0123456789abcdef")}
+	keyring, err := NewKeyring(dataKey.ID, dataKey)
+	require.NoError(t, err)
+	SetKeyProvider(keyring)
+	defer SetKMS(nil)
+
+	// data encrypted before enabling envelope encryption
+	direct, err := MaskData("secret")
+	require.NoError(t, err)
+
+	oldKEK := Key{ID: "kek-1", Material: []byte("fedcba9876543210fedcba9876543210")}
+	newKEK := Key{ID: "kek-2", Material: []byte("abcdef0123456789abcdef0123456789")}
+	before, err := NewKeyring(oldKEK.ID, oldKEK)
+	require.NoError(t, err)
+	SetKMS(NewLocalKMS(before))
+
+	masked, err := MaskData("secret")
+	require.NoError(t, err)
+	frame, ok := parseCipherText(masked)
+	require.True(t, ok)
+	assert.Equal(t, envelopeVersion, frame.version)
+	assert.Equal(t, oldKEK.ID, frame.keyID)
+
+	var unmasked string
+	require.NoError(t, UnmaskData(string(masked), &unmasked))
+	assert.Equal(t, "secret", unmasked)
+	require.NoError(t, UnmaskData(string(direct), &unmasked))
+	assert.Equal(t, "secret", unmasked)
+
+	// rotating the KEK re-encrypts data wrapped by the old one
+	after, err := NewKeyring(newKEK.ID, oldKEK, newKEK)
+	require.NoError(t, err)
+	SetKMS(NewLocalKMS(after))
+	reencrypted, changed, err := Reencrypt(masked)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	_, changed, err = Reencrypt(reencrypted)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	rotated, err := NewKeyring(newKEK.ID, newKEK)
+	require.NoError(t, err)
+	SetKMS(NewLocalKMS(rotated))
+	require.NoError(t, UnmaskData(string(reencrypted), &unmasked))
+	assert.Equal(t, "secret", unmasked)
+	assert.ErrorIs(t, UnmaskData(string(masked), &unmasked), ErrUnknownKey)
+}
+
+func TestHTTPKMS(t *testing.T) {
+	// the fake KMS wraps keys with a local one, checking the requests it receives
+	kek := Key{ID: "remote", Material: []byte("0123456789abcdef0123456789abcdef")}
+	keyring, err := NewKeyring(kek.ID, kek)
+	require.NoError(t, err)
+	local := NewLocalKMS(keyring)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var request kmsRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, kek.ID, request.KeyID)
+
+		var response kmsResponse
+		switch r.URL.Path {
+		case "/encrypt":
+			dek, err := base64.StdEncoding.DecodeString(request.Plaintext)
+			require.NoError(t, err)
+			kekID, wrapped, err := local.WrapKey(r.Context(), dek)
+			require.NoError(t, err)
+			response = kmsResponse{KeyID: kekID, Ciphertext: base64.StdEncoding.EncodeToString(wrapped)}
+		case "/decrypt":
+			wrapped, err := base64.StdEncoding.DecodeString(request.Ciphertext)
+			require.NoError(t, err)
+			dek, err := local.UnwrapKey(r.Context(), request.KeyID, wrapped)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			response.Plaintext = base64.StdEncoding.EncodeToString(dek)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	remote, err := NewHTTPKMS(HTTPKMSConfig{URL: server.URL + "/", KeyID: kek.ID, Token: "token"})
+	require.NoError(t, err)
+	dek := []byte("0123456789abcdef0123456789abcdef")
+	kekID, wrapped, err := remote.WrapKey(ctx, dek)
+	require.NoError(t, err)
+	assert.Equal(t, kek.ID, kekID)
+	unwrapped, err := remote.UnwrapKey(ctx, kekID, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+
+	_, err = remote.UnwrapKey(ctx, kekID, []byte("not a wrapped key"))
+	assert.ErrorContains(t, err, "status 400")
+
+	unauthorized, err := NewHTTPKMS(HTTPKMSConfig{URL: server.URL, KeyID: kek.ID})
+	require.NoError(t, err)
+	_, _, err = unauthorized.WrapKey(ctx, dek)
+	assert.ErrorContains(t, err, "status 401")
+}
@@ -1,35 +1,129 @@
 package services
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
-var cipherKey string
+// Ciphertexts produced by MaskData are framed as
+//
+//	magic (1 byte) | version (1 byte) | key ID length (1 byte) | key ID | [version 2 only] | nonce | AES-GCM ciphertext
+//
+// Version 1 ciphertexts are encrypted with the key identified by key ID.
+// Version 2 ciphertexts use envelope encryption: they are encrypted with a data key generated for each message,
+// which is stored wrapped by the KMS key identified by key ID, preceded by its length (2 bytes, big endian).
+//
+// The header, up to the nonce, is authenticated as additional data. Ciphertexts without the header predate key IDs;
+// they are decrypted by trying every key.
+const (
+	cipherTextMagic   byte = 0xE7
+	cipherTextVersion byte = 1
+	envelopeVersion   byte = 2
 
-func InitEncryptionKey(key string) {
-	cipherKey = key
-}
+	// dataKeySize is the size of the AES-256 data keys of envelope encryption
+	dataKeySize = 32
+
+	// kmsTimeout bounds the KMS calls of envelope encryption
+	kmsTimeout = 10 * time.Second
+)
 
-// MaskData encrypts plain text using AES-GCM
+var ErrInvalidCipherText = errors.New("invalid cipher text")
+
+// MaskData serializes data to JSON and encrypts it using AES-GCM with the primary key
 func MaskData(data any) ([]byte, error) {
 	raw, err := json.Marshal(data)
 	if err != nil {
 		return nil, fmt.Errorf("error serializing data: %w", err)
 	}
+	return encrypt(raw)
+}
+
+// UnmaskData decrypts data encrypted by MaskData into out, using the key it was encrypted with
+func UnmaskData(encryptedText string, out any) error {
+	raw, err := decrypt([]byte(encryptedText))
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("error deserialising data: %w", err)
+	}
+	return nil
+}
+
+// Reencrypt encrypts cipherText again with the primary key, reporting false when it already was
+func Reencrypt(cipherText []byte) ([]byte, bool, error) {
+	current, err := currentEncryption()
+	if err != nil {
+		return nil, false, err
+	}
+	if frame, ok := parseCipherText(cipherText); ok && frame.version == current.version && frame.keyID == current.keyID {
+		return cipherText, false, nil
+	}
 
-	keyBytes := []byte(cipherKey)
-	block, err := aes.NewCipher(keyBytes)
+	raw, err := decrypt(cipherText)
+	if err != nil {
+		return nil, false, err
+	}
+	reencrypted, err := encrypt(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	return reencrypted, true, nil
+}
+
+func encrypt(raw []byte) ([]byte, error) {
+	if kms := currentKMS(); kms != nil {
+		return encryptEnvelope(kms, raw)
+	}
+
+	provider, err := currentKeyProvider()
 	if err != nil {
 		return nil, err
 	}
+	key, err := provider.PrimaryKey()
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte{cipherTextMagic, cipherTextVersion, byte(len(key.ID))}, key.ID...)
+	return seal(key, header, raw)
+}
 
-	aesGCM, err := cipher.NewGCM(block)
+// encryptEnvelope encrypts raw with a new data key, stored wrapped by kms in the header
+func encryptEnvelope(kms KMS, raw []byte) ([]byte, error) {
+	dek := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+	kekID, wrapped, err := kms.WrapKey(ctx, dek)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping data key: %w", err)
+	}
+	if kekID == "" || len(kekID) > 255 || len(wrapped) > 0xffff {
+		return nil, errors.New("KMS returned an unsupported key ID or wrapped key")
+	}
+
+	header := append([]byte{cipherTextMagic, envelopeVersion, byte(len(kekID))}, kekID...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	return seal(Key{Material: dek}, header, raw)
+}
+
+// seal returns header followed by a nonce and raw encrypted with key, authenticating header
+func seal(key Key, header, raw []byte) ([]byte, error) {
+	aesGCM, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
@@ -40,46 +134,138 @@ func MaskData(data any) ([]byte, error) {
 		return nil, err
 	}
 
-	cipherText := aesGCM.Seal(nonce, nonce, raw, nil)
-
-	return cipherText, nil
+	cipherText := append(header[:len(header):len(header)], nonce...)
+	return aesGCM.Seal(cipherText, nonce, raw, header), nil
 }
 
-// UnmaskData decrypts AES-GCM encrypted text into out
-func UnmaskData(encryptedText string, out any) error {
-
-	keyBytes := []byte(cipherKey)
-	cipherText := []byte(encryptedText)
+func decrypt(cipherText []byte) ([]byte, error) {
+	frame, ok := parseCipherText(cipherText)
+	if !ok {
+		return decryptLegacy(cipherText)
+	}
 
-	block, err := aes.NewCipher(keyBytes)
+	raw, err := decryptFrame(frame)
 	if err != nil {
-		return err
+		// the header may be the first bytes of the nonce of a legacy cipher text
+		if legacy, legacyErr := decryptLegacy(cipherText); legacyErr == nil {
+			return legacy, nil
+		}
+		return nil, err
 	}
+	return raw, nil
+}
 
-	aesGCM, err := cipher.NewGCM(block)
+func decryptFrame(frame cipherTextFrame) ([]byte, error) {
+	var key Key
+	switch frame.version {
+	case envelopeVersion:
+		kms := currentKMS()
+		if kms == nil {
+			return nil, errors.New("no KMS configured to decrypt envelope encrypted data")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+		defer cancel()
+		dek, err := kms.UnwrapKey(ctx, frame.keyID, frame.wrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("error unwrapping data key: %w", err)
+		}
+		key = Key{Material: dek}
+	default:
+		provider, err := currentKeyProvider()
+		if err != nil {
+			return nil, err
+		}
+		if key, err = provider.Key(frame.keyID); err != nil {
+			return nil, err
+		}
+	}
+
+	aesGCM, err := newGCM(key)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// extract nonce from encrypted data
 	nonceSize := aesGCM.NonceSize()
-	if len(cipherText) < nonceSize {
-		return errors.New("invalid cipher text")
+	if len(frame.body) < nonceSize {
+		return nil, ErrInvalidCipherText
 	}
-	nonce, cipherText := cipherText[:nonceSize], cipherText[nonceSize:]
+	return aesGCM.Open(nil, frame.body[:nonceSize], frame.body[nonceSize:], frame.header)
+}
 
-	// Decrypt the data
-	raw, err := aesGCM.Open(nil, nonce, cipherText, nil)
+// decryptLegacy decrypts a cipher text without header by trying each key
+func decryptLegacy(cipherText []byte) ([]byte, error) {
+	provider, err := currentKeyProvider()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	keys, err := provider.Keys()
+	if err != nil {
+		return nil, err
 	}
 
-	if err = json.Unmarshal(raw, out); err != nil {
-		return fmt.Errorf("error deserialising data: %w", err)
+	for _, key := range keys {
+		aesGCM, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		nonceSize := aesGCM.NonceSize()
+		if len(cipherText) < nonceSize {
+			return nil, ErrInvalidCipherText
+		}
+		if raw, err := aesGCM.Open(nil, cipherText[:nonceSize], cipherText[nonceSize:], nil); err == nil {
+			return raw, nil
+		}
 	}
-	return nil
+	return nil, fmt.Errorf("%w: no key decrypts it", ErrInvalidCipherText)
 }
 
-func VerifyUserAuthenticatorCode(userID int, code string) error {
-	return nil
+// cipherTextFrame is a cipher text split into its parts
+type cipherTextFrame struct {
+	version    byte
+	keyID      string
+	wrappedKey []byte
+	header     []byte
+	body       []byte
+}
+
+// parseCipherText splits a framed cipher text into its parts
+func parseCipherText(cipherText []byte) (cipherTextFrame, bool) {
+	if len(cipherText) < 3 || cipherText[0] != cipherTextMagic {
+		return cipherTextFrame{}, false
+	}
+	frame := cipherTextFrame{version: cipherText[1]}
+	if frame.version != cipherTextVersion && frame.version != envelopeVersion {
+		return cipherTextFrame{}, false
+	}
+
+	end := 3 + int(cipherText[2])
+	if cipherText[2] == 0 || len(cipherText) < end {
+		return cipherTextFrame{}, false
+	}
+	frame.keyID = string(cipherText[3:end])
+
+	if frame.version == envelopeVersion {
+		if len(cipherText) < end+2 {
+			return cipherTextFrame{}, false
+		}
+		wrappedStart := end + 2
+		end = wrappedStart + int(binary.BigEndian.Uint16(cipherText[end:]))
+		if len(cipherText) < end {
+			return cipherTextFrame{}, false
+		}
+		frame.wrappedKey = cipherText[wrappedStart:end]
+	}
+
+	frame.header = cipherText[:end]
+	frame.body = cipherText[end:]
+	return frame, true
+}
+
+func newGCM(key Key) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.Material)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
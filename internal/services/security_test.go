@@ -0,0 +1,119 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskData_KeyRotation(t *testing.T) {
+	oldKey := Key{ID: "2024-01", Material: []byte("0123456789abcdef")}
+	newKey := Key{ID: "2024-06", Material: []byte("fedcba9876543210fedcba9876543210")}
+
+	before, err := NewKeyring(oldKey.ID, oldKey)
+	require.NoError(t, err)
+	SetKeyProvider(before)
+	masked, err := MaskData("secret")
+	require.NoError(t, err)
+
+	after, err := NewKeyring(newKey.ID, oldKey, newKey)
+	require.NoError(t, err)
+	SetKeyProvider(after)
+
+	// data encrypted with a retired key still decrypts
+	var unmasked string
+	require.NoError(t, UnmaskData(string(masked), &unmasked))
+	assert.Equal(t, "secret", unmasked)
+
+	reencrypted, changed, err := Reencrypt(masked)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	_, changed, err = Reencrypt(reencrypted)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// once re-encrypted, the retired key can be removed
+	rotated, err := NewKeyring(newKey.ID, newKey)
+	require.NoError(t, err)
+	SetKeyProvider(rotated)
+	require.NoError(t, UnmaskData(string(reencrypted), &unmasked))
+	assert.Equal(t, "secret", unmasked)
+	assert.ErrorIs(t, UnmaskData(string(masked), &unmasked), ErrUnknownKey)
+}
+
+// sealLegacy encrypts v with key as done before key IDs were introduced
+func sealLegacy(t *testing.T, key string, v any) []byte {
+	t.Helper()
+	block, err := aes.NewCipher([]byte(key))
+	require.NoError(t, err)
+	aesGCM, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	nonce := make([]byte, aesGCM.NonceSize())
+	_, err = rand.Read(nonce)
+	require.NoError(t, err)
+	raw, err := json.Marshal(v)
+	require.NoError(t, err)
+	return aesGCM.Seal(nonce, nonce, raw, nil)
+}
+
+func TestUnmaskData_Legacy(t *testing.T) {
+	InitEncryptionKey("0123456789abcdef")
+
+	// cipher text as produced before key IDs were introduced
+	legacy := sealLegacy(t, "0123456789abcdef", "secret")
+
+	var unmasked string
+	require.NoError(t, UnmaskData(string(legacy), &unmasked))
+	assert.Equal(t, "secret", unmasked)
+}
+
+func TestParseKeyring(t *testing.T) {
+	keyring, err := ParseKeyring("new:ZmVkY2JhOTg3NjU0MzIxMA==,old:MDEyMzQ1Njc4OWFiY2RlZg==")
+	require.NoError(t, err)
+	primary, err := keyring.PrimaryKey()
+	require.NoError(t, err)
+	assert.Equal(t, "new", primary.ID)
+
+	_, err = ParseKeyring("short:c2hvcnQ=")
+	assert.Error(t, err)
+}
+
+func TestWithBuiltinKey(t *testing.T) {
+	configured, err := NewKeyring(LegacyKeyID, Key{ID: LegacyKeyID, Material: []byte("QTLyhXOqRQNmgca4")})
+	require.NoError(t, err)
+	builtin := sealLegacy(t, builtinKey, "secret")
+
+	// data encrypted with the key built into earlier versions is lost without it
+	SetKeyProvider(configured)
+	var unmasked string
+	assert.ErrorIs(t, UnmaskData(string(builtin), &unmasked), ErrInvalidCipherText)
+
+	keyring, err := WithBuiltinKey(configured)
+	require.NoError(t, err)
+	SetKeyProvider(keyring)
+	require.NoError(t, UnmaskData(string(builtin), &unmasked))
+	assert.Equal(t, "secret", unmasked)
+
+	// the builtin key only decrypts, and re-encrypting moves data to the configured key
+	primary, err := keyring.PrimaryKey()
+	require.NoError(t, err)
+	assert.Equal(t, LegacyKeyID, primary.ID)
+	reencrypted, changed, err := Reencrypt(builtin)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	SetKeyProvider(configured)
+	require.NoError(t, UnmaskData(string(reencrypted), &unmasked))
+	assert.Equal(t, "secret", unmasked)
+
+	// a keyring already holding the builtin key is left as is
+	same, err := NewKeyring(LegacyKeyID, Key{ID: LegacyKeyID, Material: []byte(builtinKey)})
+	require.NoError(t, err)
+	withBuiltin, err := WithBuiltinKey(same)
+	require.NoError(t, err)
+	assert.Same(t, same, withBuiltin)
+}
@@ -0,0 +1,171 @@
+package settings
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Channel is the Redis channel changes are broadcast on
+const Channel = "payment_gateways:settings"
+
+// Broadcaster shares changes between instances
+type Broadcaster interface {
+	Publish(ctx context.Context, channel string, message []byte) error
+	Subscribe(ctx context.Context, channel string) <-chan []byte
+}
+
+// change is the message broadcast when an instance loads new settings
+type change struct {
+	Origin   string   `json:"origin"`
+	Settings Settings `json:"settings"`
+}
+
+// Reloader loads the settings from a YAML file whenever it changes, and broadcasts them to the other instances,
+// whose changes it applies in turn
+type Reloader struct {
+	path        string
+	rateLimit   RateLimit
+	store       *Store
+	broadcaster Broadcaster
+	interval    time.Duration
+	log         *logger.Logger
+
+	// origin identifies this instance in broadcasts
+	origin  string
+	modTime time.Time
+}
+
+// NewReloader loads the settings from the file at path if set, using rateLimit when the file sets none
+func NewReloader(
+	path string,
+	rateLimit RateLimit,
+	store *Store,
+	broadcaster Broadcaster,
+	interval time.Duration,
+	log *logger.Logger,
+) (*Reloader, error) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	r := &Reloader{
+		path:        path,
+		rateLimit:   rateLimit,
+		store:       store,
+		broadcaster: broadcaster,
+		interval:    interval,
+		log:         log,
+		origin:      hex.EncodeToString(id),
+	}
+	if path == "" {
+		return r, nil
+	}
+	if err := r.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Run applies the changes of the file and of the other instances until ctx is cancelled.
+// Settings that fail to load are reported and the previous ones kept in effect.
+func (r *Reloader) Run(ctx context.Context) {
+	messages := r.broadcaster.Subscribe(ctx, Channel)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.path == "" {
+				continue
+			}
+			if err := r.Reload(ctx); err != nil {
+				r.log.Error("error reloading settings", logger.NewField("Error", err.Error()), logger.NewField("File", r.path))
+			}
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			r.receive(message)
+		}
+	}
+}
+
+// Reload loads the file again if it changed since it was last loaded, and broadcasts the settings it changed
+func (r *Reloader) Reload(ctx context.Context) error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(r.modTime) {
+		return nil
+	}
+
+	content, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	next := Settings{RateLimit: r.rateLimit}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&next); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading settings file %s: %w", r.path, err)
+	}
+
+	changes, err := r.store.Apply(next)
+	if err != nil {
+		return fmt.Errorf("invalid settings in %s: %w", r.path, err)
+	}
+	r.modTime = info.ModTime()
+	if len(changes) == 0 {
+		return nil
+	}
+	r.log.Info("settings changed", logger.NewField("Origin", "file"), logger.NewField("Changes", strings.Join(changes, ",")))
+
+	message, err := json.Marshal(change{Origin: r.origin, Settings: next})
+	if err != nil {
+		return err
+	}
+	if err = r.broadcaster.Publish(ctx, Channel, message); err != nil {
+		return fmt.Errorf("error broadcasting settings: %w", err)
+	}
+	return nil
+}
+
+func (r *Reloader) receive(message []byte) {
+	var c change
+	if err := json.Unmarshal(message, &c); err != nil {
+		r.log.Warn("invalid settings broadcast", logger.ComponentRedis, logger.NewField("Error", err.Error()))
+		return
+	}
+	if c.Origin == r.origin {
+		return
+	}
+
+	changes, err := r.store.Apply(c.Settings)
+	if err != nil {
+		r.log.Warn("invalid settings broadcast", logger.ComponentRedis,
+			logger.NewField("Error", err.Error()), logger.NewField("Origin", c.Origin))
+		return
+	}
+	if len(changes) > 0 {
+		r.log.Info("settings changed", logger.NewField("Origin", c.Origin), logger.NewField("Changes", strings.Join(changes, ",")))
+	}
+}
@@ -0,0 +1,143 @@
+// Package settings holds the settings that can change while the server runs: the rate limit, how payments are routed
+// to gateways, and feature flags. Changes are loaded from a file, shared with the other instances through Redis,
+// and applied as a whole, so that a request never sees a mix of old and new settings.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Features that can be turned off, all enabled unless set to false
+const (
+	FeatureDeposits    = "deposits"
+	FeatureWithdrawals = "withdrawals"
+)
+
+var features = map[string]bool{FeatureDeposits: true, FeatureWithdrawals: true}
+
+type Settings struct {
+	RateLimit RateLimit       `yaml:"rate_limit" json:"rate_limit"`
+	Routing   Routing         `yaml:"routing" json:"routing"`
+	Features  map[string]bool `yaml:"features" json:"features"`
+}
+
+// RateLimit is how many payment requests each user may send per window
+type RateLimit struct {
+	Requests int           `yaml:"requests" json:"requests"`
+	Window   time.Duration `yaml:"window" json:"window"`
+}
+
+type Routing struct {
+
+	// Priorities lists by country ID the gateways to try in order, overriding the priorities stored in the database
+	Priorities map[int][]string `yaml:"priorities" json:"priorities,omitempty"`
+
+	// Disabled gateways are never selected
+	Disabled []string `yaml:"disabled" json:"disabled,omitempty"`
+}
+
+// Enabled reports whether feature is turned on
+func (s *Settings) Enabled(feature string) bool {
+	enabled, ok := s.Features[feature]
+	return !ok || enabled
+}
+
+// GatewayDisabled reports whether the gateway named name must not be selected
+func (s *Settings) GatewayDisabled(name string) bool {
+	for _, disabled := range s.Routing.Disabled {
+		if strings.EqualFold(disabled, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Settings) Validate() error {
+	var errs []error
+	if s.RateLimit.Requests <= 0 {
+		errs = append(errs, errors.New("rate_limit.requests must be positive"))
+	}
+	if s.RateLimit.Window <= 0 {
+		errs = append(errs, errors.New("rate_limit.window must be positive"))
+	}
+	for feature := range s.Features {
+		if !features[feature] {
+			errs = append(errs, fmt.Errorf("unknown feature %q", feature))
+		}
+	}
+	for countryID, gateways := range s.Routing.Priorities {
+		if len(gateways) == 0 {
+			errs = append(errs, fmt.Errorf("routing.priorities of country %d lists no gateway", countryID))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Changes returns the sections that differ between s and other
+func (s *Settings) Changes(other *Settings) []string {
+	var changes []string
+	if s.RateLimit != other.RateLimit {
+		changes = append(changes, "rate_limit")
+	}
+	if !reflect.DeepEqual(s.Routing, other.Routing) {
+		changes = append(changes, "routing")
+	}
+	if !reflect.DeepEqual(s.Features, other.Features) {
+		changes = append(changes, "features")
+	}
+	return changes
+}
+
+// Store holds the settings in effect
+type Store struct {
+	current atomic.Pointer[Settings]
+
+	// mu serializes changes, so that listeners are notified in the order changes are applied
+	mu        sync.Mutex
+	listeners []func(*Settings)
+}
+
+func NewStore(initial Settings) *Store {
+	s := new(Store)
+	s.current.Store(&initial)
+	return s
+}
+
+// Current returns the settings in effect, which must not be modified.
+// A request should read them once, so as to use the same settings throughout.
+func (s *Store) Current() *Settings {
+	return s.current.Load()
+}
+
+// OnChange calls listener with the settings in effect, then with every change applied
+func (s *Store) OnChange(listener func(*Settings)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
+	listener(s.current.Load())
+}
+
+// Apply puts next in effect unless invalid, and returns the sections it changed
+func (s *Store) Apply(next Settings) ([]string, error) {
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changes := s.current.Load().Changes(&next)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	s.current.Store(&next)
+	for _, listener := range s.listeners {
+		listener(&next)
+	}
+	return changes, nil
+}
@@ -0,0 +1,128 @@
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var defaultRateLimit = RateLimit{Requests: 3, Window: time.Minute}
+
+// broadcaster records published messages, and delivers those sent to its channel
+type broadcaster struct {
+	published [][]byte
+	messages  chan []byte
+}
+
+func (b *broadcaster) Publish(_ context.Context, _ string, message []byte) error {
+	b.published = append(b.published, message)
+	return nil
+}
+
+func (b *broadcaster) Subscribe(context.Context, string) <-chan []byte {
+	return b.messages
+}
+
+// writeSettings writes content to path with a modification time distinct from the previous write
+func writeSettings(t *testing.T, path, content string, modTime time.Time) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestStore_Apply(t *testing.T) {
+	store := NewStore(Settings{RateLimit: defaultRateLimit})
+
+	var applied []*Settings
+	store.OnChange(func(current *Settings) {
+		applied = append(applied, current)
+	})
+	require.Len(t, applied, 1)
+
+	changes, err := store.Apply(Settings{RateLimit: defaultRateLimit, Features: map[string]bool{FeatureWithdrawals: false}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"features"}, changes)
+	assert.Len(t, applied, 2)
+	assert.False(t, store.Current().Enabled(FeatureWithdrawals))
+	assert.True(t, store.Current().Enabled(FeatureDeposits))
+
+	// applying the same settings again notifies no one
+	changes, err = store.Apply(*store.Current())
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Len(t, applied, 2)
+
+	_, err = store.Apply(Settings{Features: map[string]bool{"refunds": true}})
+	assert.ErrorContains(t, err, "rate_limit.requests")
+	assert.ErrorContains(t, err, `unknown feature "refunds"`)
+	assert.False(t, store.Current().Enabled(FeatureWithdrawals), "invalid settings must not be applied")
+}
+
+func TestReloader_ReloadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.yaml")
+	modTime := time.Now().Add(-time.Hour)
+	writeSettings(t, path, "routing:\n  disabled: [paypal]\n", modTime)
+
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+	store := NewStore(Settings{RateLimit: defaultRateLimit})
+	b := &broadcaster{}
+	reloader, err := NewReloader(path, defaultRateLimit, store, b, time.Second, log)
+	require.NoError(t, err)
+	assert.True(t, store.Current().GatewayDisabled("PayPal"))
+	assert.Equal(t, defaultRateLimit, store.Current().RateLimit)
+	require.Len(t, b.published, 1)
+
+	writeSettings(t, path, "rate_limit:\n  requests: 10\n  window: 30s\nrouting:\n  priorities:\n    1: [stripe]\n", modTime.Add(time.Minute))
+	require.NoError(t, reloader.Reload(context.Background()))
+	assert.Equal(t, RateLimit{Requests: 10, Window: 30 * time.Second}, store.Current().RateLimit)
+	assert.Equal(t, []string{"stripe"}, store.Current().Routing.Priorities[1])
+	assert.False(t, store.Current().GatewayDisabled("paypal"))
+
+	var c change
+	require.Len(t, b.published, 2)
+	require.NoError(t, json.Unmarshal(b.published[1], &c))
+	assert.Equal(t, 10, c.Settings.RateLimit.Requests)
+
+	// an invalid file keeps the previous settings in effect
+	writeSettings(t, path, "rate_limit:\n  request: 5\n", modTime.Add(2*time.Minute))
+	assert.Error(t, reloader.Reload(context.Background()))
+	assert.Equal(t, 10, store.Current().RateLimit.Requests)
+	assert.Len(t, b.published, 2)
+}
+
+func TestReloader_AppliesBroadcasts(t *testing.T) {
+	log, err := logger.NewSilentLogger()
+	require.NoError(t, err)
+	store := NewStore(Settings{RateLimit: defaultRateLimit})
+	b := &broadcaster{messages: make(chan []byte)}
+	reloader, err := NewReloader("", defaultRateLimit, store, b, time.Hour, log)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reloader.Run(ctx)
+		close(done)
+	}()
+
+	own, err := json.Marshal(change{Origin: reloader.origin, Settings: Settings{RateLimit: RateLimit{Requests: 1, Window: time.Second}}})
+	require.NoError(t, err)
+	other, err := json.Marshal(change{Origin: "other", Settings: Settings{RateLimit: RateLimit{Requests: 7, Window: time.Minute}}})
+	require.NoError(t, err)
+
+	b.messages <- own
+	b.messages <- other
+	cancel()
+	<-done
+
+	// its own broadcasts are ignored
+	assert.Equal(t, 7, store.Current().RateLimit.Requests)
+	assert.Empty(t, b.published, "broadcasts received must not be broadcast again")
+}
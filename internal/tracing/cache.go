@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	cache "github.com/ercross/payment_gateways/internal/redis"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Cache returns c recording a span, child of the span in ctx, for each cache and lock operation.
+// Like Repository, it is meant to be created for each request.
+func Cache(ctx context.Context, c cache.DistributedCache) cache.DistributedCache {
+	return &tracedCache{ctx: ctx, cache: c}
+}
+
+type tracedCache struct {
+	ctx   context.Context
+	cache cache.DistributedCache
+}
+
+func (c *tracedCache) start(ctx context.Context, operation, key string) (context.Context, trace.Span) {
+	return Start(ctx, "redis."+operation, trace.SpanKindClient, semconv.DBSystemRedis,
+		semconv.DBOperationName(operation), attribute.String("cache.key", key))
+}
+
+func (c *tracedCache) Get(ctx context.Context, key string, out interface{}) error {
+	ctx, span := c.start(ctx, "Get", key)
+	err := c.cache.Get(ctx, key, out)
+
+	// a miss is an expected outcome rather than a failure
+	span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		End(span, nil)
+	} else {
+		End(span, err)
+	}
+	return err
+}
+
+func (c *tracedCache) Save(key string, value any, expiration time.Duration) error {
+	_, span := c.start(c.ctx, "Save", key)
+	err := c.cache.Save(key, value, expiration)
+	End(span, err)
+	return err
+}
+
+func (c *tracedCache) Delete(key string) error {
+	_, span := c.start(c.ctx, "Delete", key)
+	err := c.cache.Delete(key)
+	End(span, err)
+	return err
+}
+
+func (c *tracedCache) AcquireLock(ctx context.Context, key string) (*cache.Lock, error) {
+	ctx, span := c.start(ctx, "AcquireLock", key)
+	lock, err := c.cache.AcquireLock(ctx, key)
+	End(span, err)
+	return lock, err
+}
+
+func (c *tracedCache) ReleaseLock(lock *cache.Lock) error {
+	_, span := c.start(c.ctx, "ReleaseLock", "")
+	err := c.cache.ReleaseLock(lock)
+	End(span, err)
+	return err
+}
@@ -0,0 +1,186 @@
+package tracing
+
+import (
+	"context"
+	"github.com/ercross/payment_gateways/db"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Repository returns repo recording a span, child of the span in ctx, for each call.
+// db.Repository methods take no context, so it is meant to be created for each request.
+func Repository(ctx context.Context, repo db.Repository) db.Repository {
+	return &repository{ctx: ctx, repo: repo}
+}
+
+type repository struct {
+	ctx  context.Context
+	repo db.Repository
+}
+
+func (r *repository) start(operation string) trace.Span {
+	_, span := Start(r.ctx, "db."+operation, trace.SpanKindClient, semconv.DBSystemPostgreSQL, semconv.DBOperationName(operation))
+	return span
+}
+
+func (r *repository) CreateUser(user db.User) error {
+	span := r.start("CreateUser")
+	err := r.repo.CreateUser(user)
+	End(span, err)
+	return err
+}
+
+func (r *repository) GetUserByID(id int) (db.User, error) {
+	span := r.start("GetUserByID")
+	user, err := r.repo.GetUserByID(id)
+	End(span, err)
+	return user, err
+}
+
+func (r *repository) GetCountries() ([]db.Country, error) {
+	span := r.start("GetCountries")
+	countries, err := r.repo.GetCountries()
+	End(span, err)
+	return countries, err
+}
+
+func (r *repository) CreateTransaction(trx db.Transaction) (int, error) {
+	span := r.start("CreateTransaction")
+	id, err := r.repo.CreateTransaction(trx)
+	End(span, err)
+	return id, err
+}
+
+func (r *repository) InsertGatewayPriority(priority db.GatewayPriority) error {
+	span := r.start("InsertGatewayPriority")
+	err := r.repo.InsertGatewayPriority(priority)
+	End(span, err)
+	return err
+}
+
+func (r *repository) GetGatewayPriorities(countryID int) ([]db.GatewayPriority, error) {
+	span := r.start("GetGatewayPriorities")
+	priorities, err := r.repo.GetGatewayPriorities(countryID)
+	End(span, err)
+	return priorities, err
+}
+
+func (r *repository) GetGatewayByName(name string) (db.Gateway, error) {
+	span := r.start("GetGatewayByName")
+	gateway, err := r.repo.GetGatewayByName(name)
+	End(span, err)
+	return gateway, err
+}
+
+func (r *repository) GetUserCountryByUserID(userID int) (db.Country, error) {
+	span := r.start("GetUserCountryByUserID")
+	country, err := r.repo.GetUserCountryByUserID(userID)
+	End(span, err)
+	return country, err
+}
+
+func (r *repository) GetUserAccount(userID int) (*db.UserAccount, error) {
+	span := r.start("GetUserAccount")
+	account, err := r.repo.GetUserAccount(userID)
+	End(span, err)
+	return account, err
+}
+
+func (r *repository) UpdateUserBalance(userID int, amount float64) error {
+	span := r.start("UpdateUserBalance")
+	err := r.repo.UpdateUserBalance(userID, amount)
+	End(span, err)
+	return err
+}
+
+func (r *repository) GetTransactionByID(id int) (db.Transaction, error) {
+	span := r.start("GetTransactionByID")
+	trx, err := r.repo.GetTransactionByID(id)
+	End(span, err)
+	return trx, err
+}
+
+func (r *repository) ListTransactions(ctx context.Context, filter db.TransactionFilter, page db.TransactionPage) ([]db.Transaction, error) {
+	span := r.start("ListTransactions")
+	transactions, err := r.repo.ListTransactions(ctx, filter, page)
+	End(span, err)
+	return transactions, err
+}
+
+func (r *repository) GetBalanceAt(ctx context.Context, userID int, currency string, at time.Time) (float64, error) {
+	span := r.start("GetBalanceAt")
+	balance, err := r.repo.GetBalanceAt(ctx, userID, currency, at)
+	End(span, err)
+	return balance, err
+}
+
+func (r *repository) UpdateTransactionStatus(id int, newStatus string) error {
+	span := r.start("UpdateTransactionStatus")
+	err := r.repo.UpdateTransactionStatus(id, newStatus)
+	End(span, err)
+	return err
+}
+
+func (r *repository) CreateTransactionWithEvent(trx db.Transaction, buildEvent db.OutboxEventBuilder) (int, error) {
+	span := r.start("CreateTransactionWithEvent")
+	id, err := r.repo.CreateTransactionWithEvent(trx, buildEvent)
+	End(span, err)
+	return id, err
+}
+
+func (r *repository) UpdateTransactionStatusWithEvent(id int, newStatus string, event db.OutboxEvent) error {
+	span := r.start("UpdateTransactionStatusWithEvent")
+	err := r.repo.UpdateTransactionStatusWithEvent(id, newStatus, event)
+	End(span, err)
+	return err
+}
+
+func (r *repository) TransitionTransactionStatusWithEvent(id int, fromStatus, toStatus string, event db.OutboxEvent) error {
+	span := r.start("TransitionTransactionStatusWithEvent")
+	err := r.repo.TransitionTransactionStatusWithEvent(id, fromStatus, toStatus, event)
+	End(span, err)
+	return err
+}
+
+func (r *repository) ProcessOutboxBatch(ctx context.Context, limit int, policy db.OutboxRetryPolicy, publish func(db.OutboxEvent) error) (int, error) {
+	span := r.start("ProcessOutboxBatch")
+	sent, err := r.repo.ProcessOutboxBatch(ctx, limit, policy, publish)
+	End(span, err)
+	return sent, err
+}
+
+func (r *repository) ListDeadLetterEvents(ctx context.Context, filter db.DeadLetterFilter, limit int) ([]db.DeadLetterEvent, error) {
+	span := r.start("ListDeadLetterEvents")
+	events, err := r.repo.ListDeadLetterEvents(ctx, filter, limit)
+	End(span, err)
+	return events, err
+}
+
+func (r *repository) ProcessDeadLetterBatch(ctx context.Context, filter db.DeadLetterFilter, limit int, policy db.OutboxRetryPolicy, publish func(db.DeadLetterEvent) error) (int, error) {
+	span := r.start("ProcessDeadLetterBatch")
+	sent, err := r.repo.ProcessDeadLetterBatch(ctx, filter, limit, policy, publish)
+	End(span, err)
+	return sent, err
+}
+
+func (r *repository) GetGateways() ([]db.Gateway, error) {
+	span := r.start("GetGateways")
+	gateways, err := r.repo.GetGateways()
+	End(span, err)
+	return gateways, err
+}
+
+func (r *repository) AssignUserRole(userID int, role string) error {
+	span := r.start("AssignUserRole")
+	err := r.repo.AssignUserRole(userID, role)
+	End(span, err)
+	return err
+}
+
+func (r *repository) RevokeUserRole(userID int, role string) error {
+	span := r.start("RevokeUserRole")
+	err := r.repo.RevokeUserRole(userID, role)
+	End(span, err)
+	return err
+}
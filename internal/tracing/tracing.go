@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/ercross/payment_gateways"
+
+// propagator carries W3C trace context and baggage across HTTP requests and Kafka messages
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+type Config struct {
+	ServiceName string
+
+	// OTLPEndpoint is the URL of an OTLP/HTTP collector such as http://localhost:4318.
+	// When empty, spans are still created, so that trace context is propagated, but not exported.
+	OTLPEndpoint string
+
+	// SampleRatio is the fraction of traces started by this service that are recorded, none of them when zero.
+	// Traces started by callers are recorded if the caller recorded them.
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider and propagator. The returned function flushes and stops the provider.
+func Setup(ctx context.Context, config Config) (shutdown func(context.Context) error, err error) {
+	if config.ServiceName == "" {
+		config.ServiceName = "payment_gateways"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(config.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("error describing service: %w", err)
+	}
+
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(rootSampler(config.SampleRatio))),
+	}
+
+	if config.OTLPEndpoint != "" {
+		exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(config.OTLPEndpoint))
+		if err != nil {
+			return nil, fmt.Errorf("error creating OTLP exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown, nil
+}
+
+// rootSampler samples ratio of the traces started by this service, as OTEL_TRACES_SAMPLER_ARG does for the
+// traceidratio sampler
+func rootSampler(ratio float64) sdktrace.Sampler {
+	switch {
+	case ratio <= 0:
+		return sdktrace.NeverSample()
+	case ratio >= 1:
+		return sdktrace.AlwaysSample()
+	default:
+		return sdktrace.TraceIDRatioBased(ratio)
+	}
+}
+
+// NewInMemoryProvider returns a tracer provider recording every span to an in-memory exporter, for tests.
+// Install it with otel.SetTracerProvider.
+func NewInMemoryProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter), sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	return provider, exporter
+}
+
+// Tracer returns the tracer of the global tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts a span named name, child of the span in ctx if any
+func Start(ctx context.Context, name string, kind trace.SpanKind, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attributes...))
+}
+
+// End ends span, marking it as failed when err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the trace context of ctx to carrier
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	propagator.Inject(ctx, carrier)
+}
+
+// Extract returns ctx with the trace context read from carrier
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return propagator.Extract(ctx, carrier)
+}
+
+// TraceParent returns the W3C traceparent of the span in ctx, or an empty string when there is none.
+// It lets work deferred to another process, like outbox events, continue the trace.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// ContextWithTraceParent returns ctx with the remote span traceparent stands for as parent
+func ContextWithTraceParent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
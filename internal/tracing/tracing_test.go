@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ercross/payment_gateways/db"
+	cache "github.com/ercross/payment_gateways/internal/redis"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var exporter *tracetest.InMemoryExporter
+
+func TestMain(m *testing.M) {
+	var provider trace.TracerProvider
+	provider, exporter = NewInMemoryProvider()
+	otel.SetTracerProvider(provider)
+	os.Exit(m.Run())
+}
+
+func spanNamed(t *testing.T, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("no span named %q", name)
+	return tracetest.SpanStub{}
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter.Reset()
+
+	// the handler calls a downstream service, which must receive the trace context
+	var downstreamTraceParent string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstreamTraceParent = r.Header.Get("traceparent")
+	}))
+	defer downstream.Close()
+	client := &http.Client{Transport: NewTransport(nil)}
+
+	mux := chi.NewRouter()
+	mux.Use(Middleware)
+	mux.Get("/transactions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		repo := Repository(r.Context(), new(db.Mock))
+		_, _ = repo.GetTransactionByID(1)
+
+		_ = Cache(r.Context(), new(cache.Mock)).Get(r.Context(), "transaction_1", nil)
+
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, downstream.URL, nil)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	// the caller's trace is continued
+	const callerTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/transactions/1", nil)
+	req.Header.Set("traceparent", "00-"+callerTraceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	server := spanNamed(t, "GET /transactions/{id}")
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, callerTraceID, server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+
+	for _, name := range []string{"db.GetTransactionByID", "redis.Get", "GET"} {
+		child := spanNamed(t, name)
+		assert.Equal(t, server.SpanContext.SpanID(), child.Parent.SpanID(), name)
+		assert.Equal(t, trace.SpanKindClient, child.SpanKind, name)
+	}
+
+	clientSpan := spanNamed(t, "GET")
+	assert.Equal(t, "00-"+callerTraceID+"-"+clientSpan.SpanContext.SpanID().String()+"-01", downstreamTraceParent)
+}
+
+func TestTraceParent(t *testing.T) {
+	exporter.Reset()
+
+	assert.Empty(t, TraceParent(context.Background()))
+	assert.Equal(t, context.Background(), ContextWithTraceParent(context.Background(), ""))
+
+	// an outbox event recorded in a request is published in the same trace
+	ctx, request := Start(context.Background(), "request", trace.SpanKindServer)
+	traceparent := TraceParent(ctx)
+	request.End()
+
+	_, publish := Start(ContextWithTraceParent(context.Background(), traceparent), "publish", trace.SpanKindProducer)
+	publish.End()
+
+	span := spanNamed(t, "publish")
+	assert.Equal(t, request.SpanContext().TraceID(), span.SpanContext.TraceID())
+	assert.Equal(t, request.SpanContext().SpanID(), span.Parent.SpanID())
+}
+
+func TestRootSampler(t *testing.T) {
+	traceID := trace.TraceID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	sampled := func(ratio float64) bool {
+		result := rootSampler(ratio).ShouldSample(sdktrace.SamplingParameters{TraceID: traceID, Name: "GET /"})
+		return result.Decision == sdktrace.RecordAndSample
+	}
+
+	// OTEL_TRACES_SAMPLER_ARG=0 samples nothing
+	assert.False(t, sampled(0))
+	assert.True(t, sampled(1))
+	assert.False(t, sampled(0.5), "the trace ID is above the ratio")
+}
@@ -0,0 +1,124 @@
+// Package vault replaces sensitive values, such as the accounts withdrawals are paid to, with opaque tokens.
+// Values are stored encrypted and are only disclosed to payment gateway adapters, each disclosure being audited.
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/services"
+	"strings"
+)
+
+// TokenPrefix starts every token, telling them apart from the values they stand for
+const TokenPrefix = "tok_"
+
+var (
+	ErrUnknownToken = errors.New("unknown vault token")
+	ErrAccessDenied = errors.New("vault token does not belong to the transaction user")
+)
+
+// Store persists vault entries and their accesses
+type Store interface {
+	CreateVaultEntry(entry db.VaultEntry) error
+	AccessVaultEntry(access db.VaultAccess) (db.VaultEntry, error)
+}
+
+// Tokenizer replaces sensitive values with tokens
+type Tokenizer interface {
+
+	// Tokenize stores value on behalf of userID and returns the token standing for it
+	Tokenize(userID int, value string) (string, error)
+}
+
+// Detokenizer discloses the values tokens stand for.
+// It is only given to payment gateway adapters, which need the actual values to process transactions.
+type Detokenizer interface {
+
+	// Detokenize returns the value token stands for, provided it was tokenized on behalf of access.UserID
+	Detokenize(token string, access Access) (string, error)
+}
+
+// Access describes why a value is disclosed
+type Access struct {
+
+	// Gateway is the name of the payment gateway the value is disclosed to
+	Gateway       string
+	UserID        int
+	TransactionID int
+	Purpose       string
+}
+
+// Vault is both the Tokenizer and the Detokenizer
+type Vault struct {
+	store Store
+	log   *logger.Logger
+}
+
+func NewVault(store Store, log *logger.Logger) *Vault {
+	return &Vault{store: store, log: log}
+}
+
+func (v *Vault) Tokenize(userID int, value string) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", fmt.Errorf("error generating vault token: %w", err)
+	}
+	encrypted, err := services.MaskData(value)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting vault value: %w", err)
+	}
+	if err = v.store.CreateVaultEntry(db.VaultEntry{Token: token, UserID: userID, Value: encrypted}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (v *Vault) Detokenize(token string, access Access) (string, error) {
+	fields := []logger.Field{
+		logger.ComponentAudit, logger.NewField("Vault-Token", token), logger.NewField("Gateway", access.Gateway),
+		logger.NewField("User-ID", access.UserID), logger.NewField("Transaction-ID", access.TransactionID),
+		logger.NewField("Purpose", access.Purpose),
+	}
+
+	entry, err := v.store.AccessVaultEntry(db.VaultAccess{
+		Token:         token,
+		Accessor:      access.Gateway,
+		TransactionID: access.TransactionID,
+		Purpose:       access.Purpose,
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrDataNotFound) {
+			v.log.Warn("detokenization of unknown token", fields...)
+			return "", ErrUnknownToken
+		}
+		return "", err
+	}
+	if entry.UserID != access.UserID {
+		v.log.Warn("detokenization denied", fields...)
+		return "", ErrAccessDenied
+	}
+
+	var value string
+	if err = services.UnmaskData(string(entry.Value), &value); err != nil {
+		return "", fmt.Errorf("error decrypting vault value: %w", err)
+	}
+	v.log.Info("value detokenized", fields...)
+	return value, nil
+}
+
+// IsToken reports whether s looks like a vault token
+func IsToken(s string) bool {
+	return strings.HasPrefix(s, TokenPrefix)
+}
+
+func generateToken() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return TokenPrefix + hex.EncodeToString(raw), nil
+}
@@ -0,0 +1,65 @@
+package vault
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ercross/payment_gateways/db"
+	"github.com/ercross/payment_gateways/internal/logger"
+	"github.com/ercross/payment_gateways/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	services.InitEncryptionKey("0123456789abcdef")
+	os.Exit(m.Run())
+}
+
+// memoryStore is an in-memory Store recording accesses
+type memoryStore struct {
+	entries  map[string]db.VaultEntry
+	accesses []db.VaultAccess
+}
+
+func (s *memoryStore) CreateVaultEntry(entry db.VaultEntry) error {
+	s.entries[entry.Token] = entry
+	return nil
+}
+
+func (s *memoryStore) AccessVaultEntry(access db.VaultAccess) (db.VaultEntry, error) {
+	entry, ok := s.entries[access.Token]
+	if !ok {
+		return db.VaultEntry{}, db.ErrDataNotFound
+	}
+	s.accesses = append(s.accesses, access)
+	return entry, nil
+}
+
+func TestVault(t *testing.T) {
+	log, _ := logger.NewSilentLogger()
+	store := &memoryStore{entries: make(map[string]db.VaultEntry)}
+	v := NewVault(store, log)
+
+	token, err := v.Tokenize(1, "GB33BUKB20201555555555")
+	require.NoError(t, err)
+	assert.True(t, IsToken(token))
+	assert.NotContains(t, string(store.entries[token].Value), "GB33BUKB20201555555555")
+
+	other, err := v.Tokenize(1, "GB33BUKB20201555555555")
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+
+	value, err := v.Detokenize(token, Access{Gateway: "stripe", UserID: 1, TransactionID: 7, Purpose: "withdrawal"})
+	require.NoError(t, err)
+	assert.Equal(t, "GB33BUKB20201555555555", value)
+	require.Len(t, store.accesses, 1)
+	assert.Equal(t, db.VaultAccess{Token: token, Accessor: "stripe", TransactionID: 7, Purpose: "withdrawal"}, store.accesses[0])
+
+	_, err = v.Detokenize(token, Access{Gateway: "stripe", UserID: 2, TransactionID: 8, Purpose: "withdrawal"})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+
+	_, err = v.Detokenize(TokenPrefix+strings.Repeat("0", 32), Access{Gateway: "stripe", UserID: 1})
+	assert.ErrorIs(t, err, ErrUnknownToken)
+}